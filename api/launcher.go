@@ -7,12 +7,17 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/SuzukiHonoka/spaceship/v2/internal/dns"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/http"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/redir"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/socks"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/client"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/server"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/tun"
 	"github.com/SuzukiHonoka/spaceship/v2/pkg/config"
 	"github.com/SuzukiHonoka/spaceship/v2/pkg/logger"
 	"github.com/google/uuid"
@@ -23,6 +28,14 @@ type Launcher struct {
 	sigStop             chan struct{}
 	skipInternalLogging bool
 	stopOnce            sync.Once
+	// tunFD is a TUN device opened by the host app, see SetTunFD
+	tunFD int
+
+	// reload state, see Reload
+	reloadMu   sync.Mutex
+	configPath string
+	cfg        *config.MixedConfig
+	server     *server.Server
 }
 
 func NewLauncher() *Launcher {
@@ -35,6 +48,13 @@ func (l *Launcher) SkipInternalLogging() {
 	l.skipInternalLogging = true
 }
 
+// SetTunFD makes the client serve the TUN device fd, opened by the host app
+// as mobile platforms require, in place of the configured one. The fd stays
+// owned by the caller. Call it before launching.
+func (l *Launcher) SetTunFD(fd int) {
+	l.tunFD = fd
+}
+
 func (l *Launcher) launchServer(ctx context.Context, cfg *config.MixedConfig) error {
 	log.Println("server starting")
 
@@ -46,6 +66,15 @@ func (l *Launcher) launchServer(ctx context.Context, cfg *config.MixedConfig) er
 		return fmt.Errorf("create server failed: %w", err)
 	}
 
+	// restore per-user traffic counters
+	if cfg.StateFile != "" {
+		if err = s.LoadState(cfg.StateFile); err != nil {
+			return fmt.Errorf("load state failed: %w", err)
+		}
+	}
+	l.setServer(s)
+	defer l.setServer(nil)
+
 	errGroup.Go(func() error {
 		if err := s.ListenAndServe(cfg.Listen); err != nil {
 			return fmt.Errorf("serve rpc failed: %w", err)
@@ -55,6 +84,10 @@ func (l *Launcher) launchServer(ctx context.Context, cfg *config.MixedConfig) er
 	errGroup.Go(func() error {
 		return l.listenSignal(ctx)
 	})
+	errGroup.Go(func() error {
+		l.listenReload(ctx)
+		return nil
+	})
 
 	err = errGroup.Wait()
 	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, ErrSignalArrived) {
@@ -75,7 +108,22 @@ func (l *Launcher) launchClient(ctx context.Context, cfg *config.MixedConfig) er
 	defer client.Destroy()
 
 	// initialize pool
-	if err := client.Init(cfg.ServerAddr, cfg.Host, cfg.EnableTLS, cfg.Mux, cfg.CAs); err != nil {
+	var upstreams []client.Upstream
+	if cfg.ServerAddr != "" {
+		upstreams = append(upstreams, client.Upstream{Addr: cfg.ServerAddr, Host: cfg.Host})
+	}
+	for _, s := range cfg.Servers {
+		host := s.Host
+		if host == "" {
+			host = cfg.Host
+		}
+		upstreams = append(upstreams, client.Upstream{Addr: s.Addr, Host: host, Priority: s.Priority})
+	}
+	balance, err := client.ParseBalance(cfg.Balance)
+	if err != nil {
+		return err
+	}
+	if err := client.InitUpstreams(upstreams, balance, cfg.EnableTLS, cfg.Mux, cfg.CAs); err != nil {
 		return fmt.Errorf("init client failed: %w", err)
 	}
 
@@ -111,14 +159,15 @@ func (l *Launcher) launchClient(ctx context.Context, cfg *config.MixedConfig) er
 	// create socks server for unix socket
 	if cfg.ListenSocksUnix != "" {
 		// support Linux abstract namespace
-		if cfg.ListenSocksUnix[0] != '/' {
-			cfg.ListenSocksUnix = "\x00" + cfg.ListenSocksUnix
+		socksUnix := cfg.ListenSocksUnix
+		if socksUnix[0] != '/' {
+			socksUnix = "\x00" + socksUnix
 		}
 		socksCfg := &socks.Config{Credentials: basicAuth}
 		s := socks.New(ctx, socksCfg)
 
 		errGroup.Go(func() error {
-			if err := s.ListenAndServe("unix", cfg.ListenSocksUnix); err != nil {
+			if err := s.ListenAndServe("unix", socksUnix); err != nil {
 				return fmt.Errorf("serve unix socks failed: %w", err)
 			}
 			return nil
@@ -138,9 +187,82 @@ func (l *Launcher) launchClient(ctx context.Context, cfg *config.MixedConfig) er
 		})
 	}
 
+	// create transparent proxy servers
+	for _, inbound := range []struct {
+		addr string
+		mode redir.Mode
+	}{
+		{cfg.ListenRedir, redir.ModeRedirect},
+		{cfg.ListenTProxy, redir.ModeTProxy},
+	} {
+		if inbound.addr == "" {
+			continue
+		}
+		r := redir.New(ctx, &redir.Config{Mode: inbound.mode})
+
+		errGroup.Go(func() error {
+			if err := r.ListenAndServe("tcp", inbound.addr); err != nil {
+				return fmt.Errorf("serve %s failed: %w", inbound.mode, err)
+			}
+			return nil
+		})
+	}
+
+	// create tun server
+	if cfg.Tun != nil || l.tunFD > 0 {
+		tunCfg := &tun.Config{FD: l.tunFD}
+		if cfg.Tun != nil {
+			tunCfg.Name = cfg.Tun.Name
+			tunCfg.MTU = cfg.Tun.MTU
+		}
+		t := tun.New(ctx, tunCfg)
+
+		errGroup.Go(func() error {
+			if err := t.Serve(); err != nil {
+				return fmt.Errorf("serve tun failed: %w", err)
+			}
+			return nil
+		})
+	}
+
 	// create dns server
 	if cfg.ListenDns != "" {
-		dnsSrv, err := dns.NewServer(cfg.ListenDns, cfg.BlockIPv6DNS)
+		var cacheConfig dns.CacheConfig
+		if c := cfg.DNSCache; c != nil {
+			cacheConfig = dns.CacheConfig{
+				Disable:     c.Disable,
+				Size:        c.Size,
+				MinTTL:      time.Duration(c.MinTTL) * time.Second,
+				MaxTTL:      time.Duration(c.MaxTTL) * time.Second,
+				NegativeTTL: time.Duration(c.NegativeTTL) * time.Second,
+			}
+		}
+		split := dns.SplitConfig{Block: dns.BlockMode(cfg.DNSBlock)}
+		direct := cfg.DNSDirect
+		if direct == nil {
+			direct = cfg.DNS
+		}
+		if direct != nil && direct.Server != "" {
+			c, err := direct.NewClient()
+			if err != nil {
+				return fmt.Errorf("create direct dns client failed: %w", err)
+			}
+			split.Direct = c
+		}
+		if f := cfg.FakeIP; f != nil {
+			r := f.Range
+			if r == "" {
+				r = fakeip.DefaultRange
+			}
+			pool, err := fakeip.New(r, f.Range6, f.Size)
+			if err != nil {
+				return fmt.Errorf("create fake ip pool failed: %w", err)
+			}
+			fakeip.SetDefault(pool)
+			defer fakeip.SetDefault(nil)
+			split.FakeIP = pool
+		}
+		dnsSrv, err := dns.NewServer(cfg.ListenDns, cfg.BlockIPv6DNS, cacheConfig, split)
 		if err != nil {
 			return fmt.Errorf("create dns server failed: %w", err)
 		}
@@ -157,9 +279,13 @@ func (l *Launcher) launchClient(ctx context.Context, cfg *config.MixedConfig) er
 	errGroup.Go(func() error {
 		return l.listenSignal(ctx)
 	})
+	errGroup.Go(func() error {
+		l.listenReload(ctx)
+		return nil
+	})
 
 	// blocks main
-	err := errGroup.Wait()
+	err = errGroup.Wait()
 	if err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, ErrSignalArrived) {
 		return fmt.Errorf("inbound process error: %w", err)
 	}
@@ -176,11 +302,24 @@ func (l *Launcher) Launch(cfg *config.MixedConfig) error {
 	if err := cfg.Apply(); err != nil {
 		return err
 	}
+	l.setConfig(cfg)
+	defer l.setConfig(nil)
+
+	// named outbounds
+	closeOutbounds, err := cfg.SetupOutbounds()
+	if err != nil {
+		return fmt.Errorf("setup outbounds failed: %w", err)
+	}
+	defer closeOutbounds()
 
 	// main context
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// keep remote rule sets and route-ext files fresh
+	go router.RefreshRuleSets(ctx)
+	go router.WatchRouteFiles(ctx)
+
 	// switch role
 	switch cfg.Role {
 	case config.RoleServer:
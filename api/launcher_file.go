@@ -11,5 +11,8 @@ func (l *Launcher) LaunchFromFile(path string) error {
 	if err != nil {
 		return fmt.Errorf("load config from %s: %w", path, err)
 	}
+	l.reloadMu.Lock()
+	l.configPath = path
+	l.reloadMu.Unlock()
 	return l.Launch(m)
 }
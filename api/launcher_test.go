@@ -1,6 +1,7 @@
 package api
 
 import (
+	"errors"
 	"net"
 	"os"
 	"path/filepath"
@@ -8,10 +9,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
 	"github.com/SuzukiHonoka/spaceship/v2/pkg/config"
 	"github.com/SuzukiHonoka/spaceship/v2/pkg/config/client"
 	"github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+	"github.com/miekg/dns"
 )
 
 const testUserUUID = "6f1a6bb5-30f1-4a2e-9d0e-3a1c5f2b7e10"
@@ -283,3 +286,106 @@ func TestStopIdempotent(t *testing.T) {
 	l.Stop()
 	l.Stop() // must not panic on double close of sigStop
 }
+
+func TestLauncherReload(t *testing.T) {
+	t.Cleanup(transport.EnableIPv6)
+
+	l := NewLauncher()
+	l.SkipInternalLogging()
+	if _, err := l.Reload(); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("Reload() before launch = %v, want ErrNotRunning", err)
+	}
+
+	lnAddr := pickFreeAddr(t)
+	raw := `{"role":"server","listen":"` + lnAddr + `","users":[{"uuid":"` + testUserUUID + `"}]}`
+	path := filepath.Join(t.TempDir(), "cfg.json")
+	if err := os.WriteFile(path, []byte(raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- l.LaunchFromFile(path) }()
+	waitDialable(t, lnAddr)
+	t.Cleanup(func() {
+		l.Stop()
+		select {
+		case <-done:
+		case <-time.After(3 * time.Second):
+			t.Error("launcher did not stop")
+		}
+	})
+
+	reloaded := `{"role":"server","listen":"127.0.0.1:1","users":[{"uuid":"` + testUserUUID + `"},{"uuid":"added"}]}`
+	if err := os.WriteFile(path, []byte(reloaded), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := l.Reload()
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if len(changes.UsersAdded) != 1 || changes.UsersAdded[0] != "added" {
+		t.Errorf("UsersAdded = %v, want [added]", changes.UsersAdded)
+	}
+	if len(changes.RestartRequired) != 1 || changes.RestartRequired[0] != "listen" {
+		t.Errorf("RestartRequired = %v, want [listen]", changes.RestartRequired)
+	}
+	// the listener is left alone
+	waitDialable(t, lnAddr)
+
+	if err = os.WriteFile(path, []byte(`{"role":"client"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = l.Reload(); err == nil {
+		t.Fatal("Reload() accepted a role change")
+	}
+}
+
+func TestLauncherStopUninstallsFakeIP(t *testing.T) {
+	t.Cleanup(transport.EnableIPv6)
+
+	serverAddr, stopServer := startTestServer(t)
+	t.Cleanup(stopServer)
+
+	dnsAddr := pickFreeAddr(t)
+	launcher := NewLauncher()
+	launcher.SkipInternalLogging()
+	cfg := &config.MixedConfig{
+		Role: config.RoleClient,
+		Client: &client.Client{
+			ServerAddr: serverAddr,
+			UUID:       testUserUUID,
+			ListenDns:  dnsAddr,
+			Mux:        1,
+			FakeIP:     &client.FakeIP{},
+		},
+		Server: &server.Server{},
+	}
+	done := make(chan error, 1)
+	go func() { done <- launcher.Launch(cfg) }()
+	waitDialable(t, dnsAddr)
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	c := &dns.Client{Net: "tcp", Timeout: 3 * time.Second}
+	r, _, err := c.Exchange(m, dnsAddr)
+	if err != nil {
+		t.Fatalf("query fake ip: %v", err)
+	}
+	if len(r.Answer) != 1 {
+		t.Fatalf("answer = %v, want one fake address", r.Answer)
+	}
+	fake := r.Answer[0].(*dns.A).A.String()
+	if name, ok := fakeip.Lookup(fake); !ok || name != "example.com" {
+		t.Fatalf("Lookup(%s) = %q, %t while running", fake, name, ok)
+	}
+
+	launcher.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Launcher.Stop() did not stop client mode")
+	}
+	if name, ok := fakeip.Lookup(fake); ok {
+		t.Errorf("Lookup(%s) = %q after the launcher stopped", fake, name)
+	}
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/server"
+	"github.com/SuzukiHonoka/spaceship/v2/pkg/config"
+	"github.com/SuzukiHonoka/spaceship/v2/pkg/logger"
+)
+
+var (
+	ErrNotRunning   = errors.New("launcher is not running")
+	ErrNoConfigFile = errors.New("launcher was not started from a config file")
+)
+
+func (l *Launcher) setConfig(cfg *config.MixedConfig) {
+	l.reloadMu.Lock()
+	l.cfg = cfg
+	l.reloadMu.Unlock()
+}
+
+func (l *Launcher) setServer(s *server.Server) {
+	l.reloadMu.Lock()
+	l.server = s
+	l.reloadMu.Unlock()
+}
+
+// Reload re-reads the config file the launcher was started from and applies
+// it in place: routes and server users are swapped atomically, while tunnels
+// and inbound sessions that are already established keep running. Fields that
+// need a restart are reported in the returned changes and left as they were.
+func (l *Launcher) Reload() (*config.Changes, error) {
+	l.reloadMu.Lock()
+	defer l.reloadMu.Unlock()
+
+	if l.cfg == nil {
+		return nil, ErrNotRunning
+	}
+	if l.configPath == "" {
+		return nil, ErrNoConfigFile
+	}
+
+	next, err := config.NewFromConfigFile(l.configPath)
+	if err != nil {
+		return nil, fmt.Errorf("load config from %s: %w", l.configPath, err)
+	}
+	if l.skipInternalLogging {
+		next.LogMode = logger.ModeSkip
+	}
+
+	changes, err := l.cfg.PrepareReload(next)
+	if err != nil {
+		return nil, err
+	}
+	if next.Role == config.RoleServer && len(next.Users) == 0 {
+		return nil, errors.New("users can not be empty")
+	}
+
+	// everything is prepared before anything is swapped, so a rejected
+	// reload leaves the running config whole
+	prepared, err := next.Prepare()
+	if err != nil {
+		return nil, fmt.Errorf("apply config: %w", err)
+	}
+	var applyUsers func()
+	if l.server != nil {
+		if applyUsers, err = l.server.PrepareUsers(next.Users, prepared.Policy); err != nil {
+			return nil, fmt.Errorf("apply users: %w", err)
+		}
+	}
+	prepared.Apply()
+	if applyUsers != nil {
+		applyUsers()
+	}
+	l.cfg = next
+	logChanges(changes)
+	return changes, nil
+}
+
+// listenReload reloads the config on SIGHUP until ctx is done.
+func (l *Launcher) listenReload(ctx context.Context) {
+	sys := make(chan os.Signal, 1)
+	signal.Notify(sys, syscall.SIGHUP)
+	defer signal.Stop(sys)
+	for {
+		select {
+		case <-sys:
+			if _, err := l.Reload(); err != nil {
+				log.Printf("reload failed: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func logChanges(changes *config.Changes) {
+	if len(changes.Applied) == 0 {
+		log.Println("config reloaded, nothing changed")
+	} else {
+		log.Printf("config reloaded, applied: %s", strings.Join(changes.Applied, ", "))
+	}
+	if len(changes.UsersAdded) > 0 || len(changes.UsersRemoved) > 0 {
+		log.Printf("users added: %d, removed: %d", len(changes.UsersAdded), len(changes.UsersRemoved))
+	}
+	if len(changes.RestartRequired) > 0 {
+		log.Printf("restart required to apply: %s", strings.Join(changes.RestartRequired, ", "))
+	}
+}
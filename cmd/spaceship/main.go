@@ -66,6 +66,7 @@ func main() {
 
 	// Start management HTTP server if requested.
 	if *managementAddr != "" {
+		management.SetReloadFunc(launcher.Reload)
 		mgmtCtx, mgmtCancel := context.WithCancel(context.Background())
 		defer mgmtCancel()
 		go func() {
@@ -0,0 +1,206 @@
+package dns
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// DefaultCacheSize bounds the number of cached questions.
+	DefaultCacheSize = 4096
+	// DefaultNegativeTTL applies to NXDOMAIN and empty answers. The tunnel only
+	// carries the answer section, so the SOA minimum that would normally bound
+	// negative caching is not available.
+	DefaultNegativeTTL = 30 * time.Second
+	// DefaultMaxTTL caps how long any answer is served from the cache.
+	DefaultMaxTTL = 24 * time.Hour
+)
+
+// GlobalCacheStats counts lookups of the client DNS cache for the management api.
+var GlobalCacheStats = new(cacheStats)
+
+type cacheStats struct {
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	entries atomic.Int64
+}
+
+// Snapshot returns the hit and miss counts and the number of cached questions.
+func (s *cacheStats) Snapshot() (hits, misses uint64, entries int) {
+	return s.hits.Load(), s.misses.Load(), int(s.entries.Load())
+}
+
+// CacheConfig tunes the answer cache. Zero values select the defaults.
+type CacheConfig struct {
+	Disable bool
+	// Size bounds the number of cached questions.
+	Size int
+	// MinTTL raises short record TTLs, MaxTTL lowers long ones.
+	MinTTL time.Duration
+	MaxTTL time.Duration
+	// NegativeTTL is how long NXDOMAIN and empty answers are cached.
+	NegativeTTL time.Duration
+}
+
+type cacheKey struct {
+	name   string
+	qtype  uint16
+	qclass uint16
+}
+
+type cacheItem struct {
+	key     cacheKey
+	answer  []dns.RR
+	rcode   int
+	stored  time.Time
+	expires time.Time
+}
+
+// answerCache is an LRU cache of upstream answers keyed by question.
+type answerCache struct {
+	config CacheConfig
+	now    func() time.Time
+
+	mu    sync.Mutex
+	items map[cacheKey]*list.Element
+	lru   *list.List
+}
+
+// newAnswerCache returns nil when caching is disabled; a nil cache misses on
+// every lookup and stores nothing.
+func newAnswerCache(config CacheConfig) *answerCache {
+	if config.Disable {
+		return nil
+	}
+	if config.Size <= 0 {
+		config.Size = DefaultCacheSize
+	}
+	if config.MaxTTL <= 0 {
+		config.MaxTTL = DefaultMaxTTL
+	}
+	if config.NegativeTTL <= 0 {
+		config.NegativeTTL = DefaultNegativeTTL
+	}
+	if config.MinTTL > config.MaxTTL {
+		config.MinTTL = config.MaxTTL
+	}
+	return &answerCache{
+		config: config,
+		now:    time.Now,
+		items:  make(map[cacheKey]*list.Element),
+		lru:    list.New(),
+	}
+}
+
+func newCacheKey(q dns.Question) cacheKey {
+	return cacheKey{name: strings.ToLower(q.Name), qtype: q.Qtype, qclass: q.Qclass}
+}
+
+// Get returns a copy of the cached answer with TTLs counted down by the time
+// it spent in the cache.
+func (c *answerCache) Get(q dns.Question) ([]dns.RR, int, bool) {
+	if c == nil {
+		return nil, 0, false
+	}
+	key := newCacheKey(q)
+	now := c.now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		GlobalCacheStats.misses.Add(1)
+		return nil, 0, false
+	}
+	item := elem.Value.(*cacheItem)
+	if !now.Before(item.expires) {
+		c.removeLocked(elem)
+		GlobalCacheStats.misses.Add(1)
+		return nil, 0, false
+	}
+	c.lru.MoveToFront(elem)
+	GlobalCacheStats.hits.Add(1)
+
+	elapsed := uint32(now.Sub(item.stored) / time.Second)
+	answer := make([]dns.RR, len(item.answer))
+	for i, rr := range item.answer {
+		rr = dns.Copy(rr)
+		if hdr := rr.Header(); hdr.Ttl > elapsed {
+			hdr.Ttl -= elapsed
+		} else {
+			hdr.Ttl = 0
+		}
+		answer[i] = rr
+	}
+	return answer, item.rcode, true
+}
+
+// Set caches an upstream answer. Only NOERROR and NXDOMAIN are cacheable;
+// server failures must be retried.
+func (c *answerCache) Set(q dns.Question, answer []dns.RR, rcode int) {
+	if c == nil {
+		return
+	}
+	ttl, ok := c.ttl(answer, rcode)
+	if !ok {
+		return
+	}
+
+	now := c.now()
+	stored := make([]dns.RR, len(answer))
+	for i, rr := range answer {
+		rr = dns.Copy(rr)
+		// serve the ttl the cache honours, so downstream caches agree
+		rr.Header().Ttl = uint32(ttl / time.Second)
+		stored[i] = rr
+	}
+	item := &cacheItem{
+		key:     newCacheKey(q),
+		answer:  stored,
+		rcode:   rcode,
+		stored:  now,
+		expires: now.Add(ttl),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[item.key]; ok {
+		elem.Value = item
+		c.lru.MoveToFront(elem)
+		return
+	}
+	for c.lru.Len() >= c.config.Size {
+		c.removeLocked(c.lru.Back())
+	}
+	c.items[item.key] = c.lru.PushFront(item)
+	GlobalCacheStats.entries.Add(1)
+}
+
+// ttl returns how long an answer may be cached: the lowest record TTL within
+// the configured bounds, or the negative TTL when there is nothing to answer.
+func (c *answerCache) ttl(answer []dns.RR, rcode int) (time.Duration, bool) {
+	switch {
+	case rcode == dns.RcodeNameError, rcode == dns.RcodeSuccess && len(answer) == 0:
+		return min(c.config.NegativeTTL, c.config.MaxTTL), true
+	case rcode != dns.RcodeSuccess:
+		return 0, false
+	}
+
+	lowest := answer[0].Header().Ttl
+	for _, rr := range answer[1:] {
+		lowest = min(lowest, rr.Header().Ttl)
+	}
+	ttl := min(max(time.Duration(lowest)*time.Second, c.config.MinTTL), c.config.MaxTTL)
+	return ttl, ttl > 0
+}
+
+func (c *answerCache) removeLocked(elem *list.Element) {
+	delete(c.items, elem.Value.(*cacheItem).key)
+	c.lru.Remove(elem)
+	GlobalCacheStats.entries.Add(-1)
+}
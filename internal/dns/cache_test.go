@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	rpcClient "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/client"
+	mdns "github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) mdns.RR {
+	t.Helper()
+	rr, err := mdns.NewRR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rr
+}
+
+func question(name string, qtype uint16) mdns.Question {
+	return mdns.Question{Name: name, Qtype: qtype, Qclass: mdns.ClassINET}
+}
+
+func TestAnswerCacheCountsDownTTL(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := newAnswerCache(CacheConfig{})
+	c.now = func() time.Time { return now }
+
+	q := question("Example.COM.", mdns.TypeA)
+	c.Set(q, []mdns.RR{mustRR(t, "example.com. 60 IN A 192.0.2.1")}, mdns.RcodeSuccess)
+
+	now = now.Add(20 * time.Second)
+	answer, rcode, ok := c.Get(question("example.com.", mdns.TypeA))
+	if !ok || rcode != mdns.RcodeSuccess || len(answer) != 1 {
+		t.Fatalf("Get() = %v, %d, %v, want a case-insensitive hit", answer, rcode, ok)
+	}
+	if ttl := answer[0].Header().Ttl; ttl != 40 {
+		t.Errorf("ttl = %d, want 40", ttl)
+	}
+
+	now = now.Add(40 * time.Second)
+	if _, _, ok = c.Get(q); ok {
+		t.Error("Get() served an expired answer")
+	}
+}
+
+func TestAnswerCacheTTLBounds(t *testing.T) {
+	c := newAnswerCache(CacheConfig{MinTTL: time.Minute, MaxTTL: time.Hour, NegativeTTL: 5 * time.Second})
+	tests := []struct {
+		name   string
+		answer []mdns.RR
+		rcode  int
+		want   time.Duration
+		cached bool
+	}{
+		{"short ttl raised", []mdns.RR{mustRR(t, "a. 1 IN A 192.0.2.1")}, mdns.RcodeSuccess, time.Minute, true},
+		{"long ttl lowered", []mdns.RR{mustRR(t, "a. 86400 IN A 192.0.2.1")}, mdns.RcodeSuccess, time.Hour, true},
+		{"lowest record wins", []mdns.RR{
+			mustRR(t, "a. 600 IN CNAME b."),
+			mustRR(t, "b. 120 IN A 192.0.2.1"),
+		}, mdns.RcodeSuccess, 2 * time.Minute, true},
+		{"nxdomain", nil, mdns.RcodeNameError, 5 * time.Second, true},
+		{"nodata", nil, mdns.RcodeSuccess, 5 * time.Second, true},
+		{"servfail", nil, mdns.RcodeServerFailure, 0, false},
+		{"refused", nil, mdns.RcodeRefused, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := c.ttl(tt.answer, tt.rcode)
+			if ok != tt.cached || got != tt.want {
+				t.Fatalf("ttl() = %v, %v, want %v, %v", got, ok, tt.want, tt.cached)
+			}
+		})
+	}
+}
+
+func TestAnswerCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newAnswerCache(CacheConfig{Size: 2})
+	a, b, d := question("a.", mdns.TypeA), question("b.", mdns.TypeA), question("d.", mdns.TypeA)
+	c.Set(a, []mdns.RR{mustRR(t, "a. 60 IN A 192.0.2.1")}, mdns.RcodeSuccess)
+	c.Set(b, []mdns.RR{mustRR(t, "b. 60 IN A 192.0.2.2")}, mdns.RcodeSuccess)
+	c.Get(a) // b is now the least recently used
+	c.Set(d, []mdns.RR{mustRR(t, "d. 60 IN A 192.0.2.3")}, mdns.RcodeSuccess)
+
+	if _, _, ok := c.Get(b); ok {
+		t.Error("least recently used entry was kept")
+	}
+	if _, _, ok := c.Get(a); !ok {
+		t.Error("recently used entry was evicted")
+	}
+	if len(c.items) != 2 {
+		t.Errorf("entries = %d, want 2", len(c.items))
+	}
+}
+
+func TestDisabledCacheNeverHits(t *testing.T) {
+	c := newAnswerCache(CacheConfig{Disable: true})
+	q := question("a.", mdns.TypeA)
+	c.Set(q, []mdns.RR{mustRR(t, "a. 60 IN A 192.0.2.1")}, mdns.RcodeSuccess)
+	if _, _, ok := c.Get(q); ok {
+		t.Fatal("disabled cache returned an answer")
+	}
+}
+
+func TestServeDNSAnswersRepeatsFromCache(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0", false, CacheConfig{}, SplitConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := 0
+	s.resolve = func(_ context.Context, requests []*rpcClient.DnsRequest) (rpcClient.DnsAnswer, error) {
+		calls++
+		return rpcClient.DnsAnswer{Records: []mdns.RR{mustRR(t, requests[0].Fqdn+" 60 IN A 192.0.2.1")}}, nil
+	}
+
+	hits, misses, _ := GlobalCacheStats.Snapshot()
+	for i := 0; i < 3; i++ {
+		req := new(mdns.Msg)
+		req.SetQuestion("cached.test.", mdns.TypeA)
+		rec := new(responseRecorder)
+		s.ServeDNS(rec, req)
+		if rec.msg == nil || len(rec.msg.Answer) != 1 || rec.msg.Id != req.Id {
+			t.Fatalf("response %d = %v, want one answer for the query", i, rec.msg)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("rpc calls = %d, want 1", calls)
+	}
+	gotHits, gotMisses, _ := GlobalCacheStats.Snapshot()
+	if gotHits-hits != 2 || gotMisses-misses != 1 {
+		t.Errorf("hits/misses = +%d/+%d, want +2/+1", gotHits-hits, gotMisses-misses)
+	}
+}
@@ -2,9 +2,14 @@ package dns
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
+	"net"
+	"net/netip"
 	"time"
 
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
 	rpcClient "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/client"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
 	"github.com/miekg/dns"
@@ -12,21 +17,42 @@ import (
 
 var DefaultShutdownTimeout = 3 * time.Second
 
+// ednsUDPSize is the payload size advertised to clients that speak EDNS0, the
+// size recommended by DNS flag day 2020 to stay clear of IP fragmentation.
+const ednsUDPSize = 1232
+
+// Server answers queries on the same address over UDP and TCP.
 type Server struct {
-	srv          *dns.Server
+	addr         string
+	udp          *dns.Server
+	tcp          *dns.Server
 	blockIPv6DNS bool
+	cache        *answerCache
+	split        SplitConfig
+	// directCache holds the answers of split.Direct apart, as clients routed
+	// differently may ask the same question.
+	directCache *answerCache
+	// route and resolve look up the route of a name and send questions
+	// through the tunnel; replaced in tests.
+	route   func(src router.Source, dst string) (router.Egress, error)
+	resolve func(ctx context.Context, requests []*rpcClient.DnsRequest) (rpcClient.DnsAnswer, error)
 }
 
-func NewServer(addr string, blockIPv6DNS bool) (*Server, error) {
+func NewServer(addr string, blockIPv6DNS bool, cacheConfig CacheConfig, split SplitConfig) (*Server, error) {
+	if err := split.validate(); err != nil {
+		return nil, err
+	}
 	srv := &Server{
+		addr:         addr,
 		blockIPv6DNS: blockIPv6DNS,
+		cache:        newAnswerCache(cacheConfig),
+		split:        split,
+		directCache:  newAnswerCache(cacheConfig),
+		route:        routeName,
+		resolve:      resolveViaRPC,
 	}
-	dnsSrv := &dns.Server{
-		Addr:    addr,
-		Net:     "udp",
-		Handler: srv,
-	}
-	srv.srv = dnsSrv
+	srv.udp = &dns.Server{Net: "udp", Handler: srv, UDPSize: dns.DefaultMsgSize}
+	srv.tcp = &dns.Server{Net: "tcp", Handler: srv}
 	return srv, nil
 }
 
@@ -35,19 +61,46 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	m.SetReply(r)
 	m.Authoritative = true
 
-	// Acquire a client from the pool for this request and release it immediately
-	// after the RPC completes. This avoids permanently holding one pool slot for
-	// the lifetime of the DNS server (which starves other connections).
-	client, err := rpcClient.New()
-	if err != nil {
-		log.Printf("dns: acquire client failed: %v", err)
-		m.SetRcode(r, dns.RcodeServerFailure)
-		if writeErr := w.WriteMsg(m); writeErr != nil {
-			log.Printf("dns: write response failed: %v", writeErr)
-		}
+	// Names are routed like connections to them: blocked names are answered
+	// here, direct ones by the local resolver and the rest through the tunnel.
+	// Multi-question queries are not routed; no resolver sends them.
+	egress := router.EgressProxy
+	if len(r.Question) == 1 {
+		egress = s.egress(source(w), r.Question[0].Name)
+	}
+	if isBlocked(egress) {
+		log.Printf("dns: blocked %s", r.Question[0].Name)
+		answer := s.blockAnswer(r.Question[0])
+		m.Answer = answer.Records
+		m.Rcode = answer.Rcode
+		writeReply(w, r, m, nil)
 		return
 	}
-	defer utils.Close(client)
+
+	direct := egress == router.EgressDirect && s.split.Direct != nil
+	if s.split.FakeIP != nil && !direct && len(r.Question) == 1 {
+		if q := r.Question[0]; q.Qtype == dns.TypeA || q.Qtype == dns.TypeAAAA {
+			answer := s.fakeAnswer(q)
+			m.Answer = answer.Records
+			writeReply(w, r, m, nil)
+			return
+		}
+	}
+
+	edns := r.IsEdns0()
+	cacheable := isCacheable(r)
+	cache := s.cache
+	if direct {
+		cache = s.directCache
+	}
+	if cacheable {
+		if answer, rcode, ok := cache.Get(r.Question[0]); ok {
+			m.Answer = answer
+			m.Rcode = rcode
+			writeReply(w, r, m, nil)
+			return
+		}
+	}
 
 	// Pre-allocate with exact capacity
 	questionCount := len(r.Question)
@@ -57,6 +110,7 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 			Fqdn:      r.Question[i].Name,
 			QType:     r.Question[i].Qtype,
 			BlockIPv6: s.blockIPv6DNS,
+			EDNS:      edns,
 		})
 	}
 
@@ -64,37 +118,145 @@ func (s *Server) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 	// indefinitely-hanging ServeDNS goroutines when the upstream is slow.
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	results, rcode, err := client.DnsResolve(ctx, dnsReqList)
+	var answer rpcClient.DnsAnswer
+	var err error
+	if direct {
+		answer, err = s.resolveDirect(ctx, r)
+	} else {
+		answer, err = s.resolve(ctx, dnsReqList)
+	}
 	if err != nil {
-		log.Printf("dns: resolve via rpc failed: %v", err)
+		log.Printf("dns: %v", err)
 		m.SetRcode(r, dns.RcodeServerFailure)
-		if err = w.WriteMsg(m); err != nil {
-			log.Printf("dns: write response failed: %v", err)
-		}
+		writeReply(w, r, m, nil)
 		return
 	}
+	if cacheable {
+		cache.Set(r.Question[0], answer.Records, answer.Rcode)
+	}
 
 	// Convert RPC results back to DNS format
-	m.Answer = results
-	m.Rcode = rcode
+	m.Answer = answer.Records
+	m.Rcode = answer.Rcode
+	writeReply(w, r, m, answer.EDNS)
+}
+
+// isCacheable reports whether the answer to r does not depend on more than its
+// question: only single-question queries, which is all resolvers send, without
+// DNSSEC records requested and without a client subnet the upstream may tailor
+// the answer to.
+func isCacheable(r *dns.Msg) bool {
+	if len(r.Question) != 1 {
+		return false
+	}
+	edns := r.IsEdns0()
+	if edns == nil {
+		return true
+	}
+	if edns.Do() {
+		return false
+	}
+	for _, option := range edns.Option {
+		if option.Option() == dns.EDNS0SUBNET {
+			return false
+		}
+	}
+	return true
+}
+
+// writeReply sends m, the reply to r. A client that sent an OPT record gets
+// one back, carrying the options of the upstream's if there was one. Over UDP
+// the reply is cut down to what the client can receive and marked truncated,
+// so it retries over TCP.
+func writeReply(w dns.ResponseWriter, r, m *dns.Msg, upstream *dns.OPT) {
+	edns := r.IsEdns0()
+	if edns != nil {
+		opt := &dns.OPT{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}}
+		opt.SetUDPSize(ednsUDPSize)
+		if upstream != nil {
+			opt.Option = upstream.Option
+			if edns.Do() && upstream.Do() {
+				opt.SetDo()
+			}
+		}
+		m.Extra = append(m.Extra, opt)
+	} else if m.Rcode > 0xF {
+		// the upper bits of an extended rcode need an OPT record to travel in
+		m.Rcode = dns.RcodeServerFailure
+	}
 
-	if err = w.WriteMsg(m); err != nil {
+	if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+		size := dns.MinMsgSize
+		if edns != nil {
+			size = int(edns.UDPSize())
+		}
+		m.Truncate(size)
+	}
+	if err := w.WriteMsg(m); err != nil {
 		log.Printf("dns: write response failed: %v", err)
 	}
 }
 
+// routeName routes a queried name, which is headed to no port in particular.
+func routeName(src router.Source, name string) (router.Egress, error) {
+	return router.GetEgressFrom(src, name, 0)
+}
+
+// source is where the query answered through w comes from, as routes see it.
+func source(w dns.ResponseWriter) router.Source {
+	src := router.Source{Inbound: router.InboundDNS}
+	if addrPort, err := netip.ParseAddrPort(w.RemoteAddr().String()); err == nil {
+		src.Addr = addrPort.Addr()
+	}
+	return src
+}
+
+// resolveViaRPC acquires a client from the pool for this request and releases
+// it immediately after the RPC completes. This avoids permanently holding one
+// pool slot for the lifetime of the DNS server (which starves other
+// connections).
+func resolveViaRPC(ctx context.Context, requests []*rpcClient.DnsRequest) (rpcClient.DnsAnswer, error) {
+	client, err := rpcClient.New()
+	if err != nil {
+		return rpcClient.DnsAnswer{Rcode: dns.RcodeServerFailure}, fmt.Errorf("acquire client failed: %w", err)
+	}
+	defer utils.Close(client)
+
+	answer, err := client.DnsExchange(ctx, requests)
+	if err != nil {
+		return answer, fmt.Errorf("resolve via rpc failed: %w", err)
+	}
+	return answer, nil
+}
+
+// Start listens on UDP and TCP and serves until ctx is done.
 func (s *Server) Start(ctx context.Context) error {
-	log.Printf("dns: listening at %s", s.srv.Addr)
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return err
+	}
+	// bind TCP to the address UDP got, so a zero port means the same port
+	ln, err := net.Listen("tcp", conn.LocalAddr().String())
+	if err != nil {
+		utils.Close(conn)
+		return err
+	}
+	s.udp.PacketConn = conn
+	s.tcp.Listener = ln
+	log.Printf("dns: listening at %s (udp, tcp)", conn.LocalAddr())
 
 	// Create error channel for server errors
-	serverErr := make(chan error, 1)
-	go func() {
-		serverErr <- s.srv.ListenAndServe()
-	}()
+	serverErr := make(chan error, 2)
+	for _, srv := range []*dns.Server{s.udp, s.tcp} {
+		go func() {
+			serverErr <- srv.ActivateAndServe()
+		}()
+	}
 
 	// Wait for context done or server error
 	select {
-	case err := <-serverErr:
+	case err = <-serverErr:
+		utils.Close(s)
 		return err
 	case <-ctx.Done():
 		utils.Close(s)
@@ -106,8 +268,14 @@ func (s *Server) Close() error {
 	log.Println("dns: shutting down")
 	ctx, cancel := context.WithTimeout(context.Background(), DefaultShutdownTimeout)
 	defer cancel()
-	if s.srv != nil {
-		return s.srv.ShutdownContext(ctx)
+	var errs []error
+	for _, srv := range []*dns.Server{s.udp, s.tcp} {
+		if srv.PacketConn == nil && srv.Listener == nil {
+			continue
+		}
+		if err := srv.ShutdownContext(ctx); err != nil {
+			errs = append(errs, err)
+		}
 	}
-	return nil
+	return errors.Join(errs...)
 }
@@ -1,9 +1,12 @@
 package dns
 
 import (
+	"context"
 	"net"
 	"testing"
+	"time"
 
+	rpcClient "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/client"
 	mdns "github.com/miekg/dns"
 )
 
@@ -41,7 +44,7 @@ func (r *responseRecorder) TsigTimersOnly(bool) {}
 func (r *responseRecorder) Hijack() {}
 
 func TestServeDNSReturnsServfailWhenRPCClientUnavailable(t *testing.T) {
-	s, err := NewServer("127.0.0.1:0", false)
+	s, err := NewServer("127.0.0.1:0", false, CacheConfig{}, SplitConfig{})
 	if err != nil {
 		t.Fatalf("NewServer() error = %v", err)
 	}
@@ -58,3 +61,162 @@ func TestServeDNSReturnsServfailWhenRPCClientUnavailable(t *testing.T) {
 		t.Fatalf("Rcode = %d, want SERVFAIL", rec.msg.Rcode)
 	}
 }
+
+// startServer serves s on a free local port and returns its address, which
+// is the same for UDP and TCP.
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+	started := make(chan struct{}, 2)
+	s.udp.NotifyStartedFunc = func() { started <- struct{}{} }
+	s.tcp.NotifyStartedFunc = func() { started <- struct{}{} }
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Start(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	for range 2 {
+		select {
+		case <-started:
+		case err := <-done:
+			t.Fatalf("Start() error = %v", err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("dns server did not start")
+		}
+	}
+	return s.udp.PacketConn.LocalAddr().String()
+}
+
+// largeAnswer resolves every question to 60 A records, well over 512 bytes.
+func largeAnswer(_ context.Context, requests []*rpcClient.DnsRequest) (rpcClient.DnsAnswer, error) {
+	var answer rpcClient.DnsAnswer
+	for i := range 60 {
+		answer.Records = append(answer.Records, &mdns.A{
+			Hdr: mdns.RR_Header{Name: requests[0].Fqdn, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 60},
+			A:   net.IPv4(192, 0, 2, byte(i)).To4(),
+		})
+	}
+	return answer, nil
+}
+
+func TestServerAnswersOverUDPAndTCP(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0", false, CacheConfig{Disable: true}, SplitConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.resolve = largeAnswer
+	addr := startServer(t, s)
+
+	tests := []struct {
+		name      string
+		net       string
+		udpSize   uint16
+		truncated bool
+	}{
+		{name: "udp without edns is truncated", net: "udp", truncated: true},
+		{name: "udp with a large edns buffer", net: "udp", udpSize: 4096},
+		{name: "udp with a small edns buffer is truncated", net: "udp", udpSize: 600, truncated: true},
+		{name: "tcp", net: "tcp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := new(mdns.Msg)
+			req.SetQuestion("large.test.", mdns.TypeA)
+			if tt.udpSize > 0 {
+				req.SetEdns0(tt.udpSize, false)
+			}
+			client := &mdns.Client{Net: tt.net, Timeout: 5 * time.Second}
+			resp, _, err := client.Exchange(req, addr)
+			if err != nil {
+				t.Fatalf("Exchange() error = %v", err)
+			}
+			if resp.Truncated != tt.truncated {
+				t.Fatalf("Truncated = %t, want %t", resp.Truncated, tt.truncated)
+			}
+			if !tt.truncated && len(resp.Answer) != 60 {
+				t.Fatalf("answers = %d, want 60", len(resp.Answer))
+			}
+			if tt.truncated && len(resp.Answer) >= 60 {
+				t.Fatalf("answers = %d, want fewer than 60", len(resp.Answer))
+			}
+			if (tt.udpSize > 0) != (resp.IsEdns0() != nil) {
+				t.Fatalf("response OPT = %v, want one only when the query had one", resp.IsEdns0())
+			}
+			resp.Compress = true // as it went over the wire
+			if size := resp.Len(); tt.net == "udp" && size > max(int(tt.udpSize), mdns.MinMsgSize) {
+				t.Fatalf("udp response is %d bytes, over the client's buffer", size)
+			}
+		})
+	}
+}
+
+func TestServeDNSPassesEDNSThrough(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0", false, CacheConfig{}, SplitConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var forwarded *mdns.OPT
+	s.resolve = func(_ context.Context, requests []*rpcClient.DnsRequest) (rpcClient.DnsAnswer, error) {
+		forwarded = requests[0].EDNS
+		upstream := &mdns.OPT{Hdr: mdns.RR_Header{Name: ".", Rrtype: mdns.TypeOPT}}
+		upstream.SetUDPSize(512)
+		upstream.SetDo()
+		upstream.Option = []mdns.EDNS0{&mdns.EDNS0_EDE{InfoCode: mdns.ExtendedErrorCodeDNSBogus}}
+		return rpcClient.DnsAnswer{Rcode: mdns.RcodeBadVers, EDNS: upstream}, nil
+	}
+
+	req := new(mdns.Msg)
+	req.SetQuestion("example.com.", mdns.TypeA)
+	req.SetEdns0(4096, true)
+	rec := new(responseRecorder)
+	s.ServeDNS(rec, req)
+
+	if forwarded == nil || forwarded.UDPSize() != 4096 || !forwarded.Do() {
+		t.Fatalf("forwarded EDNS = %v, want the query's OPT record", forwarded)
+	}
+	opt := rec.msg.IsEdns0()
+	if opt == nil {
+		t.Fatal("response has no OPT record")
+	}
+	if opt.UDPSize() != ednsUDPSize || !opt.Do() || len(opt.Option) != 1 {
+		t.Fatalf("response OPT = %v, want ours carrying the upstream options", opt)
+	}
+	if rec.msg.Rcode != mdns.RcodeBadVers {
+		t.Fatalf("Rcode = %d, want BADVERS", rec.msg.Rcode)
+	}
+}
+
+func TestIsCacheable(t *testing.T) {
+	plain := new(mdns.Msg)
+	plain.SetQuestion("example.com.", mdns.TypeA)
+
+	withEDNS := plain.Copy()
+	withEDNS.SetEdns0(1232, false)
+
+	dnssec := plain.Copy()
+	dnssec.SetEdns0(1232, true)
+
+	subnet := withEDNS.Copy()
+	subnet.IsEdns0().Option = []mdns.EDNS0{&mdns.EDNS0_SUBNET{
+		Code: mdns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("198.51.100.0").To4(),
+	}}
+
+	tests := []struct {
+		name string
+		msg  *mdns.Msg
+		want bool
+	}{
+		{"plain", plain, true},
+		{"edns", withEDNS, true},
+		{"dnssec ok", dnssec, false},
+		{"client subnet", subnet, false},
+		{"no question", new(mdns.Msg), false},
+	}
+	for _, tt := range tests {
+		if got := isCacheable(tt.msg); got != tt.want {
+			t.Errorf("isCacheable(%s) = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+// Package fakeip hands out addresses from a reserved range in place of the
+// real ones and maps them back to the names they stand for, so inbounds can
+// route connections made to them by domain.
+package fakeip
+
+import (
+	"container/list"
+	"fmt"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
+)
+
+const (
+	// DefaultRange is reserved for benchmarking (RFC 2544) and not routed on
+	// the internet.
+	DefaultRange = "198.18.0.0/15"
+	// DefaultSize bounds the number of names mapped at once in each range.
+	DefaultSize = 65535
+)
+
+var active atomic.Pointer[Pool]
+
+// SetDefault makes p the pool Lookup and Resolve consult; nil turns the
+// translation off.
+func SetDefault(p *Pool) {
+	active.Store(p)
+}
+
+// Lookup returns the name host stands for when it is a fake address handed
+// out by the default pool.
+func Lookup(host string) (string, bool) {
+	p := active.Load()
+	if p == nil {
+		return "", false
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return "", false
+	}
+	return p.Lookup(addr)
+}
+
+// Resolve returns the name host stands for, or host itself when it is not a
+// fake address.
+func Resolve(host string) string {
+	if name, ok := Lookup(host); ok {
+		return name
+	}
+	return host
+}
+
+// Pool maps names to fake addresses of an IPv4 and, optionally, an IPv6
+// range. When a range runs out, the least recently used mapping is recycled.
+type Pool struct {
+	v4 *table
+	v6 *table
+}
+
+// New returns a pool over range4 and range6, either of which may be empty to
+// leave the family without fake addresses, holding up to size names in each.
+func New(range4, range6 string, size int) (*Pool, error) {
+	if size <= 0 {
+		size = DefaultSize
+	}
+	p := new(Pool)
+	var err error
+	if range4 != "" {
+		if p.v4, err = newTable(range4, size, true); err != nil {
+			return nil, err
+		}
+	}
+	if range6 != "" {
+		if p.v6, err = newTable(range6, size, false); err != nil {
+			return nil, err
+		}
+	}
+	if p.v4 == nil && p.v6 == nil {
+		return nil, fmt.Errorf("fakeip: no range configured")
+	}
+	return p, nil
+}
+
+// Get returns the fake address of name in the IPv6 or IPv4 range, mapping it
+// first if needed. It returns false when the family has no range.
+func (p *Pool) Get(name string, ipv6 bool) (netip.Addr, bool) {
+	t := p.v4
+	if ipv6 {
+		t = p.v6
+	}
+	if t == nil {
+		return netip.Addr{}, false
+	}
+	return t.get(utils.NormalizeHost(name)), true
+}
+
+// Lookup returns the name addr stands for. A hit counts as a use, so
+// addresses still being connected to are the last to be recycled.
+func (p *Pool) Lookup(addr netip.Addr) (string, bool) {
+	addr = addr.Unmap()
+	for _, t := range []*table{p.v4, p.v6} {
+		if t != nil && t.prefix.Contains(addr) {
+			return t.lookup(addr)
+		}
+	}
+	return "", false
+}
+
+type mapping struct {
+	name string
+	addr netip.Addr
+}
+
+type table struct {
+	prefix netip.Prefix
+	size   int
+
+	mu     sync.Mutex
+	byName map[string]*list.Element
+	byAddr map[netip.Addr]*list.Element
+	lru    *list.List
+}
+
+func newTable(cidr string, size int, ipv4 bool) (*table, error) {
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("fakeip: %w", err)
+	}
+	if prefix.Addr().Is4() != ipv4 {
+		family := "ipv6"
+		if ipv4 {
+			family = "ipv4"
+		}
+		return nil, fmt.Errorf("fakeip: %s is not an %s range", cidr, family)
+	}
+	prefix = prefix.Masked()
+
+	// the first address of the range, and for IPv4 the broadcast address,
+	// are never handed out
+	hostBits := prefix.Addr().BitLen() - prefix.Bits()
+	usable := size
+	if hostBits < 31 {
+		usable = 1<<hostBits - 1
+		if ipv4 {
+			usable--
+		}
+	}
+	if usable < 1 {
+		return nil, fmt.Errorf("fakeip: %s is too small", cidr)
+	}
+	return &table{
+		prefix: prefix,
+		size:   min(size, usable),
+		byName: make(map[string]*list.Element),
+		byAddr: make(map[netip.Addr]*list.Element),
+		lru:    list.New(),
+	}, nil
+}
+
+func (t *table) get(name string) netip.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if elem, ok := t.byName[name]; ok {
+		t.lru.MoveToFront(elem)
+		return elem.Value.(*mapping).addr
+	}
+
+	var m *mapping
+	if t.lru.Len() < t.size {
+		m = &mapping{addr: offset(t.prefix.Addr(), uint64(t.lru.Len())+1)}
+	} else {
+		// recycle the address of the least recently used name
+		elem := t.lru.Back()
+		m = elem.Value.(*mapping)
+		delete(t.byName, m.name)
+		delete(t.byAddr, m.addr)
+		t.lru.Remove(elem)
+	}
+	m.name = name
+	elem := t.lru.PushFront(m)
+	t.byName[name] = elem
+	t.byAddr[m.addr] = elem
+	return m.addr
+}
+
+func (t *table) lookup(addr netip.Addr) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	elem, ok := t.byAddr[addr]
+	if !ok {
+		return "", false
+	}
+	t.lru.MoveToFront(elem)
+	return elem.Value.(*mapping).name, true
+}
+
+// offset returns base + n.
+func offset(base netip.Addr, n uint64) netip.Addr {
+	b := base.AsSlice()
+	for i := len(b) - 1; i >= 0 && n > 0; i-- {
+		sum := uint64(b[i]) + n&0xff
+		b[i] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
@@ -0,0 +1,105 @@
+package fakeip
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestPoolMapsBothWays(t *testing.T) {
+	p, err := New("198.18.0.0/15", "fc00::/64", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, ok := p.Get("Example.COM.", false)
+	if !ok || a != netip.MustParseAddr("198.18.0.1") {
+		t.Fatalf("Get() = %v, %t, want 198.18.0.1", a, ok)
+	}
+	if again, _ := p.Get("example.com", false); again != a {
+		t.Fatalf("second Get() = %v, want the same address %v", again, a)
+	}
+	b, _ := p.Get("other.example", false)
+	if b != netip.MustParseAddr("198.18.0.2") {
+		t.Fatalf("Get(other) = %v, want 198.18.0.2", b)
+	}
+	v6, ok := p.Get("example.com", true)
+	if !ok || v6 != netip.MustParseAddr("fc00::1") {
+		t.Fatalf("Get(ipv6) = %v, %t, want fc00::1", v6, ok)
+	}
+
+	for addr, want := range map[netip.Addr]string{
+		a:                                        "example.com",
+		b:                                        "other.example",
+		v6:                                       "example.com",
+		netip.MustParseAddr("::ffff:198.18.0.1"): "example.com",
+	} {
+		if name, ok := p.Lookup(addr); !ok || name != want {
+			t.Errorf("Lookup(%v) = %q, %t, want %q", addr, name, ok, want)
+		}
+	}
+	if _, ok := p.Lookup(netip.MustParseAddr("198.18.0.3")); ok {
+		t.Error("Lookup() found a name for an address never handed out")
+	}
+	if _, ok := p.Lookup(netip.MustParseAddr("192.0.2.1")); ok {
+		t.Error("Lookup() found a name outside the range")
+	}
+}
+
+func TestPoolRecyclesLeastRecentlyUsed(t *testing.T) {
+	p, err := New("198.18.0.0/30", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a /30 has two usable addresses
+	a, _ := p.Get("a.example", false)
+	b, _ := p.Get("b.example", false)
+	p.Lookup(a) // b is now the least recently used
+
+	c, _ := p.Get("c.example", false)
+	if c != b {
+		t.Fatalf("Get(c) = %v, want the recycled address %v", c, b)
+	}
+	if name, _ := p.Lookup(b); name != "c.example" {
+		t.Fatalf("Lookup(%v) = %q, want c.example", b, name)
+	}
+	if name, _ := p.Lookup(a); name != "a.example" {
+		t.Fatalf("Lookup(%v) = %q, want a.example", a, name)
+	}
+	if _, ok := p.Get("d.example", true); ok {
+		t.Fatal("Get() handed out an ipv6 address without an ipv6 range")
+	}
+}
+
+func TestNewRejectsBadRanges(t *testing.T) {
+	for _, tt := range []struct{ range4, range6 string }{
+		{"", ""},
+		{"fc00::/64", ""},
+		{"", "198.18.0.0/15"},
+		{"198.18.0.0/32", ""},
+		{"not a range", ""},
+	} {
+		if _, err := New(tt.range4, tt.range6, 0); err == nil {
+			t.Errorf("New(%q, %q) accepted a bad range", tt.range4, tt.range6)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	p, err := New(DefaultRange, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, _ := p.Get("example.com", false)
+
+	if got := Resolve(addr.String()); got != addr.String() {
+		t.Fatalf("Resolve() without a default pool = %q", got)
+	}
+	SetDefault(p)
+	t.Cleanup(func() { SetDefault(nil) })
+	if got := Resolve(addr.String()); got != "example.com" {
+		t.Fatalf("Resolve(%v) = %q, want example.com", addr, got)
+	}
+	if got := Resolve("example.org"); got != "example.org" {
+		t.Fatalf("Resolve(example.org) = %q, want it unchanged", got)
+	}
+}
@@ -0,0 +1,128 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	rpcClient "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/client"
+	pkgdns "github.com/SuzukiHonoka/spaceship/v2/pkg/dns"
+	"github.com/miekg/dns"
+)
+
+const (
+	// blockTTL is the TTL of the answers made up for blocked names.
+	blockTTL = 60
+	// fakeTTL keeps fake answers out of downstream caches, which would go on
+	// serving an address after the pool recycled it for another name.
+	fakeTTL = 1
+)
+
+// BlockMode selects how names routed to block are answered.
+type BlockMode string
+
+const (
+	// BlockNXDomain answers that the name does not exist.
+	BlockNXDomain BlockMode = "nxdomain"
+	// BlockZeroIP answers A and AAAA questions with the unspecified address,
+	// so connections fail at once instead of being retried elsewhere, and
+	// other questions with an empty answer.
+	BlockZeroIP BlockMode = "zero_ip"
+)
+
+// SplitConfig routes questions by the client route table.
+type SplitConfig struct {
+	// Direct answers names routed direct. Without it they go through the
+	// tunnel like every other name.
+	Direct pkgdns.Client
+	// Block defaults to BlockNXDomain.
+	Block BlockMode
+	// FakeIP, if set, answers A and AAAA questions for names not resolved by
+	// Direct with addresses of the pool. Inbounds map them back to the name,
+	// so the route of a connection is picked by domain even when the app
+	// dials an address.
+	FakeIP *fakeip.Pool
+}
+
+func (c *SplitConfig) validate() error {
+	switch c.Block {
+	case "":
+		c.Block = BlockNXDomain
+	case BlockNXDomain, BlockZeroIP:
+	default:
+		return fmt.Errorf("dns: unknown block mode %q", c.Block)
+	}
+	return nil
+}
+
+// egress returns the route of a name queried from src. Names no route matches
+// go through the tunnel as before.
+func (s *Server) egress(src router.Source, name string) router.Egress {
+	egress, err := s.route(src, name)
+	if err != nil {
+		return router.EgressProxy
+	}
+	return egress
+}
+
+func isBlocked(egress router.Egress) bool {
+	return egress == router.EgressBlock || egress == router.EgressBlackHole
+}
+
+// blockAnswer makes up the answer to a question for a blocked name.
+func (s *Server) blockAnswer(q dns.Question) rpcClient.DnsAnswer {
+	if s.split.Block == BlockNXDomain {
+		return rpcClient.DnsAnswer{Rcode: dns.RcodeNameError}
+	}
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: blockTTL}
+	switch q.Qtype {
+	case dns.TypeA:
+		return rpcClient.DnsAnswer{Records: []dns.RR{&dns.A{Hdr: hdr, A: net.IPv4zero.To4()}}}
+	case dns.TypeAAAA:
+		return rpcClient.DnsAnswer{Records: []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: net.IPv6zero}}}
+	}
+	return rpcClient.DnsAnswer{}
+}
+
+// fakeAnswer answers an A or AAAA question with the fake address of the name.
+// Without a range for the family, or with IPv6 blocked, the answer is empty.
+func (s *Server) fakeAnswer(q dns.Question) rpcClient.DnsAnswer {
+	ipv6 := q.Qtype == dns.TypeAAAA
+	if ipv6 && s.blockIPv6DNS {
+		return rpcClient.DnsAnswer{}
+	}
+	addr, ok := s.split.FakeIP.Get(q.Name, ipv6)
+	if !ok {
+		return rpcClient.DnsAnswer{}
+	}
+	hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: fakeTTL}
+	if ipv6 {
+		return rpcClient.DnsAnswer{Records: []dns.RR{&dns.AAAA{Hdr: hdr, AAAA: addr.AsSlice()}}}
+	}
+	return rpcClient.DnsAnswer{Records: []dns.RR{&dns.A{Hdr: hdr, A: addr.AsSlice()}}}
+}
+
+// resolveDirect asks the local resolver, applying the IPv6 block the server
+// applies to names resolved through the tunnel.
+func (s *Server) resolveDirect(ctx context.Context, r *dns.Msg) (rpcClient.DnsAnswer, error) {
+	if s.blockIPv6DNS && r.Question[0].Qtype == dns.TypeAAAA {
+		return rpcClient.DnsAnswer{}, nil
+	}
+	resp, err := s.split.Direct.Exchange(ctx, r.Copy())
+	if err != nil {
+		return rpcClient.DnsAnswer{Rcode: dns.RcodeServerFailure}, fmt.Errorf("resolve %s directly failed: %w", r.Question[0].Name, err)
+	}
+
+	answer := rpcClient.DnsAnswer{Rcode: resp.Rcode, EDNS: resp.IsEdns0()}
+	for _, rr := range resp.Answer {
+		if s.blockIPv6DNS && rr.Header().Rrtype == dns.TypeAAAA {
+			continue
+		}
+		answer.Records = append(answer.Records, rr)
+	}
+	log.Printf("dns: resolved %s directly with %d records", r.Question[0].Name, len(answer.Records))
+	return answer, nil
+}
@@ -0,0 +1,207 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	rpcClient "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/client"
+	mdns "github.com/miekg/dns"
+)
+
+// localResolver answers every A question with 198.51.100.1.
+type localResolver struct {
+	queries int
+}
+
+func (l *localResolver) Exchange(_ context.Context, m *mdns.Msg) (*mdns.Msg, error) {
+	l.queries++
+	r := new(mdns.Msg)
+	r.SetReply(m)
+	r.Answer = []mdns.RR{&mdns.A{
+		Hdr: mdns.RR_Header{Name: m.Question[0].Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("198.51.100.1").To4(),
+	}}
+	return r, nil
+}
+
+func newSplitServer(t *testing.T, split SplitConfig) (*Server, *int) {
+	t.Helper()
+	s, err := NewServer("127.0.0.1:0", false, CacheConfig{Disable: true}, split)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.route = func(_ router.Source, dst string) (router.Egress, error) {
+		switch dst {
+		case "cn.example.":
+			return router.EgressDirect, nil
+		case "ads.example.":
+			return router.EgressBlock, nil
+		case "void.example.":
+			return router.EgressBlackHole, nil
+		case "proxied.example.":
+			return router.EgressProxy, nil
+		}
+		return router.EgressUnknown, errors.New("route not found")
+	}
+	tunneled := new(int)
+	s.resolve = func(_ context.Context, requests []*rpcClient.DnsRequest) (rpcClient.DnsAnswer, error) {
+		*tunneled++
+		return rpcClient.DnsAnswer{Records: []mdns.RR{&mdns.A{
+			Hdr: mdns.RR_Header{Name: requests[0].Fqdn, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("203.0.113.1").To4(),
+		}}}, nil
+	}
+	return s, tunneled
+}
+
+func query(s *Server, name string, qtype uint16) *mdns.Msg {
+	req := new(mdns.Msg)
+	req.SetQuestion(name, qtype)
+	rec := new(responseRecorder)
+	s.ServeDNS(rec, req)
+	return rec.msg
+}
+
+func TestServeDNSRoutesNames(t *testing.T) {
+	local := new(localResolver)
+	s, tunneled := newSplitServer(t, SplitConfig{Direct: local})
+
+	tests := []struct {
+		name     string
+		want     string
+		local    int
+		tunneled int
+	}{
+		{name: "cn.example.", want: "198.51.100.1", local: 1},
+		{name: "proxied.example.", want: "203.0.113.1", local: 1, tunneled: 1},
+		{name: "unrouted.example.", want: "203.0.113.1", local: 1, tunneled: 2},
+	}
+	for _, tt := range tests {
+		resp := query(s, tt.name, mdns.TypeA)
+		if len(resp.Answer) != 1 || resp.Answer[0].(*mdns.A).A.String() != tt.want {
+			t.Fatalf("%s: answer = %v, want %s", tt.name, resp.Answer, tt.want)
+		}
+		if local.queries != tt.local || *tunneled != tt.tunneled {
+			t.Fatalf("%s: local/tunneled queries = %d/%d, want %d/%d",
+				tt.name, local.queries, *tunneled, tt.local, tt.tunneled)
+		}
+	}
+}
+
+func TestServeDNSTunnelsDirectNamesWithoutLocalResolver(t *testing.T) {
+	s, tunneled := newSplitServer(t, SplitConfig{})
+	resp := query(s, "cn.example.", mdns.TypeA)
+	if len(resp.Answer) != 1 || *tunneled != 1 {
+		t.Fatalf("answer = %v after %d tunneled queries, want one tunneled answer", resp.Answer, *tunneled)
+	}
+}
+
+func TestServeDNSCachesDirectAnswersApart(t *testing.T) {
+	local := new(localResolver)
+	s, tunneled := newSplitServer(t, SplitConfig{Direct: local})
+	s.cache = newAnswerCache(CacheConfig{})
+	s.directCache = newAnswerCache(CacheConfig{})
+	// the same name is routed direct for one client and proxied for another
+	egress := router.EgressDirect
+	s.route = func(router.Source, string) (router.Egress, error) {
+		return egress, nil
+	}
+
+	for _, tt := range []struct {
+		egress   router.Egress
+		want     string
+		local    int
+		tunneled int
+	}{
+		{egress: router.EgressDirect, want: "198.51.100.1", local: 1},
+		{egress: router.EgressProxy, want: "203.0.113.1", local: 1, tunneled: 1},
+		{egress: router.EgressDirect, want: "198.51.100.1", local: 1, tunneled: 1},
+		{egress: router.EgressProxy, want: "203.0.113.1", local: 1, tunneled: 1},
+	} {
+		egress = tt.egress
+		resp := query(s, "cn.example.", mdns.TypeA)
+		if len(resp.Answer) != 1 || resp.Answer[0].(*mdns.A).A.String() != tt.want {
+			t.Fatalf("%s: answer = %v, want %s", tt.egress, resp.Answer, tt.want)
+		}
+		if local.queries != tt.local || *tunneled != tt.tunneled {
+			t.Fatalf("%s: local/tunneled queries = %d/%d, want %d/%d",
+				tt.egress, local.queries, *tunneled, tt.local, tt.tunneled)
+		}
+	}
+}
+
+func TestServeDNSBlocksNames(t *testing.T) {
+	t.Run("nxdomain", func(t *testing.T) {
+		s, tunneled := newSplitServer(t, SplitConfig{})
+		for _, name := range []string{"ads.example.", "void.example."} {
+			resp := query(s, name, mdns.TypeA)
+			if resp.Rcode != mdns.RcodeNameError || len(resp.Answer) != 0 {
+				t.Fatalf("%s: response = %v, want NXDOMAIN", name, resp)
+			}
+		}
+		if *tunneled != 0 {
+			t.Fatalf("tunneled queries = %d, want 0", *tunneled)
+		}
+	})
+
+	t.Run("zero ip", func(t *testing.T) {
+		s, _ := newSplitServer(t, SplitConfig{Block: BlockZeroIP})
+		resp := query(s, "ads.example.", mdns.TypeA)
+		if len(resp.Answer) != 1 || !resp.Answer[0].(*mdns.A).A.Equal(net.IPv4zero) {
+			t.Fatalf("A answer = %v, want 0.0.0.0", resp.Answer)
+		}
+		resp = query(s, "ads.example.", mdns.TypeAAAA)
+		if len(resp.Answer) != 1 || !resp.Answer[0].(*mdns.AAAA).AAAA.Equal(net.IPv6zero) {
+			t.Fatalf("AAAA answer = %v, want ::", resp.Answer)
+		}
+		resp = query(s, "ads.example.", mdns.TypeMX)
+		if resp.Rcode != mdns.RcodeSuccess || len(resp.Answer) != 0 {
+			t.Fatalf("MX response = %v, want an empty answer", resp)
+		}
+	})
+}
+
+func TestNewServerRejectsUnknownBlockMode(t *testing.T) {
+	if _, err := NewServer("127.0.0.1:0", false, CacheConfig{}, SplitConfig{Block: "refuse"}); err == nil {
+		t.Fatal("NewServer() accepted an unknown block mode")
+	}
+}
+
+func TestServeDNSAnswersFakeIPs(t *testing.T) {
+	pool, err := fakeip.New(fakeip.DefaultRange, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local := new(localResolver)
+	s, tunneled := newSplitServer(t, SplitConfig{Direct: local, FakeIP: pool})
+
+	resp := query(s, "proxied.example.", mdns.TypeA)
+	if len(resp.Answer) != 1 || resp.Answer[0].Header().Ttl != fakeTTL {
+		t.Fatalf("answer = %v, want one fake address", resp.Answer)
+	}
+	fake, _ := netip.AddrFromSlice(resp.Answer[0].(*mdns.A).A.To4())
+	if name, ok := pool.Lookup(fake); !ok || name != "proxied.example" {
+		t.Fatalf("pool.Lookup(%v) = %q, %t, want proxied.example", fake, name, ok)
+	}
+
+	if resp = query(s, "proxied.example.", mdns.TypeAAAA); resp.Rcode != mdns.RcodeSuccess || len(resp.Answer) != 0 {
+		t.Fatalf("AAAA response = %v, want an empty answer without an ipv6 range", resp)
+	}
+	if resp = query(s, "cn.example.", mdns.TypeA); resp.Answer[0].(*mdns.A).A.String() != "198.51.100.1" {
+		t.Fatalf("direct answer = %v, want the local resolver's", resp.Answer)
+	}
+	if *tunneled != 0 || local.queries != 1 {
+		t.Fatalf("local/tunneled queries = %d/%d, want 1/0", local.queries, *tunneled)
+	}
+
+	// other types still get real answers
+	query(s, "proxied.example.", mdns.TypeTXT)
+	if *tunneled != 1 {
+		t.Fatalf("tunneled queries = %d, want 1", *tunneled)
+	}
+}
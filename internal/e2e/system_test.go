@@ -190,6 +190,8 @@ func startSystemServerProcess(t *testing.T, serverAddr, certPath, keyPath, resol
 		},
 		"ssl": map[string]any{"cert": certPath, "key": keyPath},
 		"dns": map[string]any{"Type": "common", "Server": resolver},
+		// the test targets listen on loopback, guarded by default
+		"guard": map[string]any{"allow": []string{"127.0.0.0/8", "::1"}},
 	}
 
 	raw, err := json.Marshal(cfg)
@@ -8,10 +8,12 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/netip"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
@@ -111,10 +113,23 @@ func (s *Server) proxyAuth(next http.Handler) http.Handler {
 		}
 
 		// Authentication successful, continue to the actual proxy handling
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, user)))
 	})
 }
 
+// userKey is the context key of the user a request authenticated as.
+type userKey struct{}
+
+// source is where r comes from, as routes see it.
+func source(r *http.Request) router.Source {
+	src := router.Source{Inbound: router.InboundHTTP}
+	if addrPort, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		src.Addr = addrPort.Addr()
+	}
+	src.User, _ = r.Context().Value(userKey{}).(string)
+	return src
+}
+
 func (s *Server) Handle(w http.ResponseWriter, r *http.Request) {
 	// filter bad request
 	if r.URL.Host == "" {
@@ -139,7 +154,8 @@ func (s *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// get route for host
-	route, err := router.GetRoute(host)
+	_, port, _ := utils.SplitHostPort(addr)
+	route, err := router.GetRouteFrom(source(r), host, port)
 	if err != nil {
 		ServeProxyError(w, host, fmt.Errorf("no route: %w", err))
 		return
@@ -266,14 +282,15 @@ func writeForwardRequest(w io.Writer, r *http.Request, host string) error {
 }
 
 func (s *Server) handleConnect(w http.ResponseWriter, r *http.Request) {
-	host, _, err := net.SplitHostPort(r.Host)
+	host, port, err := net.SplitHostPort(r.Host)
 	if err != nil {
 		ServeProxyError(w, r.Host, fmt.Errorf("invalid host: %w", err))
 		return
 	}
+	host = fakeip.Resolve(host)
 
 	// get route for host
-	route, err := router.GetRoute(host)
+	route, err := router.GetRouteFrom(source(r), host, utils.ParsePort(port))
 	if err != nil {
 		ServeProxyError(w, r.Host, fmt.Errorf("no route: %w", err))
 		return
@@ -4,8 +4,11 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"testing"
 	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
 )
 
 func TestServer_ProxyAuth(t *testing.T) {
@@ -115,3 +118,23 @@ func TestServer_Handle_NotConnect(t *testing.T) {
 		t.Errorf("expected StatusServiceUnavailable for GET with no route, got %v", rr.Code)
 	}
 }
+
+func TestServer_ProxyAuthSource(t *testing.T) {
+	s := New(context.Background(), &Config{Credentials: StaticCredentials{"user": "pass"}})
+
+	var got router.Source
+	handler := s.proxyAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = source(r)
+	}))
+	req := httptest.NewRequest("CONNECT", "example.com:443", nil)
+	req.RemoteAddr = "192.168.1.50:51000"
+	req.SetBasicAuth("user", "pass")
+	req.Header.Set("Proxy-Authorization", req.Header.Get("Authorization"))
+	req.Header.Del("Authorization")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	want := router.Source{Inbound: router.InboundHTTP, Addr: netip.MustParseAddr("192.168.1.50"), User: "user"}
+	if got != want {
+		t.Errorf("source() = %+v, want %+v", got, want)
+	}
+}
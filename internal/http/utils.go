@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
 )
@@ -76,5 +77,7 @@ func BuildRemoteAddr(r *http.Request) (string, string, error) {
 			port = 80
 		}
 	}
+	// an address handed out by the fake-IP DNS server stands for its name
+	host = fakeip.Resolve(host)
 	return host, net.JoinHostPort(host, strconv.FormatUint(uint64(port), 10)), nil
 }
@@ -10,6 +10,8 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
 )
 
 func TestServeError(t *testing.T) {
@@ -109,6 +111,25 @@ func TestBuildRemoteAddr(t *testing.T) {
 	}
 }
 
+func TestBuildRemoteAddrResolvesFakeIP(t *testing.T) {
+	pool, err := fakeip.New(fakeip.DefaultRange, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeip.SetDefault(pool)
+	t.Cleanup(func() { fakeip.SetDefault(nil) })
+	fake, _ := pool.Get("example.com", false)
+
+	req := httptest.NewRequest(http.MethodGet, "http://"+fake.String()+":8080/", nil)
+	host, addr, err := BuildRemoteAddr(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "example.com" || addr != "example.com:8080" {
+		t.Fatalf("BuildRemoteAddr() = (%q, %q), want the name the fake IP stands for", host, addr)
+	}
+}
+
 func TestWriteForwardRequest(t *testing.T) {
 	const body = "request body"
 	req := httptest.NewRequest(http.MethodPost, "http://example.com:8080/search?q=spaceship&page=1", strings.NewReader(body))
@@ -10,11 +10,18 @@ import (
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
 	rpcClient "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/client"
+	rpcServer "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/server"
+	"github.com/SuzukiHonoka/spaceship/v2/pkg/config"
+	serverConfig "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
 )
 
 // Server timeouts guard against slow-client (Slowloris) resource exhaustion.
@@ -36,6 +43,47 @@ type StatsResponse struct {
 	PoolActive   int                          `json:"pool_active"`
 	PoolLoad     uint32                       `json:"pool_load"`
 	Connections  []rpcClient.ConnectionDetail `json:"connections"`
+	// Upstream servers of the client, the active one marked
+	Upstreams []rpcClient.UpstreamDetail `json:"upstreams"`
+	// DNS cache of the client DNS server (listen_dns)
+	DNSCacheHits    uint64 `json:"dns_cache_hits"`
+	DNSCacheMisses  uint64 `json:"dns_cache_misses"`
+	DNSCacheEntries int    `json:"dns_cache_entries"`
+}
+
+// TrafficResponse is the JSON payload returned by GET /api/traffic. It lists
+// the per-user counters of a server role; a client role reports no users.
+type TrafficResponse struct {
+	Users []rpcServer.UserTraffic `json:"users"`
+}
+
+// UsersResponse is the JSON payload returned by GET /api/users.
+type UsersResponse struct {
+	Users []rpcServer.UserInfo `json:"users"`
+}
+
+// RemoveUserResponse is the JSON payload returned by DELETE /api/users/{uuid}.
+type RemoveUserResponse struct {
+	Terminated int `json:"terminated"`
+}
+
+// maxRequestBody bounds request bodies; a user entry is well below it.
+const maxRequestBody = 64 << 10
+
+// ReloadFunc reloads the running configuration and reports what changed.
+type ReloadFunc func() (*config.Changes, error)
+
+var (
+	reloadMu sync.RWMutex
+	reload   ReloadFunc
+)
+
+// SetReloadFunc installs the function behind POST /api/reload. Without one
+// the endpoint answers 503.
+func SetReloadFunc(f ReloadFunc) {
+	reloadMu.Lock()
+	reload = f
+	reloadMu.Unlock()
 }
 
 // ipIsLoopback reports whether a "host:port" (or bare "host") string refers to a
@@ -66,8 +114,23 @@ func hostHeaderAllowed(host string) bool {
 	return ip != nil && ip.IsLoopback()
 }
 
+// originAllowed rejects requests a browser sends on behalf of another site.
+// The Host check alone does not stop a page from posting to the loopback
+// address directly, which matters now that endpoints can add and revoke users.
+// Non-browser clients send no Origin and are unaffected.
+func originAllowed(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return hostHeaderAllowed(u.Host)
+}
+
 // loopbackGuard rejects any request that does not originate from loopback or
-// carries a non-loopback Host header. Applied uniformly to every endpoint as a
+// carries a non-loopback Host or Origin header. Applied uniformly to every endpoint as a
 // defense-in-depth layer on top of the loopback-only listener bind.
 func loopbackGuard(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -79,6 +142,10 @@ func loopbackGuard(next http.Handler) http.Handler {
 			http.Error(w, "forbidden", http.StatusForbidden)
 			return
 		}
+		if !originAllowed(r.Header.Get("Origin")) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
 		next.ServeHTTP(w, r)
 	})
 }
@@ -88,6 +155,10 @@ func handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/stats", handleStats)
 	mux.HandleFunc("/api/health", handleHealth)
+	mux.HandleFunc("/api/traffic", handleTraffic)
+	mux.HandleFunc("/api/reload", handleReload)
+	mux.HandleFunc("/api/users", handleUsers)
+	mux.HandleFunc("/api/users/{uuid}", handleUser)
 	return loopbackGuard(mux)
 }
 
@@ -142,6 +213,7 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 	txSpeed, rxSpeed := transport.GlobalStats.CalculateSpeed()
 	total, active, load := rpcClient.GetConnectionSummary()
 	details := rpcClient.GetConnectionDetails()
+	hits, misses, entries := dns.GlobalCacheStats.Snapshot()
 
 	resp := StatsResponse{
 		TxTotalBytes: tx,
@@ -152,6 +224,11 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 		PoolActive:   active,
 		PoolLoad:     load,
 		Connections:  details,
+		Upstreams:    rpcClient.GetUpstreamDetails(),
+
+		DNSCacheHits:    hits,
+		DNSCacheMisses:  misses,
+		DNSCacheEntries: entries,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -160,6 +237,131 @@ func handleStats(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func handleTraffic(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// a client role, or a server not serving yet, reports no users
+	users, err := rpcServer.GetUserTraffic()
+	if err != nil && !errors.Is(err, rpcServer.ErrNotRunning) {
+		http.Error(w, err.Error(), userErrorStatus(err))
+		return
+	}
+	resp := TrafficResponse{Users: users}
+	if resp.Users == nil {
+		resp.Users = []rpcServer.UserTraffic{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("management: encode traffic error: %v", err)
+	}
+}
+
+func handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	reloadMu.RLock()
+	f := reload
+	reloadMu.RUnlock()
+	if f == nil {
+		http.Error(w, "reload not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	changes, err := f()
+	if err != nil {
+		log.Printf("management: reload failed: %v", err)
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(changes); err != nil {
+		log.Printf("management: encode reload error: %v", err)
+	}
+}
+
+// handleUsers lists the server users on GET and adds one on POST. Users added
+// here live until the next reload, which restores the configured list.
+func handleUsers(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		users, err := rpcServer.ListUsers()
+		if err != nil {
+			http.Error(w, err.Error(), userErrorStatus(err))
+			return
+		}
+		resp := UsersResponse{Users: users}
+		if resp.Users == nil {
+			resp.Users = []rpcServer.UserInfo{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			log.Printf("management: encode users error: %v", err)
+		}
+	case http.MethodPost:
+		var user serverConfig.User
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&user); err != nil {
+			http.Error(w, "invalid user: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := rpcServer.AddUser(&user); err != nil {
+			http.Error(w, err.Error(), userErrorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUser revokes a server user on DELETE. With ?terminate=true the user's
+// open streams are cut immediately instead of being left to finish.
+func handleUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	terminate := false
+	if v := r.URL.Query().Get("terminate"); v != "" {
+		var err error
+		if terminate, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid terminate value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	n, err := rpcServer.RemoveUser(r.PathValue("uuid"), terminate)
+	if err != nil {
+		http.Error(w, err.Error(), userErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(RemoveUserResponse{Terminated: n}); err != nil {
+		log.Printf("management: encode remove user error: %v", err)
+	}
+}
+
+func userErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, rpcServer.ErrNotRunning):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, rpcServer.ErrUserNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, rpcServer.ErrUserExists), errors.Is(err, rpcServer.ErrLastUser):
+		return http.StatusConflict
+	default:
+		return http.StatusBadRequest
+	}
+}
+
 func handleHealth(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -3,12 +3,18 @@ package management
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
+
+	rpcServer "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/server"
+	"github.com/SuzukiHonoka/spaceship/v2/pkg/config"
+	serverConfig "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
 )
 
 func TestIPIsLoopback(t *testing.T) {
@@ -70,6 +76,18 @@ func TestLoopbackGuard(t *testing.T) {
 		{"ipv6 loopback ok", "[::1]:5555", "[::1]:19999", http.StatusOK},
 	}
 
+	t.Run("cross-site origin", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/users", nil)
+		req.RemoteAddr = "127.0.0.1:5555"
+		req.Host = "127.0.0.1:19999"
+		req.Header.Set("Origin", "https://evil.com")
+		rec := httptest.NewRecorder()
+		loopbackGuard(http.NotFoundHandler()).ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Errorf("status = %d, want 403", rec.Code)
+		}
+	})
+
 	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
 		w.WriteHeader(http.StatusOK)
 	})
@@ -244,3 +262,174 @@ func TestHandleStats_EncodeErrorIsHandled(t *testing.T) {
 	// Must not panic even when the response writer fails.
 	handleStats(w, req)
 }
+
+// runServer serves an rpc server with users until the test ends.
+func runServer(t *testing.T, users serverConfig.Users) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	s, err := rpcServer.NewServer(ctx, users, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = s.ListenAndServe("127.0.0.1:0")
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err := rpcServer.ListUsers(); err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("rpc server did not start")
+		}
+	}
+}
+
+func TestHandleTraffic(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/traffic", nil)
+	rec := httptest.NewRecorder()
+	handleTraffic(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var resp TrafficResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("response is not valid TrafficResponse JSON: %v", err)
+	}
+	if resp.Users == nil {
+		t.Error("users = null, want an empty list")
+	}
+}
+
+func TestHandleTraffic_MethodNotAllowed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/traffic", nil)
+	rec := httptest.NewRecorder()
+	handleTraffic(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want 405", rec.Code)
+	}
+}
+
+func TestHandleReload(t *testing.T) {
+	t.Cleanup(func() { SetReloadFunc(nil) })
+
+	req := httptest.NewRequest(http.MethodPost, "/api/reload", nil)
+	rec := httptest.NewRecorder()
+	handleReload(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status without reload func = %d, want 503", rec.Code)
+	}
+
+	SetReloadFunc(func() (*config.Changes, error) {
+		return &config.Changes{Applied: []string{"route"}}, nil
+	})
+	rec = httptest.NewRecorder()
+	handleReload(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	var changes config.Changes
+	if err := json.Unmarshal(rec.Body.Bytes(), &changes); err != nil {
+		t.Fatalf("response is not valid Changes JSON: %v", err)
+	}
+	if len(changes.Applied) != 1 || changes.Applied[0] != "route" {
+		t.Errorf("applied = %v, want [route]", changes.Applied)
+	}
+
+	SetReloadFunc(func() (*config.Changes, error) {
+		return nil, errors.New("bad config")
+	})
+	rec = httptest.NewRecorder()
+	handleReload(rec, req)
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("status on failure = %d, want 422", rec.Code)
+	}
+}
+
+func TestHandleReload_MethodNotAllowed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/reload", nil)
+	rec := httptest.NewRecorder()
+	handleReload(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want 405", rec.Code)
+	}
+}
+
+func TestHandleUsers(t *testing.T) {
+	runServer(t, serverConfig.Users{{UUID: "first"}})
+	h := handler()
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.RemoteAddr = "127.0.0.1:12345"
+		req.Host = "127.0.0.1:19999"
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodPost, "/api/users", `{"uuid":"second","quota":5}`); rec.Code != http.StatusCreated {
+		t.Fatalf("add status = %d, want 201", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/users", `{"uuid":"second"}`); rec.Code != http.StatusConflict {
+		t.Errorf("duplicate add status = %d, want 409", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/users", `{`); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed add status = %d, want 400", rec.Code)
+	}
+
+	rec := do(http.MethodGet, "/api/users", "")
+	var list UsersResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil {
+		t.Fatalf("response is not valid UsersResponse JSON: %v", err)
+	}
+	if len(list.Users) != 2 || list.Users[1].UUID != "second" || list.Users[1].Quota != 5 {
+		t.Fatalf("users = %+v, want first and second", list.Users)
+	}
+
+	rec = do(http.MethodDelete, "/api/users/second?terminate=true", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("remove status = %d, want 200", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/api/users/second", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("second remove status = %d, want 404", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/api/users/first", ""); rec.Code != http.StatusConflict {
+		t.Errorf("removing the last user status = %d, want 409", rec.Code)
+	}
+	if rec := do(http.MethodDelete, "/api/users/first?terminate=maybe", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad terminate status = %d, want 400", rec.Code)
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{"", true}, // not a browser
+		{"http://127.0.0.1:19999", true},
+		{"http://localhost:19999", true},
+		{"https://evil.com", false},
+		{"null", false}, // sandboxed or file:// pages
+	}
+	for _, tt := range tests {
+		if got := originAllowed(tt.in); got != tt.want {
+			t.Errorf("originAllowed(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestHandleUser_MethodNotAllowed(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/users/x", nil)
+	rec := httptest.NewRecorder()
+	handleUser(rec, req)
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("status = %d, want 405", rec.Code)
+	}
+}
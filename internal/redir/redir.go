@@ -0,0 +1,226 @@
+// Package redir accepts connections the Linux firewall diverted to the client,
+// so apps that know nothing of proxies are routed like SOCKS5 and HTTP
+// clients. REDIRECT (nat table) rewrites the destination, which is recovered
+// with SO_ORIGINAL_DST; TPROXY (mangle table) leaves it in place and carries
+// UDP as well.
+package redir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
+	"golang.org/x/sync/errgroup"
+)
+
+// Mode selects the firewall target the inbound serves.
+type Mode string
+
+const (
+	// ModeRedirect serves TCP diverted by the REDIRECT target.
+	ModeRedirect Mode = "redirect"
+	// ModeTProxy serves TCP and UDP diverted by the TPROXY target; the
+	// process needs CAP_NET_ADMIN.
+	ModeTProxy Mode = "tproxy"
+)
+
+const (
+	udpIdleTimeout = 2 * time.Minute
+	// maxUDPSessions bounds the flows relayed at once, each holding two
+	// sockets and a goroutine.
+	maxUDPSessions  = 1024
+	maxUDPPacketLen = 65535
+)
+
+var ErrUnsupported = errors.New("redir: transparent proxy is only supported on Linux")
+
+type Config struct {
+	Mode Mode
+}
+
+type Server struct {
+	ctx    context.Context
+	config *Config
+
+	// originalDst recovers where a diverted connection was headed.
+	originalDst func(net.Conn) (netip.AddrPort, error)
+	getRoute    func(router.Source, string, uint16) (transport.Transport, error)
+
+	mu        sync.Mutex
+	ln        net.Listener
+	pc        *net.UDPConn
+	sessions  map[string]*udpSession
+	closeOnce sync.Once
+}
+
+func New(ctx context.Context, cfg *Config) *Server {
+	s := &Server{
+		ctx:      ctx,
+		config:   cfg,
+		getRoute: router.GetRouteFrom,
+		sessions: make(map[string]*udpSession),
+	}
+	if cfg.Mode == ModeTProxy {
+		s.originalDst = localDst
+	} else {
+		s.originalDst = originalDst
+	}
+	return s
+}
+
+// localDst is the destination of a connection TPROXY diverted: the socket
+// took the original address as its own.
+func localDst(conn net.Conn) (netip.AddrPort, error) {
+	return netip.ParseAddrPort(conn.LocalAddr().String())
+}
+
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		log.Printf("redir: shutting down")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.ln != nil {
+			_ = s.ln.Close()
+		}
+		if s.pc != nil {
+			_ = s.pc.Close()
+		}
+		for _, session := range s.sessions {
+			session.close()
+		}
+		s.sessions = nil
+	})
+	return nil
+}
+
+// ListenAndServe serves TCP on addr and, in TProxy mode, UDP as well.
+func (s *Server) ListenAndServe(_, addr string) error {
+	tproxy := s.config.Mode == ModeTProxy
+	ln, err := listenTCP(s.ctx, addr, tproxy)
+	if err != nil {
+		return fmt.Errorf("redir: listen: %w", err)
+	}
+	log.Printf("redir: listening at %s (%s)", ln.Addr(), s.config.Mode)
+	s.mu.Lock()
+	s.ln = ln
+	s.mu.Unlock()
+
+	errGroup, ctx := errgroup.WithContext(s.ctx)
+	if tproxy {
+		pc, err := listenUDP(s.ctx, addr)
+		if err != nil {
+			utils.Close(ln)
+			return fmt.Errorf("redir: listen udp: %w", err)
+		}
+		s.mu.Lock()
+		s.pc = pc
+		s.mu.Unlock()
+		errGroup.Go(func() error {
+			return s.serveUDP(pc)
+		})
+	}
+	errGroup.Go(func() error {
+		return s.serve(ln)
+	})
+	errGroup.Go(func() error {
+		<-ctx.Done()
+		utils.Close(s)
+		return ctx.Err()
+	})
+
+	err = errGroup.Wait()
+	if s.ctx.Err() != nil {
+		return s.ctx.Err()
+	}
+	return err
+}
+
+func (s *Server) serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer utils.Close(conn)
+			if err := s.handleConn(conn, ln.Addr()); err != nil {
+				log.Printf("redir: %v", err)
+			}
+		}()
+	}
+}
+
+// isLoop reports whether dst is self, an address the inbound listens on.
+// Connections made to the inbound directly, not diverted, appear to be
+// headed there. A wildcard listener covers every local address, of which
+// only loopback is told apart here.
+func isLoop(dst netip.AddrPort, self net.Addr) bool {
+	ap, err := netip.ParseAddrPort(self.String())
+	if err != nil || dst.Port() != ap.Port() {
+		return false
+	}
+	if ap.Addr().IsUnspecified() {
+		return dst.Addr().IsLoopback()
+	}
+	return dst.Addr().Unmap() == ap.Addr().Unmap()
+}
+
+// host names the destination for routing: a fake address stands for its name.
+func host(dst netip.AddrPort) string {
+	return fakeip.Resolve(dst.Addr().Unmap().String())
+}
+
+// source is where traffic from the app at src comes from, as routes see it.
+func (s *Server) source(src netip.AddrPort) router.Source {
+	inbound := router.InboundRedir
+	if s.config.Mode == ModeTProxy {
+		inbound = router.InboundTProxy
+	}
+	return router.Source{Inbound: inbound, Addr: src.Addr().Unmap()}
+}
+
+func (s *Server) handleConn(conn net.Conn, self net.Addr) error {
+	dst, err := s.originalDst(conn)
+	if err != nil {
+		return fmt.Errorf("%s: original destination: %w", conn.RemoteAddr(), err)
+	}
+	// SO_ORIGINAL_DST of a connection REDIRECT did not touch is its own
+	// local address
+	if isLoop(dst, self) || s.config.Mode != ModeTProxy && isLoop(dst, conn.LocalAddr()) {
+		return fmt.Errorf("%s: connected to the inbound itself, not diverted", conn.RemoteAddr())
+	}
+
+	host := host(dst)
+	src, _ := netip.ParseAddrPort(conn.RemoteAddr().String())
+	route, err := s.getRoute(s.source(src), host, dst.Port())
+	if err != nil {
+		return fmt.Errorf("no route for %s: %w", host, err)
+	}
+	defer utils.Close(route)
+
+	log.Printf("redir: %s:%d -> %s", host, dst.Port(), route)
+
+	addr := net.JoinHostPort(host, strconv.Itoa(int(dst.Port())))
+	localAddr := make(chan string)
+	go func() {
+		// the local address has no one to be reported to
+		for range localAddr {
+		}
+	}()
+	if err = route.Proxy(s.ctx, addr, localAddr, conn, conn); err != nil &&
+		!errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("%s failed: %w", addr, err)
+	}
+	return nil
+}
@@ -0,0 +1,151 @@
+package redir
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/direct"
+)
+
+// diverted returns the two ends of a loopback TCP connection, standing in
+// for one the firewall diverted to the inbound.
+func diverted(t *testing.T) (app, inbound net.Conn) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	app, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	inbound, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = app.Close()
+		_ = inbound.Close()
+	})
+	return app, inbound
+}
+
+func newTestServer(ctx context.Context, dst netip.AddrPort) *Server {
+	s := New(ctx, &Config{Mode: ModeRedirect})
+	s.originalDst = func(net.Conn) (netip.AddrPort, error) { return dst, nil }
+	s.getRoute = func(router.Source, string, uint16) (transport.Transport, error) { return direct.New(), nil }
+	return s
+}
+
+func TestHandleConnRelaysToOriginalDestination(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newTestServer(ctx, netip.MustParseAddrPort(echo.Addr().String()))
+
+	app, inbound := diverted(t)
+	done := make(chan error, 1)
+	go func() { done <- s.handleConn(inbound, &net.TCPAddr{IP: net.IPv4zero, Port: 1}) }()
+
+	if _, err := app.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	_ = app.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(app, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+	_ = app.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("handleConn() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleConn() did not return after the app closed")
+	}
+}
+
+func TestHandleConnRejectsLoop(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, inbound := diverted(t)
+	// without a NAT entry the original destination is the connection itself
+	s := newTestServer(ctx, netip.MustParseAddrPort(inbound.LocalAddr().String()))
+	s.getRoute = func(router.Source, string, uint16) (transport.Transport, error) {
+		t.Fatal("looped connection was routed")
+		return nil, errors.New("unreachable")
+	}
+	if err := s.handleConn(inbound, &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}); err == nil {
+		t.Fatal("handleConn() accepted a connection to the inbound itself")
+	}
+}
+
+func TestHandleConnRoutesBySource(t *testing.T) {
+	t.Cleanup(func() { _ = router.SetRoutes(nil) })
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, tt := range []struct {
+		mode  Mode
+		route *router.Route
+	}{
+		{ModeRedirect, &router.Route{MatchType: router.TypeInbound, Sources: []string{"redir"}, Destination: router.EgressBlock}},
+		{ModeTProxy, &router.Route{MatchType: router.TypeInbound, Sources: []string{"tproxy"}, Destination: router.EgressBlock}},
+		{ModeRedirect, &router.Route{MatchType: router.TypeSource, Sources: []string{"127.0.0.1"}, Destination: router.EgressBlock}},
+	} {
+		if err := router.SetRoutes(router.Routes{tt.route, {MatchType: router.TypeDefault, Destination: router.EgressDirect}}); err != nil {
+			t.Fatal(err)
+		}
+		s := newTestServer(ctx, netip.MustParseAddrPort("127.0.0.1:9"))
+		s.config.Mode = tt.mode
+		s.getRoute = router.GetRouteFrom
+
+		_, inbound := diverted(t)
+		err := s.handleConn(inbound, &net.TCPAddr{IP: net.IPv4zero, Port: 1})
+		if !errors.Is(err, transport.ErrBlocked) {
+			t.Errorf("%s: handleConn() by %s route = %v, want ErrBlocked", tt.mode, tt.route.MatchType, err)
+		}
+	}
+}
+
+func TestIsLoop(t *testing.T) {
+	tests := []struct {
+		dst  string
+		self net.Addr
+		want bool
+	}{
+		{"127.0.0.1:1080", &net.TCPAddr{IP: net.IPv4zero, Port: 1080}, true},
+		{"[::1]:1080", &net.TCPAddr{IP: net.IPv6unspecified, Port: 1080}, true},
+		{"192.0.2.1:1080", &net.TCPAddr{IP: net.IPv4zero, Port: 1080}, false},
+		{"192.0.2.1:1080", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1080}, true},
+		{"192.0.2.1:80", &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1080}, false},
+	}
+	for _, tt := range tests {
+		if got := isLoop(netip.MustParseAddrPort(tt.dst), tt.self); got != tt.want {
+			t.Errorf("isLoop(%s, %s) = %t, want %t", tt.dst, tt.self, got, tt.want)
+		}
+	}
+}
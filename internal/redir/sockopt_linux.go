@@ -0,0 +1,165 @@
+package redir
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+	"unsafe"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"golang.org/x/sys/unix"
+)
+
+// SO_ORIGINAL_DST and IP6T_SO_ORIGINAL_DST from the netfilter headers.
+const (
+	soOriginalDst     = 80
+	ip6tSoOriginalDst = 80
+)
+
+// setTransparent lets the socket take connections and datagrams addressed
+// elsewhere, and bind to addresses that are not local. A dual-stack socket
+// needs the option at both levels; either one failing is fine as long as the
+// other holds.
+func setTransparent(fd int) error {
+	err4 := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+	err6 := unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+	if err4 != nil && err6 != nil {
+		return fmt.Errorf("set IP_TRANSPARENT: %w", err4)
+	}
+	return nil
+}
+
+// control applies fn to the socket before it is bound.
+func control(fn func(fd int) error) func(string, string, syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) { err = fn(int(fd)) }); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
+
+func listenTCP(ctx context.Context, addr string, tproxy bool) (net.Listener, error) {
+	var lc net.ListenConfig
+	if tproxy {
+		lc.Control = control(setTransparent)
+	}
+	return lc.Listen(ctx, transport.DialNetwork("tcp"), addr)
+}
+
+func listenUDP(ctx context.Context, addr string) (*net.UDPConn, error) {
+	lc := net.ListenConfig{Control: control(func(fd int) error {
+		if err := setTransparent(fd); err != nil {
+			return err
+		}
+		err4 := unix.SetsockoptInt(fd, unix.SOL_IP, unix.IP_RECVORIGDSTADDR, 1)
+		err6 := unix.SetsockoptInt(fd, unix.SOL_IPV6, unix.IPV6_RECVORIGDSTADDR, 1)
+		if err4 != nil && err6 != nil {
+			return fmt.Errorf("set IP_RECVORIGDSTADDR: %w", err4)
+		}
+		return nil
+	})}
+	pc, err := lc.ListenPacket(ctx, transport.DialNetwork("udp"), addr)
+	if err != nil {
+		return nil, err
+	}
+	return pc.(*net.UDPConn), nil
+}
+
+// listenReply opens a socket bound to dst, a foreign address, to send
+// replies from. Every flow to dst has one, hence SO_REUSEADDR.
+func listenReply(dst netip.AddrPort) (*net.UDPConn, error) {
+	network := "udp6"
+	if dst.Addr().Is4() {
+		network = "udp4"
+	}
+	lc := net.ListenConfig{Control: control(func(fd int) error {
+		if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+			return fmt.Errorf("set SO_REUSEADDR: %w", err)
+		}
+		return setTransparent(fd)
+	})}
+	pc, err := lc.ListenPacket(context.Background(), network, dst.String())
+	if err != nil {
+		return nil, err
+	}
+	return pc.(*net.UDPConn), nil
+}
+
+// originalDst asks conntrack where a connection REDIRECT rewrote was headed.
+func originalDst(conn net.Conn) (netip.AddrPort, error) {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return netip.AddrPort{}, errors.New("not a TCP connection")
+	}
+	raw, err := tc.SyscallConn()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+
+	var dst netip.AddrPort
+	var opErr error
+	err = raw.Control(func(fd uintptr) {
+		// the socket address comes back in a struct of the right size that
+		// x/sys has a getter for
+		if local, _ := netip.ParseAddrPort(conn.LocalAddr().String()); local.Addr().Is4() || local.Addr().Is4In6() {
+			var mreq *unix.IPv6Mreq
+			if mreq, opErr = unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, soOriginalDst); opErr == nil {
+				dst = sockaddrInet4(mreq.Multiaddr[:])
+			}
+			return
+		}
+		var info *unix.IPv6MTUInfo
+		if info, opErr = unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, ip6tSoOriginalDst); opErr == nil {
+			port := (*[2]byte)(unsafe.Pointer(&info.Addr.Port))
+			dst = netip.AddrPortFrom(netip.AddrFrom16(info.Addr.Addr), uint16(port[0])<<8|uint16(port[1]))
+		}
+	})
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	if opErr != nil {
+		return netip.AddrPort{}, fmt.Errorf("get SO_ORIGINAL_DST: %w", opErr)
+	}
+	return dst, nil
+}
+
+// sockaddrInet4 decodes a struct sockaddr_in.
+func sockaddrInet4(b []byte) netip.AddrPort {
+	return netip.AddrPortFrom(netip.AddrFrom4([4]byte(b[4:8])), uint16(b[2])<<8|uint16(b[3]))
+}
+
+// readFromOrigDst reads a datagram along with the destination it was sent
+// to, which TPROXY hands over in a control message.
+func readFromOrigDst(pc *net.UDPConn, buf, oob []byte) (n int, src, dst netip.AddrPort, err error) {
+	n, oobn, _, src, err := pc.ReadMsgUDPAddrPort(buf, oob)
+	if err != nil {
+		return 0, src, dst, err
+	}
+	dst, err = parseOrigDst(oob[:oobn])
+	return n, src, dst, err
+}
+
+func parseOrigDst(oob []byte) (netip.AddrPort, error) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("parse control message: %w", err)
+	}
+	for i := range msgs {
+		sa, err := unix.ParseOrigDstAddr(&msgs[i])
+		if err != nil {
+			continue
+		}
+		switch sa := sa.(type) {
+		case *unix.SockaddrInet4:
+			return netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), uint16(sa.Port)), nil
+		case *unix.SockaddrInet6:
+			return netip.AddrPortFrom(netip.AddrFrom16(sa.Addr), uint16(sa.Port)), nil
+		}
+	}
+	return netip.AddrPort{}, errors.New("no original destination in control message")
+}
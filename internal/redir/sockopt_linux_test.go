@@ -0,0 +1,49 @@
+package redir
+
+import (
+	"net/netip"
+	"testing"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// cmsg builds a control message carrying a socket address.
+func cmsg(level, typ int, sa unsafe.Pointer, size int) []byte {
+	b := make([]byte, unix.CmsgSpace(size))
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = int32(level)
+	h.Type = int32(typ)
+	h.SetLen(unix.CmsgLen(size))
+	copy(b[unix.CmsgLen(0):], unsafe.Slice((*byte)(sa), size))
+	return b
+}
+
+func TestParseOrigDst(t *testing.T) {
+	sa4 := unix.RawSockaddrInet4{Family: unix.AF_INET, Addr: [4]byte{192, 0, 2, 1}}
+	port := (*[2]byte)(unsafe.Pointer(&sa4.Port))
+	port[0], port[1] = 0x01, 0xbb // 443
+	got, err := parseOrigDst(cmsg(unix.SOL_IP, unix.IP_ORIGDSTADDR, unsafe.Pointer(&sa4), unix.SizeofSockaddrInet4))
+	if want := netip.MustParseAddrPort("192.0.2.1:443"); err != nil || got != want {
+		t.Fatalf("parseOrigDst(v4) = %s, %v, want %s", got, err, want)
+	}
+
+	sa6 := unix.RawSockaddrInet6{Family: unix.AF_INET6, Addr: netip.MustParseAddr("2001:db8::1").As16()}
+	port = (*[2]byte)(unsafe.Pointer(&sa6.Port))
+	port[0], port[1] = 0x00, 0x35 // 53
+	got, err = parseOrigDst(cmsg(unix.SOL_IPV6, unix.IPV6_ORIGDSTADDR, unsafe.Pointer(&sa6), unix.SizeofSockaddrInet6))
+	if want := netip.MustParseAddrPort("[2001:db8::1]:53"); err != nil || got != want {
+		t.Fatalf("parseOrigDst(v6) = %s, %v, want %s", got, err, want)
+	}
+
+	if _, err = parseOrigDst(nil); err == nil {
+		t.Fatal("parseOrigDst() accepted a datagram without the original destination")
+	}
+}
+
+func TestSockaddrInet4(t *testing.T) {
+	b := []byte{unix.AF_INET, 0, 0x1f, 0x90, 203, 0, 113, 7, 0, 0, 0, 0, 0, 0, 0, 0}
+	if got, want := sockaddrInet4(b), netip.MustParseAddrPort("203.0.113.7:8080"); got != want {
+		t.Fatalf("sockaddrInet4() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !linux
+
+package redir
+
+import (
+	"context"
+	"net"
+	"net/netip"
+)
+
+func listenTCP(context.Context, string, bool) (net.Listener, error) {
+	return nil, ErrUnsupported
+}
+
+func listenUDP(context.Context, string) (*net.UDPConn, error) {
+	return nil, ErrUnsupported
+}
+
+func listenReply(netip.AddrPort) (*net.UDPConn, error) {
+	return nil, ErrUnsupported
+}
+
+func originalDst(net.Conn) (netip.AddrPort, error) {
+	return netip.AddrPort{}, ErrUnsupported
+}
+
+func readFromOrigDst(*net.UDPConn, []byte, []byte) (int, netip.AddrPort, netip.AddrPort, error) {
+	return 0, netip.AddrPort{}, netip.AddrPort{}, ErrUnsupported
+}
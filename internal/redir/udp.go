@@ -0,0 +1,160 @@
+package redir
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
+)
+
+// udpSession relays one flow, keyed by source and original destination.
+// Replies leave through a socket bound to the original destination, so the
+// app sees them come from the host it sent to.
+type udpSession struct {
+	out       net.PacketConn
+	reply     *net.UDPConn
+	lastSeen  atomic.Int64
+	closeOnce sync.Once
+}
+
+func (u *udpSession) close() {
+	u.closeOnce.Do(func() {
+		_ = u.out.Close()
+		_ = u.reply.Close()
+	})
+}
+
+func (s *Server) serveUDP(pc *net.UDPConn) error {
+	buf := make([]byte, maxUDPPacketLen)
+	oob := make([]byte, 1024)
+	for {
+		n, src, dst, err := readFromOrigDst(pc, buf, oob)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			log.Printf("redir: udp: %v", err)
+			continue
+		}
+		src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+		dst = netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port())
+
+		session, err := s.udpSession(pc, src, dst)
+		if err != nil {
+			log.Printf("redir: udp %s -> %s: %v", src, dst, err)
+			continue
+		}
+		session.lastSeen.Store(time.Now().UnixNano())
+		nw, err := session.out.WriteTo(buf[:n], net.UDPAddrFromAddrPort(dst))
+		if err != nil {
+			log.Printf("redir: udp %s -> %s: %v", src, dst, err)
+			continue
+		}
+		transport.GlobalStats.AddTx(uint64(nw))
+	}
+}
+
+// udpSession returns the session of the flow from src to dst, opening it on
+// the first datagram. Only serveUDP opens sessions, so the lock is not held
+// while dialing.
+func (s *Server) udpSession(pc *net.UDPConn, src, dst netip.AddrPort) (*udpSession, error) {
+	key := src.String() + "|" + dst.String()
+	s.mu.Lock()
+	session, ok := s.sessions[key]
+	full := len(s.sessions) >= maxUDPSessions
+	s.mu.Unlock()
+	if ok {
+		return session, nil
+	}
+	if full {
+		return nil, errors.New("too many sessions")
+	}
+	if isLoop(dst, pc.LocalAddr()) {
+		return nil, errors.New("sent to the inbound itself, not diverted")
+	}
+
+	host := host(dst)
+	route, err := s.getRoute(s.source(src), host, dst.Port())
+	if err != nil {
+		return nil, fmt.Errorf("no route for %s: %w", host, err)
+	}
+	// the egress must carry UDP itself; sending from a local socket instead
+	// would bypass the tunnel
+	pd, ok := route.(transport.PacketDialer)
+	if !ok {
+		utils.Close(route)
+		return nil, fmt.Errorf("egress %s does not support UDP", route)
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(dst.Port())))
+	out, err := pd.DialPacket("udp", addr)
+	if err != nil {
+		utils.Close(route)
+		return nil, fmt.Errorf("dial packet: %w", err)
+	}
+	reply, err := listenReply(dst)
+	if err != nil {
+		_ = out.Close()
+		utils.Close(route)
+		return nil, fmt.Errorf("reply socket: %w", err)
+	}
+	log.Printf("redir: udp %s -> %s", addr, route)
+
+	session = &udpSession{out: out, reply: reply}
+	session.lastSeen.Store(time.Now().UnixNano())
+	s.mu.Lock()
+	if s.sessions == nil {
+		// closed meanwhile
+		s.mu.Unlock()
+		session.close()
+		utils.Close(route)
+		return nil, net.ErrClosed
+	}
+	s.sessions[key] = session
+	s.mu.Unlock()
+	go func() {
+		defer utils.Close(route)
+		s.reverseRelay(session, src)
+		s.mu.Lock()
+		if s.sessions[key] == session {
+			delete(s.sessions, key)
+		}
+		s.mu.Unlock()
+	}()
+	return session, nil
+}
+
+// reverseRelay sends replies to src until the flow has been idle for
+// udpIdleTimeout in both directions.
+func (s *Server) reverseRelay(session *udpSession, src netip.AddrPort) {
+	defer session.close()
+	buf := make([]byte, maxUDPPacketLen)
+	for {
+		_ = session.out.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		n, _, err := session.out.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := errors.AsType[net.Error](err); ok && ne.Timeout() &&
+				time.Since(time.Unix(0, session.lastSeen.Load())) < udpIdleTimeout {
+				continue
+			}
+			return
+		}
+		session.lastSeen.Store(time.Now().UnixNano())
+		nw, err := session.reply.WriteToUDPAddrPort(buf[:n], src)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Printf("redir: udp reply to %s: %v", src, err)
+			continue
+		}
+		transport.GlobalStats.AddRx(uint64(nw))
+	}
+}
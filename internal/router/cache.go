@@ -1,6 +1,10 @@
 package router
 
 import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
 	"sync"
 
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
@@ -10,6 +14,7 @@ import (
 var (
 	routesMu      sync.RWMutex
 	routesCache   Routes
+	routesMatcher = compileRoutes(nil)
 	routesVersion uint64
 	table         = newSyncedRoutesTable(maxCacheSize)
 )
@@ -21,9 +26,7 @@ func AddToFirstRoute(r *Route) error {
 	}
 	routesMu.Lock()
 	defer routesMu.Unlock()
-	routesCache = append(prepared, routesCache...)
-	routesVersion++
-	table.Reset()
+	installRoutesLocked(append(prepared, routesCache...))
 	return nil
 }
 
@@ -34,9 +37,7 @@ func AddToLastRoute(r *Route) error {
 	}
 	routesMu.Lock()
 	defer routesMu.Unlock()
-	routesCache = append(routesCache, prepared...)
-	routesVersion++
-	table.Reset()
+	installRoutesLocked(append(slices.Clip(routesCache), prepared...))
 	return nil
 }
 
@@ -50,14 +51,65 @@ func normalizeRouteKey(dst string) string {
 	return dst
 }
 
-func GetRoute(dst string) (transport.Transport, error) {
+// decisionKey is the key routing decisions for key, a normalizeRouteKey
+// result, at port are cached by: the port counts only while routes match by
+// it.
+func decisionKey(key string, port uint16, byPort bool) string {
+	if !byPort || port == 0 {
+		return key
+	}
+	return net.JoinHostPort(key, strconv.Itoa(int(port)))
+}
+
+// GetRoute returns the transport for traffic to dst at port, zero if the
+// port is unknown.
+func GetRoute(dst string, port uint16) (transport.Transport, error) {
+	return GetRouteFrom(Source{}, dst, port)
+}
+
+// GetRouteFrom returns the transport for traffic from src to dst at port.
+func GetRouteFrom(src Source, dst string, port uint16) (transport.Transport, error) {
+	egress, err := GetEgressFrom(src, dst, port)
+	if err != nil {
+		return nil, err
+	}
+	return egress.GetTransportFor(normalizeRouteKey(dst))
+}
+
+// GetEgress returns where traffic to dst at port is routed without setting up
+// a transport, which for EgressProxy would check out a pooled connection.
+func GetEgress(dst string, port uint16) (Egress, error) {
+	return GetEgressFrom(Source{}, dst, port)
+}
+
+// GetEgressFrom is GetEgress for traffic from src.
+func GetEgressFrom(src Source, dst string, port uint16) (Egress, error) {
 	key := normalizeRouteKey(dst)
+	// Matching may resolve dst for geoip routes, so it runs on a snapshot
+	// rather than under the lock, which would hold up installing routes and
+	// every lookup queued behind that.
 	routesMu.RLock()
-	defer routesMu.RUnlock()
-	if route, ok := table.Get(key); ok {
-		return route.GetTransport()
+	matcher, version := routesMatcher, routesVersion
+	routesMu.RUnlock()
+
+	cacheKey := decisionKey(key, port, matcher.ports)
+	if matcher.sources {
+		cacheKey = src.key() + cacheKey
+	}
+	if egress, ok := table.Get(cacheKey); ok {
+		return egress, nil
+	}
+	route, ok := matcher.matchFrom(src, key, port)
+	if !ok {
+		return EgressUnknown, fmt.Errorf("route not found: %s -> nil", key)
 	}
-	return routesCache.GetRoute(key)
+	// a decision of routes replaced meanwhile is not cached
+	routesMu.RLock()
+	if version == routesVersion {
+		table.Set(cacheKey, route.Destination)
+	}
+	routesMu.RUnlock()
+	return route.Destination, nil
 }
 
 // AnyRouteSupportsUDP reports whether any installed route has an egress capable
@@ -86,31 +138,99 @@ func GenerateCache() error {
 			return err
 		}
 
+		matcher := compileRoutes(snapshot)
 		routesMu.Lock()
 		if version != routesVersion {
 			routesMu.Unlock()
 			continue
 		}
-		routesCache = snapshot
-		routesVersion++
-		table.Reset()
+		installMatcherLocked(matcher)
 		routesMu.Unlock()
 		return nil
 	}
 }
 
+// regenerateRoutes rebuilds the caches of the installed routes that are due
+// and swaps in the rebuilt ones that keep accepts, given the error of the
+// rebuild, leaving the rest of the routes untouched. Routes installed
+// meanwhile win, having been rebuilt as a whole. It returns how many routes
+// were swapped.
+func regenerateRoutes(due func(*Route) bool, keep func(*Route, error) bool) int {
+	routesMu.RLock()
+	snapshot := slices.Clone(routesCache)
+	version := routesVersion
+	routesMu.RUnlock()
+
+	swapped := 0
+	for i, route := range snapshot {
+		if route == nil || !due(route) {
+			continue
+		}
+		next := CloneRoute(route)
+		if !keep(next, next.GenerateCache()) {
+			continue
+		}
+		snapshot[i] = next
+		swapped++
+	}
+	if swapped == 0 {
+		return 0
+	}
+	matcher := compileRoutes(snapshot)
+
+	routesMu.Lock()
+	defer routesMu.Unlock()
+	if version != routesVersion {
+		return 0
+	}
+	installMatcherLocked(matcher)
+	return swapped
+}
+
 func SetRoutes(r Routes) error {
-	prepared, err := prepareRoutes(r)
+	prepared, err := NewRoutes(r)
 	if err != nil {
 		return err
 	}
+	InstallRoutes(prepared)
+	return nil
+}
+
+// PreparedRoutes are routes whose caches are generated, ready to be
+// installed by InstallRoutes.
+type PreparedRoutes struct {
+	matcher *routeMatcher
+}
+
+// NewRoutes prepares routes to be installed by InstallRoutes.
+func NewRoutes(r Routes) (*PreparedRoutes, error) {
+	prepared, err := prepareRoutes(r)
+	if err != nil {
+		return nil, err
+	}
+	return &PreparedRoutes{matcher: compileRoutes(prepared)}, nil
+}
 
+// InstallRoutes replaces the shared routes.
+func InstallRoutes(p *PreparedRoutes) {
 	routesMu.Lock()
 	defer routesMu.Unlock()
-	routesCache = prepared
+	installMatcherLocked(p.matcher)
+}
+
+// installRoutesLocked makes routes, whose caches are generated, the
+// installed ones. routesMu must be held for writing.
+func installRoutesLocked(routes Routes) {
+	installMatcherLocked(compileRoutes(routes))
+}
+
+// installMatcherLocked installs the routes of m, compiled beforehand so that
+// lookups are not held up meanwhile.
+func installMatcherLocked(m *routeMatcher) {
+	routesCache = m.routes
+	routesMatcher = m
 	routesVersion++
 	table.Reset()
-	return nil
 }
 
 func prepareRoutes(routes Routes) (Routes, error) {
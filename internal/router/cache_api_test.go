@@ -24,7 +24,7 @@ func TestAddToFirstAndLastRoute(t *testing.T) {
 		t.Fatalf("AddToFirstRoute: %v", err)
 	}
 
-	tr, err := GetRoute("blocked.example")
+	tr, err := GetRoute("blocked.example", 443)
 	if err != nil {
 		t.Fatalf("GetRoute blocked: %v", err)
 	}
@@ -41,7 +41,7 @@ func TestAddToFirstAndLastRoute(t *testing.T) {
 		t.Fatalf("AddToLastRoute: %v", err)
 	}
 
-	tr, err = GetRoute("other.example")
+	tr, err = GetRoute("other.example", 443)
 	if err != nil {
 		t.Fatalf("GetRoute other: %v", err)
 	}
@@ -51,7 +51,7 @@ func TestAddToFirstAndLastRoute(t *testing.T) {
 	_ = tr.Close()
 
 	// Prepended rule still wins over default.
-	tr, err = GetRoute("blocked.example")
+	tr, err = GetRoute("blocked.example", 443)
 	if err != nil {
 		t.Fatalf("GetRoute blocked after default: %v", err)
 	}
@@ -71,7 +71,7 @@ func TestGenerateCacheRebuilds(t *testing.T) {
 	if err := GenerateCache(); err != nil {
 		t.Fatalf("GenerateCache: %v", err)
 	}
-	tr, err := GetRoute("a.example.com")
+	tr, err := GetRoute("a.example.com", 443)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -90,3 +90,29 @@ func TestAddToFirstRouteInvalid(t *testing.T) {
 		t.Fatal("AddToFirstRoute accepted invalid regex")
 	}
 }
+
+func TestGetEgress(t *testing.T) {
+	if err := SetRoutes(Routes{
+		{MatchType: TypeDomain, Sources: []string{"cn.example"}, Destination: EgressDirect},
+		{MatchType: TypeDefault, Destination: EgressProxy},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = SetRoutes(nil) })
+
+	for dst, want := range map[string]Egress{
+		"www.cn.example.": EgressDirect,
+		"other.example":   EgressProxy,
+	} {
+		// the second lookup is answered from the route table cache
+		for range 2 {
+			got, err := GetEgress(dst, 443)
+			if err != nil {
+				t.Fatalf("GetEgress(%q): %v", dst, err)
+			}
+			if got != want {
+				t.Fatalf("GetEgress(%q) = %s, want %s", dst, got, want)
+			}
+		}
+	}
+}
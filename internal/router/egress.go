@@ -10,6 +10,8 @@ import (
 	rpcClient "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/client"
 )
 
+// Egress is where a route sends traffic: one of the built-ins below or the
+// name of an outbound.
 type Egress string
 
 const (
@@ -32,18 +34,25 @@ func (e Egress) SupportsUDP() bool {
 	case EgressDirect, EgressProxy:
 		return true
 	default:
-		return false
+		o, ok := getOutbound(string(e))
+		return ok && o.SupportsUDP()
 	}
 }
 
 func (e Egress) GetTransport() (transport.Transport, error) {
+	return e.GetTransportFor("")
+}
+
+// GetTransportFor is GetTransport for traffic to dst, which the proxy egress
+// may balance on.
+func (e Egress) GetTransportFor(dst string) (transport.Transport, error) {
 	switch e {
 	case EgressUnknown:
 		return nil, fmt.Errorf("unknown transport")
 	case EgressDirect:
 		return direct.New(), nil
 	case EgressProxy:
-		return rpcClient.New()
+		return rpcClient.NewFor(dst)
 	case EgressForward:
 		return forward.New(), nil
 	case EgressBlackHole:
@@ -51,5 +60,8 @@ func (e Egress) GetTransport() (transport.Transport, error) {
 	case EgressBlock:
 		return nil, transport.ErrBlocked
 	}
+	if o, ok := getOutbound(string(e)); ok {
+		return o.Transport(dst)
+	}
 	return nil, fmt.Errorf("desired transport [%s] not implemented", e)
 }
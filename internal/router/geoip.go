@@ -0,0 +1,145 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"github.com/oschwald/maxminddb-golang/v2"
+)
+
+// geoIPResolveTimeout bounds the lookup of a domain destination for geoip
+// routes that resolve.
+const geoIPResolveTimeout = 5 * time.Second
+
+// geoIP matches addresses by the country a MaxMind database (mmdb) places
+// them in, such as GeoLite2-Country.
+type geoIP struct {
+	reader    *maxminddb.Reader
+	countries map[string]struct{}
+}
+
+type geoIPRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// geoIPDatabase is a database read into memory, along with the state of the
+// file it was read from.
+type geoIPDatabase struct {
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+}
+
+// geoIPDatabases holds the databases read so far by path, so that the routes
+// using one, policies and rebuilds included, share a single copy.
+var (
+	geoIPDatabasesMu sync.Mutex
+	geoIPDatabases   = make(map[string]*geoIPDatabase)
+)
+
+// openGeoIPDatabase returns the reader of the database at path, reading the
+// file again only once it changed.
+func openGeoIPDatabase(path string) (*maxminddb.Reader, error) {
+	path = filepath.Clean(path)
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: %w", err)
+	}
+
+	geoIPDatabasesMu.Lock()
+	defer geoIPDatabasesMu.Unlock()
+	if db, ok := geoIPDatabases[path]; ok && db.modTime.Equal(info.ModTime()) && db.size == info.Size() {
+		return db.reader, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: %w", err)
+	}
+	reader, err := maxminddb.OpenBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("geoip: open %s: %w", path, err)
+	}
+	geoIPDatabases[path] = &geoIPDatabase{reader: reader, modTime: info.ModTime(), size: info.Size()}
+	return reader, nil
+}
+
+// loadGeoIP opens the database at path, to match the given country codes.
+// The database is kept in memory, so a reload picks up a replaced file.
+func loadGeoIP(path string, codes []string) (*geoIP, error) {
+	if path == "" {
+		return nil, fmt.Errorf("geoip: database path required")
+	}
+	reader, err := openGeoIPDatabase(path)
+	if err != nil {
+		return nil, err
+	}
+	g := &geoIP{reader: reader, countries: make(map[string]struct{}, len(codes))}
+	for _, code := range codes {
+		if code = strings.ToUpper(strings.TrimSpace(code)); code != "" {
+			g.countries[code] = struct{}{}
+		}
+	}
+	return g, nil
+}
+
+// country returns the ISO code of addr, falling back to the country the
+// network is registered in, empty if unknown.
+func (g *geoIP) country(addr netip.Addr) string {
+	var record geoIPRecord
+	if err := g.reader.Lookup(addr.Unmap()).Decode(&record); err != nil {
+		return ""
+	}
+	if record.Country.ISOCode != "" {
+		return record.Country.ISOCode
+	}
+	return record.RegisteredCountry.ISOCode
+}
+
+func (g *geoIP) matchAddr(addr netip.Addr) bool {
+	code := g.country(addr)
+	if code == "" {
+		return false
+	}
+	_, ok := g.countries[code]
+	return ok
+}
+
+// match reports whether dst is in one of the countries. A domain matches
+// only when resolve is set and any of its addresses does.
+func (g *geoIP) match(dst string, resolve bool) bool {
+	if addr, err := netip.ParseAddr(dst); err == nil {
+		return g.matchAddr(addr)
+	}
+	if !resolve || dst == "" {
+		return false
+	}
+	network := "ip"
+	if transport.PreferIPv4() {
+		network = "ip4"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), geoIPResolveTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, network, dst)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if g.matchAddr(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package router
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mmdb encodes values in the MaxMind DB data section format.
+type mmdb struct{ bytes.Buffer }
+
+func (m *mmdb) control(typ, size int) {
+	if typ <= 7 {
+		m.WriteByte(byte(typ<<5 | size))
+		return
+	}
+	m.WriteByte(byte(size))
+	m.WriteByte(byte(typ - 7))
+}
+
+func (m *mmdb) string(s string) {
+	m.control(2, len(s))
+	m.WriteString(s)
+}
+
+func (m *mmdb) uint(typ int, v uint64) {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	b = bytes.TrimLeft(b, "\x00")
+	m.control(typ, len(b))
+	m.Write(b)
+}
+
+// country writes a record holding iso_code under key.
+func (m *mmdb) country(key, code string) {
+	m.control(7, 1)
+	m.string(key)
+	m.control(7, 1)
+	m.string("iso_code")
+	m.string(code)
+}
+
+type mmdbNode struct {
+	child [2]*mmdbNode
+	data  int // data section offset of a leaf, -1 inside the tree
+}
+
+// writeGeoIPDatabase writes an IPv4 database placing each prefix in the
+// country of its record, and returns its path.
+func writeGeoIPDatabase(t *testing.T, records map[string][2]string) string {
+	t.Helper()
+	var data mmdb
+	root := &mmdbNode{data: -1}
+	for prefix, record := range records {
+		p := netip.MustParsePrefix(prefix)
+		offset := data.Len()
+		data.country(record[0], record[1])
+
+		n, ip := root, p.Addr().As4()
+		for bit := 0; bit < p.Bits(); bit++ {
+			b := ip[bit/8] >> (7 - bit%8) & 1
+			if n.child[b] == nil {
+				n.child[b] = &mmdbNode{data: -1}
+			}
+			n = n.child[b]
+		}
+		n.data = offset
+	}
+
+	var nodes []*mmdbNode
+	index := map[*mmdbNode]int{}
+	var walk func(n *mmdbNode)
+	walk = func(n *mmdbNode) {
+		index[n] = len(nodes)
+		nodes = append(nodes, n)
+		for _, c := range n.child {
+			if c != nil && c.data < 0 {
+				walk(c)
+			}
+		}
+	}
+	walk(root)
+
+	var out bytes.Buffer
+	for _, n := range nodes {
+		for _, c := range n.child {
+			record := len(nodes) // not found
+			if c != nil && c.data >= 0 {
+				record = len(nodes) + 16 + c.data
+			} else if c != nil {
+				record = index[c]
+			}
+			out.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+
+	var meta mmdb
+	meta.control(7, 5)
+	meta.string("node_count")
+	meta.uint(6, uint64(len(nodes)))
+	meta.string("record_size")
+	meta.uint(5, 24)
+	meta.string("ip_version")
+	meta.uint(5, 4)
+	meta.string("binary_format_major_version")
+	meta.uint(5, 2)
+	meta.string("database_type")
+	meta.string("Test-Country")
+	out.Write(meta.Bytes())
+
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	if err := os.WriteFile(path, out.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRoute_GeoIP(t *testing.T) {
+	db := writeGeoIPDatabase(t, map[string][2]string{
+		"1.0.1.0/24":  {"country", "CN"},
+		"8.8.8.0/24":  {"country", "US"},
+		"127.0.0.0/8": {"registered_country", "JP"},
+	})
+
+	r := &Route{Sources: []string{"cn", " JP "}, MatchType: TypeGeoIP, Database: db}
+	if err := r.GenerateCache(); err != nil {
+		t.Fatalf("GenerateCache() error = %v", err)
+	}
+	for _, tc := range []struct {
+		dst  string
+		want bool
+	}{
+		{"1.0.1.1", true},
+		{"::ffff:1.0.1.1", true},
+		{"127.0.0.1", true},
+		{"8.8.8.8", false},
+		{"9.9.9.9", false},
+		{"localhost", false},
+	} {
+		if got := r.Match(tc.dst, 443); got != tc.want {
+			t.Errorf("Match(%q) = %t, want %t", tc.dst, got, tc.want)
+		}
+	}
+
+	r.Resolve = true
+	if !r.Match("localhost", 443) {
+		t.Error("Match(localhost) = false with resolve, want its address matched")
+	}
+	if r.Match("does-not-exist.invalid", 443) {
+		t.Error("Match() of an unresolvable domain = true")
+	}
+	if r.MatchFrom(Source{Inbound: InboundDNS}, "localhost", 0) {
+		t.Error("MatchFrom() resolved a name queried through the dns inbound")
+	}
+}
+
+func TestLoadGeoIP_SharesDatabase(t *testing.T) {
+	db := writeGeoIPDatabase(t, map[string][2]string{"1.0.1.0/24": {"country", "CN"}})
+	a, err := loadGeoIP(db, []string{"CN"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := loadGeoIP(db, []string{"US"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.reader != b.reader {
+		t.Error("loadGeoIP() read the same database twice")
+	}
+
+	// a replaced file is read again
+	replaced := writeGeoIPDatabase(t, map[string][2]string{"1.0.1.0/24": {"country", "US"}})
+	data, err := os.ReadFile(replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(db, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err = os.Chtimes(db, later, later); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadGeoIP(db, []string{"US"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.reader == a.reader || !c.match("1.0.1.1", false) {
+		t.Error("loadGeoIP() kept the database of a replaced file")
+	}
+}
+
+func TestRoute_GeoIPDatabaseRequired(t *testing.T) {
+	r := &Route{Sources: []string{"CN"}, MatchType: TypeGeoIP}
+	if err := r.GenerateCache(); err == nil {
+		t.Error("GenerateCache() accepted a geoip route without a database")
+	}
+	r.Database = filepath.Join(t.TempDir(), "missing.mmdb")
+	if err := r.GenerateCache(); err == nil {
+		t.Error("GenerateCache() accepted a missing database")
+	}
+}
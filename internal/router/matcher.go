@@ -0,0 +1,238 @@
+package router
+
+import (
+	"math"
+	"net/netip"
+	"strings"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
+)
+
+// noRoute ranks below every route index.
+const noRoute = math.MaxInt
+
+// routeMatcher finds the first of its routes matching a destination. Exact
+// and domain rules of all the routes share one lookup, cidr rules one radix
+// tree per address family, each entry keeping the first route it belongs
+// to, so a lookup does not grow with the number of rules. The routes of
+// other types are matched one by one, only while they come before the best
+// route found that way.
+type routeMatcher struct {
+	routes  Routes
+	exact   map[string]int
+	domains domainTrie
+	cidr4   *cidrNode
+	cidr6   *cidrNode
+	others  []int
+	// ports is set when a route matches by port, so that decisions are
+	// cached by host and port.
+	ports bool
+	// sources is set when a route matches by source, so that decisions are
+	// cached by source too.
+	sources bool
+}
+
+// compileRoutes builds the matcher of routes, whose caches are generated.
+func compileRoutes(routes Routes) *routeMatcher {
+	m := &routeMatcher{routes: routes, exact: make(map[string]int)}
+	m.domains.init()
+	for i, route := range routes {
+		if route == nil {
+			continue
+		}
+		switch route.MatchType {
+		case TypeExact:
+			for host := range route.cache.ExactMap {
+				if _, ok := m.exact[host]; !ok {
+					m.exact[host] = i
+				}
+			}
+		case TypeDomain:
+			for domain := range route.cache.DomainMap {
+				m.domains.insert(domain, i)
+			}
+		case TypeCIDR:
+			for _, prefix := range route.cache.CIDRList {
+				prefix = prefix.Masked()
+				if prefix.Addr().Is4() {
+					insertCIDR(&m.cidr4, prefix, i)
+				} else {
+					insertCIDR(&m.cidr6, prefix, i)
+				}
+			}
+		default:
+			m.others = append(m.others, i)
+			m.ports = m.ports || route.MatchType == TypePort
+			m.sources = m.sources || route.MatchType.matchesSource()
+		}
+	}
+	return m
+}
+
+// match returns the first route matching dst, a normalizeRouteKey result,
+// at port, from an unknown source.
+func (m *routeMatcher) match(dst string, port uint16) (*Route, bool) {
+	return m.matchFrom(Source{}, dst, port)
+}
+
+// matchFrom returns the first route matching traffic from src to dst, a
+// normalizeRouteKey result, at port.
+func (m *routeMatcher) matchFrom(src Source, dst string, port uint16) (*Route, bool) {
+	best := noRoute
+	if host := utils.NormalizeHost(dst); host != "" {
+		if i, ok := m.exact[host]; ok {
+			best = i
+		}
+		if _, err := netip.ParseAddr(host); err != nil {
+			best = min(best, m.domains.lookup(host))
+		}
+	}
+	if addr, err := netip.ParseAddr(dst); err == nil {
+		root := m.cidr6
+		if addr.Is4() {
+			root = m.cidr4
+		}
+		best = min(best, lookupCIDR(root, addr))
+	}
+	for _, i := range m.others {
+		if i > best {
+			break
+		}
+		if m.routes[i].MatchFrom(src, dst, port) {
+			best = i
+			break
+		}
+	}
+	if best == noRoute {
+		return nil, false
+	}
+	return m.routes[best], true
+}
+
+// domainTrie holds domains by their labels from the top level down. Nodes
+// live in one slice and edges in one map, rather than a map per node.
+type domainTrie struct {
+	nodes []int // route index of the domain ending at each node
+	edges map[domainEdge]int32
+}
+
+type domainEdge struct {
+	parent int32
+	label  string
+}
+
+func (t *domainTrie) init() {
+	t.nodes = []int{noRoute}
+	t.edges = make(map[domainEdge]int32)
+}
+
+func (t *domainTrie) insert(domain string, route int) {
+	var node int32
+	for rest := domain; ; {
+		dot := strings.LastIndexByte(rest, '.')
+		edge := domainEdge{parent: node, label: rest[dot+1:]}
+		child, ok := t.edges[edge]
+		if !ok {
+			child = int32(len(t.nodes))
+			t.nodes = append(t.nodes, noRoute)
+			t.edges[edge] = child
+		}
+		node = child
+		if dot < 0 {
+			break
+		}
+		rest = rest[:dot]
+	}
+	t.nodes[node] = min(t.nodes[node], route)
+}
+
+// lookup returns the first route of the domains host equals or is a
+// subdomain of.
+func (t *domainTrie) lookup(host string) int {
+	best := noRoute
+	var node int32
+	for rest := host; ; {
+		dot := strings.LastIndexByte(rest, '.')
+		child, ok := t.edges[domainEdge{parent: node, label: rest[dot+1:]}]
+		if !ok {
+			break
+		}
+		node = child
+		best = min(best, t.nodes[node])
+		if dot < 0 {
+			break
+		}
+		rest = rest[:dot]
+	}
+	return best
+}
+
+// cidrNode is a node of a path-compressed binary radix tree of prefixes.
+type cidrNode struct {
+	prefix netip.Prefix
+	route  int // noRoute for nodes only splitting the tree
+	child  [2]*cidrNode
+}
+
+// addrBit returns bit i of addr, counted from the most significant.
+func addrBit(addr netip.Addr, i int) int {
+	b := addr.As16()
+	if addr.Is4() {
+		i += 96
+	}
+	return int(b[i/8]>>(7-i%8)) & 1
+}
+
+// commonBits returns how many leading bits a and b share, up to the shorter
+// of the two.
+func commonBits(a, b netip.Prefix) int {
+	n := min(a.Bits(), b.Bits())
+	for i := 0; i < n; i++ {
+		if addrBit(a.Addr(), i) != addrBit(b.Addr(), i) {
+			return i
+		}
+	}
+	return n
+}
+
+func insertCIDR(n **cidrNode, prefix netip.Prefix, route int) {
+	for {
+		cur := *n
+		if cur == nil {
+			*n = &cidrNode{prefix: prefix, route: route}
+			return
+		}
+		common := commonBits(cur.prefix, prefix)
+		switch {
+		case common == cur.prefix.Bits() && common == prefix.Bits():
+			cur.route = min(cur.route, route)
+			return
+		case common == cur.prefix.Bits():
+			n = &cur.child[addrBit(prefix.Addr(), common)]
+		case common == prefix.Bits():
+			node := &cidrNode{prefix: prefix, route: route}
+			node.child[addrBit(cur.prefix.Addr(), common)] = cur
+			*n = node
+			return
+		default:
+			split := &cidrNode{prefix: netip.PrefixFrom(prefix.Addr(), common).Masked(), route: noRoute}
+			split.child[addrBit(prefix.Addr(), common)] = &cidrNode{prefix: prefix, route: route}
+			split.child[addrBit(cur.prefix.Addr(), common)] = cur
+			*n = split
+			return
+		}
+	}
+}
+
+// lookupCIDR returns the first route of the prefixes containing addr.
+func lookupCIDR(n *cidrNode, addr netip.Addr) int {
+	best := noRoute
+	for n != nil && n.prefix.Contains(addr) {
+		best = min(best, n.route)
+		if n.prefix.Bits() == addr.BitLen() {
+			break
+		}
+		n = n.child[addrBit(addr, n.prefix.Bits())]
+	}
+	return best
+}
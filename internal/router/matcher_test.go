@@ -0,0 +1,125 @@
+package router
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/netip"
+	"testing"
+)
+
+// matchLinear is the first route matching dst at port, one route after the other.
+func matchLinear(routes Routes, dst string, port uint16) (*Route, bool) {
+	for _, route := range routes {
+		if route.Match(dst, port) {
+			return route, true
+		}
+	}
+	return nil, false
+}
+
+func TestRouteMatcher_FirstMatch(t *testing.T) {
+	routes, err := prepareRoutes(Routes{
+		{MatchType: TypeExact, Sources: []string{"www.example.com", "10.1.2.3"}, Destination: EgressDirect},
+		{MatchType: TypeCIDR, Sources: []string{"10.1.0.0/16", "2001:db8:1::/48"}, Destination: EgressBlock},
+		{MatchType: TypeDomain, Sources: []string{"ads.example.com", "tracker.net"}, Destination: EgressBlock},
+		{MatchType: TypeRegex, Sources: []string{`^api\.`}, Destination: EgressDirect},
+		{MatchType: TypeDomain, Sources: []string{"example.com", "com"}, Destination: EgressProxy},
+		{MatchType: TypeCIDR, Sources: []string{"10.0.0.0/8", "10.1.2.0/24", "0.0.0.0/0", "::ffff:0:0/96"}, Destination: EgressDirect},
+		{MatchType: TypeDefault, Destination: EgressForward},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := compileRoutes(routes)
+
+	for _, tc := range []struct {
+		dst  string
+		want int
+	}{
+		{"www.example.com", 0},
+		{"10.1.2.3", 0},
+		{"10.1.9.9", 1},
+		{"2001:db8:1::1", 1},
+		{"x.ads.example.com", 2},
+		{"ads.example.com", 2},
+		{"tracker.net", 2},
+		{"api.tracker.net", 2},
+		{"api.example.org", 3},
+		{"example.com", 4},
+		{"mail.example.com", 4},
+		{"notexample.org", 6},
+		{"10.2.0.1", 5},
+		{"1.1.1.1", 5},
+		{"::ffff:1.1.1.1", 5},
+		{"2001:db8:2::1", 6},
+		{"fe80::1%eth0", 6},
+		{"localhost", 6},
+	} {
+		route, ok := m.match(tc.dst, 443)
+		if !ok || route != routes[tc.want] {
+			t.Errorf("match(%q) = %v, want route %d", tc.dst, route, tc.want)
+		}
+		if linear, _ := matchLinear(routes, tc.dst, 443); linear != route {
+			t.Errorf("match(%q) differs from matching the routes in order", tc.dst)
+		}
+	}
+
+	if _, ok := compileRoutes(routes[:2]).match("1.1.1.1", 443); ok {
+		t.Error("match() found a route for an unmatched destination")
+	}
+}
+
+func TestRouteMatcher_AgreesWithLinear(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	randomPrefix := func() string {
+		var b [4]byte
+		for i := range b {
+			b[i] = byte(rng.IntN(4))
+		}
+		return netip.PrefixFrom(netip.AddrFrom4(b), rng.IntN(33)).Masked().String()
+	}
+	randomDomain := func() string {
+		labels := []string{"a", "b", "c"}
+		d := labels[rng.IntN(3)]
+		for range rng.IntN(3) {
+			d = labels[rng.IntN(3)] + "." + d
+		}
+		return d
+	}
+
+	var input Routes
+	for i := range 30 {
+		r := &Route{Destination: EgressDirect}
+		switch i % 3 {
+		case 0:
+			r.MatchType = TypeCIDR
+			for range 4 {
+				r.Sources = append(r.Sources, randomPrefix())
+			}
+		case 1:
+			r.MatchType = TypeDomain
+			r.Sources = []string{randomDomain(), randomDomain()}
+		default:
+			r.MatchType = TypeExact
+			r.Sources = []string{randomDomain(), fmt.Sprintf("%d.%d.%d.%d", rng.IntN(4), rng.IntN(4), rng.IntN(4), rng.IntN(4))}
+		}
+		input = append(input, r)
+	}
+	routes, err := prepareRoutes(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := compileRoutes(routes)
+
+	for range 5000 {
+		dst := randomDomain()
+		if rng.IntN(2) == 0 {
+			dst = fmt.Sprintf("%d.%d.%d.%d", rng.IntN(4), rng.IntN(4), rng.IntN(4), rng.IntN(4))
+		}
+		got, _ := m.match(dst, 443)
+		want, _ := matchLinear(routes, dst, 443)
+		if got != want {
+			t.Fatalf("match(%q) = %p, want %p", dst, got, want)
+		}
+	}
+}
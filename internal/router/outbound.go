@@ -0,0 +1,76 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+)
+
+// Outbound is a destination declared by name in config, which routes
+// reference like a built-in egress.
+type Outbound interface {
+	// Transport returns the transport to carry traffic to dst.
+	Transport(dst string) (transport.Transport, error)
+	SupportsUDP() bool
+}
+
+var (
+	outboundsMu sync.RWMutex
+	outbounds   map[string]Outbound
+)
+
+// SetOutbounds replaces the named outbounds.
+func SetOutbounds(m map[string]Outbound) {
+	outboundsMu.Lock()
+	defer outboundsMu.Unlock()
+	outbounds = m
+}
+
+func getOutbound(name string) (Outbound, bool) {
+	outboundsMu.RLock()
+	defer outboundsMu.RUnlock()
+	o, ok := outbounds[name]
+	return o, ok
+}
+
+// Declared reports whether e is built in or names an outbound.
+func (e Egress) Declared() bool {
+	if e.IsBuiltin() {
+		return true
+	}
+	_, ok := getOutbound(string(e))
+	return ok
+}
+
+// IsBuiltin reports whether e is one of the egresses that need no
+// declaration.
+func (e Egress) IsBuiltin() bool {
+	switch e {
+	case EgressDirect, EgressProxy, EgressForward, EgressBlock, EgressBlackHole:
+		return true
+	}
+	return false
+}
+
+// CheckRoutes checks every route leads to an egress declared reports.
+func CheckRoutes(routes Routes, declared func(Egress) bool) error {
+	for i, r := range routes {
+		if r != nil && r.Destination != EgressUnknown && !declared(r.Destination) {
+			return fmt.Errorf("route %d: unknown destination %q", i, r.Destination)
+		}
+	}
+	return nil
+}
+
+// CheckNestedRoutes checks the routes of a policy or user like CheckRoutes.
+// Rule sets are refreshed in the shared routes only, so none is fetched by
+// URL.
+func CheckNestedRoutes(routes Routes, declared func(Egress) bool) error {
+	for i, r := range routes {
+		if r != nil && r.URL != "" {
+			return fmt.Errorf("route %d: url rule sets are only supported in the top-level route", i)
+		}
+	}
+	return CheckRoutes(routes, declared)
+}
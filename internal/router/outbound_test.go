@@ -0,0 +1,61 @@
+package router
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/direct"
+)
+
+type testOutbound struct {
+	udp bool
+	dst string
+}
+
+func (o *testOutbound) Transport(dst string) (transport.Transport, error) {
+	o.dst = dst
+	return direct.New(), nil
+}
+
+func (o *testOutbound) SupportsUDP() bool {
+	return o.udp
+}
+
+func TestEgressResolvesNamedOutbound(t *testing.T) {
+	eu := &testOutbound{udp: true}
+	SetOutbounds(map[string]Outbound{"eu": eu, "corp": &testOutbound{}})
+	t.Cleanup(func() { SetOutbounds(nil) })
+
+	if err := SetRoutes(Routes{
+		{MatchType: TypeExact, Sources: []string{"Example.com"}, Destination: "eu"},
+		{MatchType: TypeDefault, Destination: "corp"},
+	}); err != nil {
+		t.Fatalf("SetRoutes() error = %v", err)
+	}
+	tr, err := GetRoute("EXAMPLE.com.", 443)
+	if err != nil {
+		t.Fatalf("GetRoute() error = %v", err)
+	}
+	_ = tr.Close()
+	if eu.dst != "example.com" {
+		t.Errorf("outbound got destination %q, want example.com", eu.dst)
+	}
+
+	if !Egress("eu").SupportsUDP() || Egress("corp").SupportsUDP() {
+		t.Error("SupportsUDP() does not follow the outbound")
+	}
+	if Egress("eu").IsBuiltin() || !EgressProxy.IsBuiltin() {
+		t.Error("IsBuiltin() misreports")
+	}
+}
+
+func TestEgressUnknownOutbound(t *testing.T) {
+	SetOutbounds(nil)
+	if _, err := Egress("nowhere").GetTransport(); err == nil || !strings.Contains(err.Error(), "not implemented") {
+		t.Fatalf("GetTransport() error = %v, want not implemented", err)
+	}
+	if Egress("nowhere").SupportsUDP() {
+		t.Error("SupportsUDP() = true for an undeclared outbound")
+	}
+}
@@ -0,0 +1,72 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
+)
+
+// PortRange is an inclusive range of destination ports.
+type PortRange struct {
+	Low, High uint16
+}
+
+// ParsePortRange parses a port such as "22" or a range such as "8000-8100".
+func ParsePortRange(s string) (PortRange, error) {
+	parsePort := func(p string) (uint16, error) {
+		n, err := strconv.ParseUint(strings.TrimSpace(p), 10, 16)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("port: %q invalid", s)
+		}
+		return uint16(n), nil
+	}
+	low, high, isRange := strings.Cut(strings.TrimSpace(s), "-")
+	lo, err := parsePort(low)
+	if err != nil {
+		return PortRange{}, err
+	}
+	hi := lo
+	if isRange {
+		if hi, err = parsePort(high); err != nil {
+			return PortRange{}, err
+		}
+		if hi < lo {
+			return PortRange{}, fmt.Errorf("port: %q ends before it starts", s)
+		}
+	}
+	return PortRange{Low: lo, High: hi}, nil
+}
+
+// Contains reports whether port is in the range.
+func (pr PortRange) Contains(port uint16) bool {
+	return pr.Low <= port && port <= pr.High
+}
+
+// compileGlobs compiles host patterns, where "*" stands for any run of
+// characters, dots included, and "?" for one, into a single regexp. So
+// "*.example.com" matches every subdomain of example.com but not the apex.
+// It returns how many patterns there were.
+func compileGlobs(globs []string) (*regexp.Regexp, int, error) {
+	var patterns []string
+	for _, glob := range globs {
+		glob = utils.NormalizeHost(glob)
+		if glob == "" {
+			continue
+		}
+		pattern := regexp.QuoteMeta(glob)
+		pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+		pattern = strings.ReplaceAll(pattern, `\?`, `.`)
+		patterns = append(patterns, pattern)
+	}
+	if len(patterns) == 0 {
+		return nil, 0, nil
+	}
+	glob, err := regexp.Compile(`^(?:` + strings.Join(patterns, `|`) + `)$`)
+	if err != nil {
+		return nil, 0, fmt.Errorf("glob: %w", err)
+	}
+	return glob, len(patterns), nil
+}
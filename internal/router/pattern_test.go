@@ -0,0 +1,126 @@
+package router
+
+import "testing"
+
+func TestParsePortRange(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    PortRange
+		wantErr bool
+	}{
+		{in: "22", want: PortRange{22, 22}},
+		{in: " 8000-8100 ", want: PortRange{8000, 8100}},
+		{in: "443-443", want: PortRange{443, 443}},
+		{in: "0", wantErr: true},
+		{in: "65536", wantErr: true},
+		{in: "100-10", wantErr: true},
+		{in: "80-", wantErr: true},
+		{in: "ssh", wantErr: true},
+	} {
+		got, err := ParsePortRange(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParsePortRange(%q) error = %v, wantErr %t", tc.in, err, tc.wantErr)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("ParsePortRange(%q) = %v, want %v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestRoute_Match_Keyword(t *testing.T) {
+	r := &Route{MatchType: TypeKeyword, Sources: []string{"Google", " "}}
+	if err := r.GenerateCache(); err != nil {
+		t.Fatal(err)
+	}
+	for dst, want := range map[string]bool{
+		"google.com":          true,
+		"www.GOOGLEapis.com.": true,
+		"goog.le":             false,
+	} {
+		if got := r.Match(dst, 443); got != want {
+			t.Errorf("Match(%q) = %t, want %t", dst, got, want)
+		}
+	}
+}
+
+func TestRoute_Match_Glob(t *testing.T) {
+	r := &Route{MatchType: TypeGlob, Sources: []string{"*.example.com", "cdn?.net", "a.b+c.org"}}
+	if err := r.GenerateCache(); err != nil {
+		t.Fatal(err)
+	}
+	for dst, want := range map[string]bool{
+		"www.example.com":    true,
+		"a.b.example.com.":   true,
+		"example.com":        false,
+		"www.example.com.cn": false,
+		"cdn1.net":           true,
+		"cdn12.net":          false,
+		"a.b+c.org":          true,
+		"a.bbc.org":          false,
+	} {
+		if got := r.Match(dst, 443); got != want {
+			t.Errorf("Match(%q) = %t, want %t", dst, got, want)
+		}
+	}
+}
+
+func TestRoute_Match_Port(t *testing.T) {
+	r := &Route{MatchType: TypePort, Sources: []string{"22", "8000-8100"}}
+	if err := r.GenerateCache(); err != nil {
+		t.Fatal(err)
+	}
+	for port, want := range map[uint16]bool{
+		22:   true,
+		8000: true,
+		8050: true,
+		8101: false,
+		443:  false,
+		0:    false,
+	} {
+		if got := r.Match("example.com", port); got != want {
+			t.Errorf("Match(example.com, %d) = %t, want %t", port, got, want)
+		}
+	}
+
+	if err := (&Route{MatchType: TypePort, Sources: []string{"http"}}).GenerateCache(); err == nil {
+		t.Error("GenerateCache() accepted an invalid port")
+	}
+}
+
+func TestGetEgress_ByPort(t *testing.T) {
+	routesMu.RLock()
+	saved := routesCache
+	routesMu.RUnlock()
+	t.Cleanup(func() {
+		if err := SetRoutes(saved); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	err := SetRoutes(Routes{
+		{MatchType: TypePort, Sources: []string{"22"}, Destination: EgressDirect},
+		{MatchType: TypeDefault, Destination: EgressProxy},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same host is decided, and the decision cached, per port
+	for _, tc := range []struct {
+		port uint16
+		want Egress
+	}{
+		{22, EgressDirect},
+		{443, EgressProxy},
+		{22, EgressDirect},
+		{0, EgressProxy},
+	} {
+		got, err := GetEgress("git.example", tc.port)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("GetEgress(git.example, %d) = %s, want %s", tc.port, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Policy is a route list consulted before the shared table, for the traffic
+// of some users only. Its matches are not cached.
+type Policy struct {
+	matcher *routeMatcher
+	// next is consulted when no route of the policy matches.
+	next *Policy
+}
+
+var (
+	policiesMu sync.RWMutex
+	policies   map[string]*Policy
+)
+
+// NewPolicy prepares routes to be consulted before next, or before the
+// shared table when next is nil.
+func NewPolicy(routes Routes, next *Policy) (*Policy, error) {
+	prepared, err := prepareRoutes(routes)
+	if err != nil {
+		return nil, err
+	}
+	return &Policy{matcher: compileRoutes(prepared), next: next}, nil
+}
+
+// GetEgress returns the destination of the first route of p matching dst at
+// port, failing that of its next policies, and finally of the shared table.
+func (p *Policy) GetEgress(dst string, port uint16) (Egress, error) {
+	key := normalizeRouteKey(dst)
+	for q := p; q != nil; q = q.next {
+		if route, ok := q.matcher.match(key, port); ok {
+			return route.Destination, nil
+		}
+	}
+	return GetEgress(key, port)
+}
+
+// SetPolicies prepares the named policies users can refer to and replaces
+// the previous ones. On error they are left as they were.
+func SetPolicies(m map[string]Routes) error {
+	prepared, err := NewPolicies(m)
+	if err != nil {
+		return err
+	}
+	InstallPolicies(prepared)
+	return nil
+}
+
+// NewPolicies prepares named policies to be installed by InstallPolicies.
+func NewPolicies(m map[string]Routes) (map[string]*Policy, error) {
+	prepared := make(map[string]*Policy, len(m))
+	for name, routes := range m {
+		p, err := NewPolicy(routes, nil)
+		if err != nil {
+			return nil, fmt.Errorf("policy %s: %w", name, err)
+		}
+		prepared[name] = p
+	}
+	return prepared, nil
+}
+
+// InstallPolicies replaces the named policies users can refer to.
+func InstallPolicies(m map[string]*Policy) {
+	policiesMu.Lock()
+	defer policiesMu.Unlock()
+	policies = m
+}
+
+// GetPolicy returns the named policy.
+func GetPolicy(name string) (*Policy, bool) {
+	policiesMu.RLock()
+	defer policiesMu.RUnlock()
+	p, ok := policies[name]
+	return p, ok
+}
@@ -0,0 +1,44 @@
+package router
+
+import "testing"
+
+func TestPolicyFallsThroughToSharedTable(t *testing.T) {
+	if err := SetRoutes(Routes{{MatchType: TypeDefault, Destination: EgressProxy}}); err != nil {
+		t.Fatalf("SetRoutes() error = %v", err)
+	}
+	next, err := NewPolicy(Routes{{MatchType: TypeDomain, Sources: []string{"lan"}, Destination: EgressDirect}}, nil)
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+	p, err := NewPolicy(Routes{{MatchType: TypeExact, Sources: []string{"nas.lan"}, Destination: EgressBlock}}, next)
+	if err != nil {
+		t.Fatalf("NewPolicy() error = %v", err)
+	}
+
+	for dst, want := range map[string]Egress{
+		"NAS.lan.":    EgressBlock,
+		"printer.lan": EgressDirect,
+		"example.com": EgressProxy,
+	} {
+		if got, err := p.GetEgress(dst, 443); err != nil || got != want {
+			t.Errorf("GetEgress(%s) = %s, %v, want %s", dst, got, err, want)
+		}
+	}
+	// policy matches stay out of the shared cache
+	if got, _ := GetEgress("nas.lan", 443); got != EgressProxy {
+		t.Errorf("shared GetEgress(nas.lan) = %s, want proxy", got)
+	}
+}
+
+func TestSetPoliciesKeepsPreviousOnError(t *testing.T) {
+	if err := SetPolicies(map[string]Routes{"guest": {{MatchType: TypeDefault, Destination: EgressBlock}}}); err != nil {
+		t.Fatalf("SetPolicies() error = %v", err)
+	}
+	t.Cleanup(func() { _ = SetPolicies(nil) })
+	if err := SetPolicies(map[string]Routes{"bad": {{MatchType: TypeCIDR, Sources: []string{"not-a-cidr"}}}}); err == nil {
+		t.Fatal("SetPolicies() accepted an invalid route")
+	}
+	if _, ok := GetPolicy("guest"); !ok {
+		t.Fatal("failed SetPolicies() dropped the previous policies")
+	}
+}
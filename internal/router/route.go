@@ -1,13 +1,13 @@
 package router
 
 import (
-	"bufio"
 	"fmt"
 	"log"
 	"net/netip"
 	"os"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
 )
@@ -50,14 +50,35 @@ type Route struct {
 	Ext         string   `json:"path,omitempty"`
 	Destination Egress   `json:"dst"`
 	MatchType   Type     `json:"type"`
-	cache       MatchCache
+	// Database is the mmdb file geoip routes look up, their sources being
+	// country codes. With Resolve, domain destinations are resolved and
+	// matched by their addresses; otherwise geoip matches IPs only.
+	Database string `json:"database,omitempty"`
+	Resolve  bool   `json:"resolve,omitempty"`
+	// URL is a rule set the sources are fetched from in addition, every
+	// Interval seconds (daily by default). Its last copy is kept at Ext, or
+	// in the user cache directory, and used while the URL fails. Only the
+	// top-level routes are refreshed, so the config rejects URL elsewhere.
+	URL      string `json:"url,omitempty"`
+	Interval int    `json:"interval,omitempty"`
+	cache    MatchCache
 }
 
 type MatchCache struct {
-	ExactMap   map[string]struct{}
-	DomainMap  map[string]struct{}
-	RegexpList []*regexp.Regexp
-	CIDRList   []netip.Prefix
+	ExactMap    map[string]struct{}
+	DomainMap   map[string]struct{}
+	RegexpList  []*regexp.Regexp
+	CIDRList    []netip.Prefix
+	GeoIP       *geoIP
+	Keywords    []string
+	Glob        *regexp.Regexp
+	PortRanges  []PortRange
+	Inbounds    []string
+	SourceCIDRs []netip.Prefix
+	Users       []string
+	// Fetched is when the rule set at URL was last fetched, zero if the
+	// kept copy is in use.
+	Fetched time.Time
 }
 
 func (r *Route) GenerateCache() error {
@@ -67,30 +88,32 @@ func (r *Route) GenerateCache() error {
 	// This prevents duplicate entries if GenerateCache() is called more than once
 	// (e.g., on config reload) when r.Ext points to a file.
 	sources := r.Sources
-	if r.Ext != "" {
+	var fetched time.Time
+	switch {
+	case r.URL != "":
+		remote, ok, err := r.loadRuleSet()
+		if err != nil {
+			return err
+		}
+		if ok {
+			fetched = time.Now()
+		}
+		sources = append(sources, remote...)
+	case r.Ext != "":
 		log.Printf("reading route-ext from file: %s", r.Ext)
 		f, err := os.Open(r.Ext)
 		if err != nil {
 			return fmt.Errorf("read from path: %s failed: %w", r.Ext, err)
 		}
-		var fileSources []string
-		b := bufio.NewScanner(f)
-		for b.Scan() {
-			line := strings.TrimSpace(b.Text())
-			if line == "" || strings.HasPrefix(line, "#") {
-				continue
-			}
-			fileSources = append(fileSources, line)
-		}
-		if err := b.Err(); err != nil {
-			utils.Close(f)
+		fileSources, err := readSources(f)
+		utils.Close(f)
+		if err != nil {
 			return fmt.Errorf("read from path: %s scan failed: %w", r.Ext, err)
 		}
-		utils.Close(f)
 		sources = append(sources, fileSources...)
 	}
 	// Reset caches before rebuilding to ensure idempotency.
-	r.cache = MatchCache{}
+	r.cache = MatchCache{Fetched: fetched}
 	switch r.MatchType {
 	case TypeDefault:
 	case TypeExact:
@@ -142,17 +165,104 @@ func (r *Route) GenerateCache() error {
 			r.cache.RegexpList = append(r.cache.RegexpList, regx)
 		}
 		log.Printf("regex-route count: %d", len(r.cache.RegexpList))
+	case TypeKeyword:
+		for _, keyword := range sources {
+			if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+				r.cache.Keywords = append(r.cache.Keywords, keyword)
+			}
+		}
+		log.Printf("keyword-route count: %d", len(r.cache.Keywords))
+	case TypeGlob:
+		glob, n, err := compileGlobs(sources)
+		if err != nil {
+			return err
+		}
+		r.cache.Glob = glob
+		log.Printf("glob-route count: %d", n)
+	case TypePort:
+		for _, src := range sources {
+			if strings.TrimSpace(src) == "" {
+				continue
+			}
+			pr, err := ParsePortRange(src)
+			if err != nil {
+				return err
+			}
+			r.cache.PortRanges = append(r.cache.PortRanges, pr)
+		}
+		log.Printf("port-route count: %d", len(r.cache.PortRanges))
+	case TypeInbound:
+		for _, src := range sources {
+			if strings.TrimSpace(src) == "" {
+				continue
+			}
+			inbound, err := parseInbound(src)
+			if err != nil {
+				return err
+			}
+			r.cache.Inbounds = append(r.cache.Inbounds, inbound)
+		}
+		log.Printf("inbound-route count: %d", len(r.cache.Inbounds))
+	case TypeSource:
+		for _, src := range sources {
+			if strings.TrimSpace(src) == "" {
+				continue
+			}
+			prefix, err := parseSourcePrefix(src)
+			if err != nil {
+				return err
+			}
+			r.cache.SourceCIDRs = append(r.cache.SourceCIDRs, prefix)
+		}
+		log.Printf("source-route count: %d", len(r.cache.SourceCIDRs))
+	case TypeUser:
+		for _, user := range sources {
+			if user = strings.TrimSpace(user); user != "" {
+				r.cache.Users = append(r.cache.Users, user)
+			}
+		}
+		log.Printf("user-route count: %d", len(r.cache.Users))
+	case TypeGeoIP:
+		g, err := loadGeoIP(r.Database, sources)
+		if err != nil {
+			return err
+		}
+		r.cache.GeoIP = g
+		log.Printf("geoip-route count: %d", len(g.countries))
 	default:
 		return fmt.Errorf("unknown route type: %s, cannot generate cache", r.MatchType)
 	}
 	return nil
 }
 
-func (r *Route) Match(dst string) bool {
+// Match reports whether the route matches dst at port, zero if the port is
+// unknown, which port routes never match. Routes matching by source never
+// match here; see MatchFrom.
+func (r *Route) Match(dst string, port uint16) bool {
 	//log.Printf("route matching type: %s", r.MatchType)
 	switch r.MatchType {
 	case TypeDefault:
 		return true
+	case TypeKeyword:
+		host := utils.NormalizeHost(dst)
+		for _, keyword := range r.cache.Keywords {
+			if strings.Contains(host, keyword) {
+				return true
+			}
+		}
+	case TypeGlob:
+		if host := utils.NormalizeHost(dst); host != "" && r.cache.Glob != nil {
+			return r.cache.Glob.MatchString(host)
+		}
+	case TypePort:
+		if port == 0 {
+			return false
+		}
+		for _, pr := range r.cache.PortRanges {
+			if pr.Contains(port) {
+				return true
+			}
+		}
 	case TypeExact:
 		if r.cache.ExactMap != nil {
 			if _, ok := r.cache.ExactMap[utils.NormalizeHost(dst)]; ok {
@@ -186,6 +296,10 @@ func (r *Route) Match(dst string) bool {
 			}
 			candidate = candidate[dot+1:]
 		}
+	case TypeGeoIP:
+		if r.cache.GeoIP != nil {
+			return r.cache.GeoIP.match(dst, r.Resolve)
+		}
 	case TypeCIDR:
 		// Only match if dst is a valid IP address
 		if addr, err := netip.ParseAddr(dst); err == nil {
@@ -200,3 +314,16 @@ func (r *Route) Match(dst string) bool {
 	}
 	return false
 }
+
+// MatchFrom reports whether the route matches traffic from src to dst at
+// port.
+func (r *Route) MatchFrom(src Source, dst string, port uint16) bool {
+	switch {
+	case r.MatchType.matchesSource():
+		return r.matchSource(src)
+	case r.MatchType == TypeGeoIP && src.Inbound == InboundDNS:
+		// resolving a name being queried could come back to the dns inbound
+		return r.cache.GeoIP != nil && r.cache.GeoIP.match(dst, false)
+	}
+	return r.Match(dst, port)
+}
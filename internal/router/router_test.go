@@ -1,6 +1,9 @@
 package router
 
 import (
+	"fmt"
+	"io"
+	"log"
 	"os"
 	"testing"
 )
@@ -79,16 +82,16 @@ func TestRoute_Match_Exact(t *testing.T) {
 	}
 	r.GenerateCache()
 
-	if !r.Match("example.com") {
+	if !r.Match("example.com", 443) {
 		t.Errorf("expected match for example.com")
 	}
-	if !r.Match("Example.com") {
+	if !r.Match("Example.com", 443) {
 		t.Errorf("expected case-insensitive match for Example.com")
 	}
-	if !r.Match("example.com.") {
+	if !r.Match("example.com.", 443) {
 		t.Errorf("expected match for example.com. (trailing dot)")
 	}
-	if r.Match("sub.example.com") {
+	if r.Match("sub.example.com", 443) {
 		t.Errorf("did not expect match for sub.example.com")
 	}
 }
@@ -100,16 +103,16 @@ func TestRoute_Match_Domain(t *testing.T) {
 	}
 	r.GenerateCache()
 
-	if !r.Match("google.com") {
+	if !r.Match("google.com", 443) {
 		t.Errorf("expected match for google.com")
 	}
-	if !r.Match("www.google.com") {
+	if !r.Match("www.google.com", 443) {
 		t.Errorf("expected match for www.google.com")
 	}
-	if !r.Match("Google.com") {
+	if !r.Match("Google.com", 443) {
 		t.Errorf("expected case-insensitive match for Google.com")
 	}
-	if !r.Match("www.google.com.") {
+	if !r.Match("www.google.com.", 443) {
 		t.Errorf("expected match for www.google.com. (trailing dot)")
 	}
 }
@@ -123,16 +126,16 @@ func TestRoute_Match_Domain_SubdomainSource(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if !r.Match("www.google.com") {
+	if !r.Match("www.google.com", 443) {
 		t.Error("expected domain rule src=www.google.com to match itself")
 	}
-	if !r.Match("cdn.www.google.com") {
+	if !r.Match("cdn.www.google.com", 443) {
 		t.Error("expected subdomain of rule to match")
 	}
-	if r.Match("google.com") {
+	if r.Match("google.com", 443) {
 		t.Error("apex must not match a more-specific domain rule")
 	}
-	if r.Match("api.google.com") {
+	if r.Match("api.google.com", 443) {
 		t.Error("sibling subdomain must not match")
 	}
 }
@@ -160,3 +163,72 @@ func TestRoute_GenerateCache_ExtFile_SkipsEmptyAndComments(t *testing.T) {
 		t.Fatalf("ExactMap size = %d, want 2", len(r.cache.ExactMap))
 	}
 }
+
+// benchmarkRoutes builds block lists of 100k domains and 50k prefixes spread
+// over several routes, ahead of a regex and the default route, as large
+// subscriptions produce them.
+func benchmarkRoutes(b *testing.B) Routes {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	var routes Routes
+	for r := range 100 {
+		route := &Route{MatchType: TypeDomain, Destination: EgressBlock}
+		for i := range 1000 {
+			route.Sources = append(route.Sources, fmt.Sprintf("ads%d-%d.example%d.com", i, r, r))
+		}
+		routes = append(routes, route)
+	}
+	for r := range 50 {
+		route := &Route{MatchType: TypeCIDR, Destination: EgressProxy}
+		for i := range 1000 {
+			route.Sources = append(route.Sources, fmt.Sprintf("%d.%d.%d.0/24", 11+r, i/256, i%256))
+		}
+		routes = append(routes, route)
+	}
+	routes = append(routes,
+		&Route{MatchType: TypeRegex, Sources: []string{`^cdn\d+\.`}, Destination: EgressDirect},
+		&Route{MatchType: TypeDefault, Destination: EgressDirect},
+	)
+	prepared, err := prepareRoutes(routes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return prepared
+}
+
+var benchmarkDestinations = []string{
+	"ads500-99.example99.com", // last domain route
+	"www.google.com",          // falls through to the default route
+	"60.3.200.1",              // last cidr route
+	"8.8.8.8",                 // falls through to the default route
+	"cdn7.example.net",        // regex route
+}
+
+// BenchmarkGetEgress_Linear matches the routes one after the other, as the
+// router did before compiling them.
+func BenchmarkGetEgress_Linear(b *testing.B) {
+	routes := benchmarkRoutes(b)
+	for i := 0; b.Loop(); i++ {
+		if _, ok := matchLinear(routes, benchmarkDestinations[i%len(benchmarkDestinations)], 443); !ok {
+			b.Fatal("no route")
+		}
+	}
+}
+
+func BenchmarkGetEgress_Compiled(b *testing.B) {
+	m := compileRoutes(benchmarkRoutes(b))
+	for i := 0; b.Loop(); i++ {
+		if _, ok := m.match(benchmarkDestinations[i%len(benchmarkDestinations)], 443); !ok {
+			b.Fatal("no route")
+		}
+	}
+}
+
+func BenchmarkCompileRoutes(b *testing.B) {
+	routes := benchmarkRoutes(b)
+	for b.Loop() {
+		compileRoutes(routes)
+	}
+}
@@ -2,6 +2,7 @@ package router
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
 )
@@ -20,19 +21,32 @@ func (r Routes) GenerateCache() error {
 	return nil
 }
 
-func (r Routes) GetRoute(dst string) (transport.Transport, error) {
+func (r Routes) GetRoute(dst string, port uint16) (transport.Transport, error) {
+	egress, err := r.GetEgress(dst, port)
+	if err != nil {
+		return nil, err
+	}
+	return egress.GetTransportFor(dst)
+}
+
+// GetEgress returns the destination of the first route matching dst at port,
+// zero if unknown.
+func (r Routes) GetEgress(dst string, port uint16) (Egress, error) {
 	// dst is expected to already be a normalizeRouteKey result when called from
 	// the package GetRoute entrypoint; normalize again so direct callers are safe.
 	key := normalizeRouteKey(dst)
+	byPort := slices.ContainsFunc(r, func(route *Route) bool {
+		return route != nil && route.MatchType == TypePort
+	})
 	for i, route := range r {
 		if route == nil {
-			return nil, fmt.Errorf("route %d is nil", i)
+			return EgressUnknown, fmt.Errorf("route %d is nil", i)
 		}
-		if route.Match(key) {
-			table.Set(key, route.Destination)
+		if route.Match(key, port) {
+			table.Set(decisionKey(key, port, byPort), route.Destination)
 			//log.Printf("route cached: %s -> %s", key, route.Destination)
-			return route.Destination.GetTransport()
+			return route.Destination, nil
 		}
 	}
-	return nil, fmt.Errorf("route not found: %s -> nil", key)
+	return EgressUnknown, fmt.Errorf("route not found: %s -> nil", key)
 }
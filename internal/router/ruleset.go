@@ -0,0 +1,164 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
+)
+
+const (
+	// DefaultRuleSetInterval is how often a rule set is fetched again when
+	// its route sets no interval.
+	DefaultRuleSetInterval = 24 * time.Hour
+	// minRuleSetInterval is also how often RefreshRuleSets looks for rule
+	// sets due, and retries those whose last fetch failed.
+	minRuleSetInterval  = time.Minute
+	ruleSetFetchTimeout = 30 * time.Second
+	maxRuleSetSize      = 32 << 20
+)
+
+var ruleSetClient = &http.Client{Timeout: ruleSetFetchTimeout}
+
+// readSources returns the non-empty lines of rd that are not comments.
+func readSources(rd io.Reader) ([]string, error) {
+	var sources []string
+	b := bufio.NewScanner(rd)
+	for b.Scan() {
+		line := strings.TrimSpace(b.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sources = append(sources, line)
+	}
+	return sources, b.Err()
+}
+
+func (r *Route) ruleSetInterval() time.Duration {
+	if r.Interval <= 0 {
+		return DefaultRuleSetInterval
+	}
+	return max(time.Duration(r.Interval)*time.Second, minRuleSetInterval)
+}
+
+// ruleSetCachePath returns where the last fetched copy of the rule set is
+// kept: Ext when set, else under the user cache directory, empty if there is
+// none.
+func (r *Route) ruleSetCachePath() string {
+	if r.Ext != "" {
+		return r.Ext
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(r.URL))
+	return filepath.Join(dir, "spaceship", "rule-sets", hex.EncodeToString(sum[:8])+".list")
+}
+
+// loadRuleSet fetches the sources at r.URL and keeps a copy of them on disk.
+// When the fetch fails the copy kept is used instead, and fetched reports
+// false.
+func (r *Route) loadRuleSet() (sources []string, fetched bool, err error) {
+	log.Printf("fetching rule-set: %s", r.URL)
+	path := r.ruleSetCachePath()
+	body, err := fetchRuleSet(r.URL)
+	if err == nil {
+		if sources, err = readSources(bytes.NewReader(body)); err == nil {
+			if path != "" {
+				if err := keepRuleSet(path, body); err != nil {
+					log.Printf("rule-set: keep copy of %s failed: %v", r.URL, err)
+				}
+			}
+			return sources, true, nil
+		}
+	}
+	if path == "" {
+		return nil, false, fmt.Errorf("rule-set: %s: %w", r.URL, err)
+	}
+	f, openErr := os.Open(path)
+	if openErr != nil {
+		return nil, false, fmt.Errorf("rule-set: %s: %w", r.URL, err)
+	}
+	defer utils.Close(f)
+	log.Printf("rule-set: fetch %s failed, using the copy at %s: %v", r.URL, path, err)
+	if sources, err = readSources(f); err != nil {
+		return nil, false, fmt.Errorf("rule-set: read %s: %w", path, err)
+	}
+	return sources, false, nil
+}
+
+func fetchRuleSet(url string) ([]byte, error) {
+	resp, err := ruleSetClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer utils.Close(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRuleSetSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxRuleSetSize {
+		return nil, fmt.Errorf("larger than %dM", maxRuleSetSize>>20)
+	}
+	return body, nil
+}
+
+// keepRuleSet replaces the copy at path, never leaving a partial one.
+func keepRuleSet(path string, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// RefreshRuleSets fetches the rule sets of the installed routes again as
+// their intervals elapse, until ctx is done. A route is swapped only once
+// its rule set was fetched, so a failing source leaves the route as it was.
+// Only the top-level routes have rule sets; policies and users may not.
+func RefreshRuleSets(ctx context.Context) {
+	ticker := time.NewTicker(minRuleSetInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			refreshRuleSets(now)
+		}
+	}
+}
+
+func refreshRuleSets(now time.Time) {
+	due := func(r *Route) bool {
+		return r.URL != "" && now.Sub(r.cache.Fetched) >= r.ruleSetInterval()
+	}
+	n := regenerateRoutes(due, func(r *Route, err error) bool {
+		if err != nil {
+			log.Printf("rule-set: refresh %s failed: %v", r.URL, err)
+			return false
+		}
+		return !r.cache.Fetched.IsZero()
+	})
+	if n > 0 {
+		log.Printf("rule-set: %d route(s) refreshed", n)
+	}
+}
@@ -0,0 +1,118 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// ruleSetServer serves the list in body, or fails while body is empty.
+func ruleSetServer(t *testing.T, body *atomic.Value) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		list, _ := body.Load().(string)
+		if list == "" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(list))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestRoute_RuleSet(t *testing.T) {
+	var body atomic.Value
+	body.Store("# ads\nads.example\n\ntracker.example\n")
+	srv := ruleSetServer(t, &body)
+	kept := filepath.Join(t.TempDir(), "ads.list")
+
+	r := &Route{MatchType: TypeDomain, URL: srv.URL, Ext: kept, Sources: []string{"local.example"}}
+	if err := r.GenerateCache(); err != nil {
+		t.Fatalf("GenerateCache() error = %v", err)
+	}
+	if r.cache.Fetched.IsZero() {
+		t.Error("Fetched not set after a successful fetch")
+	}
+	for _, host := range []string{"ads.example", "x.tracker.example", "local.example"} {
+		if !r.Match(host, 443) {
+			t.Errorf("Match(%q) = false, want true", host)
+		}
+	}
+
+	// the kept copy stands in while the source fails
+	body.Store("")
+	if err := r.GenerateCache(); err != nil {
+		t.Fatalf("GenerateCache() with the source down error = %v", err)
+	}
+	if !r.cache.Fetched.IsZero() {
+		t.Error("Fetched set although the kept copy is in use")
+	}
+	if !r.Match("ads.example", 443) {
+		t.Error("Match() lost the rule set when its source failed")
+	}
+
+	// without a copy, a failing source is an error
+	r = &Route{MatchType: TypeDomain, URL: srv.URL, Ext: filepath.Join(t.TempDir(), "none.list")}
+	if err := r.GenerateCache(); err == nil {
+		t.Error("GenerateCache() succeeded with neither the source nor a copy")
+	}
+}
+
+func TestRefreshRuleSets(t *testing.T) {
+	routesMu.RLock()
+	saved := routesCache
+	routesMu.RUnlock()
+	t.Cleanup(func() {
+		if err := SetRoutes(saved); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	var body atomic.Value
+	body.Store("old.example\n")
+	srv := ruleSetServer(t, &body)
+	err := SetRoutes(Routes{
+		{MatchType: TypeDomain, URL: srv.URL, Ext: filepath.Join(t.TempDir(), "proxy.list"), Interval: 600, Destination: EgressProxy},
+		{MatchType: TypeDefault, Destination: EgressDirect},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	egressOf := func(host string) Egress {
+		t.Helper()
+		egress, err := GetEgress(host, 443)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return egress
+	}
+	if got := egressOf("old.example"); got != EgressProxy {
+		t.Fatalf("GetEgress(old.example) = %s, want proxy", got)
+	}
+
+	body.Store("new.example\n")
+	refreshRuleSets(time.Now().Add(5 * time.Minute))
+	if got := egressOf("new.example"); got != EgressDirect {
+		t.Fatalf("rule set refreshed before its interval: new.example -> %s", got)
+	}
+
+	// a failed fetch keeps the route
+	body.Store("")
+	refreshRuleSets(time.Now().Add(time.Hour))
+	if got := egressOf("old.example"); got != EgressProxy {
+		t.Fatalf("failed refresh changed the route: old.example -> %s", got)
+	}
+
+	body.Store("new.example\n")
+	refreshRuleSets(time.Now().Add(time.Hour))
+	if got := egressOf("new.example"); got != EgressProxy {
+		t.Errorf("GetEgress(new.example) = %s after refresh, want proxy", got)
+	}
+	if got := egressOf("old.example"); got != EgressDirect {
+		t.Errorf("GetEgress(old.example) = %s after refresh, want direct", got)
+	}
+}
@@ -34,7 +34,7 @@ func TestSmoke_RoutingAndCacheKeys(t *testing.T) {
 	// Case / trailing-dot variants of the same host must resolve identically
 	// and share a single cache key after the first lookup.
 	for _, host := range []string{"WWW.GOOGLE.COM", "www.google.com.", "www.google.com"} {
-		tr, err := GetRoute(host)
+		tr, err := GetRoute(host, 443)
 		if err != nil {
 			t.Fatalf("GetRoute(%q) error = %v", host, err)
 		}
@@ -53,13 +53,13 @@ func TestSmoke_RoutingAndCacheKeys(t *testing.T) {
 	}
 
 	// Exact match is case-insensitive; block egress surfaces ErrBlocked.
-	_, err := GetRoute("blocked.example")
+	_, err := GetRoute("blocked.example", 443)
 	if !errors.Is(err, transport.ErrBlocked) {
 		t.Fatalf("GetRoute(blocked.example) = %v, want ErrBlocked", err)
 	}
 
 	// Subdomain of a more-specific domain rule.
-	tr, err := GetRoute("cdn.www.github.com")
+	tr, err := GetRoute("cdn.www.github.com", 443)
 	if err != nil {
 		t.Fatalf("GetRoute(cdn.www.github.com) = %v", err)
 	}
@@ -71,10 +71,10 @@ func TestSmoke_RoutingAndCacheKeys(t *testing.T) {
 	// Sibling of www.github.com falls through to default (direct), not the domain rule.
 	r := &Route{Sources: []string{"www.github.com"}, MatchType: TypeDomain}
 	_ = r.GenerateCache()
-	if r.Match("api.github.com") {
+	if r.Match("api.github.com", 443) {
 		t.Fatal("api.github.com must not match domain rule www.github.com")
 	}
-	tr, err = GetRoute("api.github.com")
+	tr, err = GetRoute("api.github.com", 443)
 	if err != nil {
 		t.Fatalf("api.github.com should hit default: %v", err)
 	}
@@ -102,7 +102,7 @@ func TestSmoke_ConcurrentGetRoute(t *testing.T) {
 		go func(i int) {
 			defer wg.Done()
 			host := hosts[i%len(hosts)]
-			tr, err := GetRoute(host)
+			tr, err := GetRoute(host, 443)
 			if err != nil {
 				t.Errorf("GetRoute(%q) = %v", host, err)
 				return
@@ -140,7 +140,7 @@ func TestSetRoutesRejectsInvalidGeneration(t *testing.T) {
 	}
 
 	// Populate the host cache before attempting a failed reload.
-	tr, err := GetRoute("cached.example")
+	tr, err := GetRoute("cached.example", 443)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -152,7 +152,7 @@ func TestSetRoutesRejectsInvalidGeneration(t *testing.T) {
 		t.Fatal("SetRoutes() accepted an invalid regular expression")
 	}
 
-	tr, err = GetRoute("cached.example")
+	tr, err = GetRoute("cached.example", 443)
 	if err != nil {
 		t.Fatalf("failed reload replaced the previous route set: %v", err)
 	}
@@ -175,7 +175,7 @@ func TestSetRoutesClonesCallerState(t *testing.T) {
 	route.Sources[0] = "mutated.example"
 	route.Destination = EgressBlackHole
 
-	tr, err := GetRoute("stable.example")
+	tr, err := GetRoute("stable.example", 443)
 	if err != nil {
 		t.Fatalf("caller mutation changed installed route: %v", err)
 	}
@@ -198,7 +198,7 @@ func TestConcurrentRouteReloadAndLookup(t *testing.T) {
 		go func() {
 			defer wg.Done()
 			for i := 0; i < 200; i++ {
-				tr, err := GetRoute("reload.example")
+				tr, err := GetRoute("reload.example", 443)
 				if err != nil {
 					t.Errorf("GetRoute() during reload: %v", err)
 					return
@@ -243,7 +243,7 @@ func TestAddRoutesBuildsCacheAndInvalidatesHostCache(t *testing.T) {
 	if err := SetRoutes(Routes{{Destination: EgressDirect, MatchType: TypeDefault}}); err != nil {
 		t.Fatal(err)
 	}
-	tr, err := GetRoute("priority.example")
+	tr, err := GetRoute("priority.example", 443)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -256,7 +256,7 @@ func TestAddRoutesBuildsCacheAndInvalidatesHostCache(t *testing.T) {
 	}); err != nil {
 		t.Fatal(err)
 	}
-	tr, err = GetRoute("priority.example")
+	tr, err = GetRoute("priority.example", 443)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -276,7 +276,7 @@ func TestAddRoutesBuildsCacheAndInvalidatesHostCache(t *testing.T) {
 	}); err != nil {
 		t.Fatal(err)
 	}
-	tr, err = GetRoute("last.example")
+	tr, err = GetRoute("last.example", 443)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,98 @@
+package router
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Inbounds traffic enters by, as inbound routes name them.
+const (
+	InboundSocks = "socks"
+	InboundUnix  = "unix"
+	InboundHTTP  = "http"
+	InboundDNS   = "dns"
+
+	// transparent inbounds
+	InboundRedir  = "redir"
+	InboundTProxy = "tproxy"
+	InboundTun    = "tun"
+)
+
+var inbounds = []string{InboundSocks, InboundUnix, InboundHTTP, InboundDNS, InboundRedir, InboundTProxy, InboundTun}
+
+// Source is where traffic comes from: the inbound it entered by, the address
+// of the client and the user it authenticated as, each zero when unknown. The
+// routes of types inbound, source and user match by it, and never match an
+// unknown one.
+type Source struct {
+	Inbound string
+	Addr    netip.Addr
+	User    string
+}
+
+// key is prepended to the key decisions for traffic from s are cached by.
+func (s Source) key() string {
+	addr := ""
+	if s.Addr.IsValid() {
+		addr = s.Addr.Unmap().String()
+	}
+	return s.Inbound + " " + addr + " " + strconv.Quote(s.User) + " "
+}
+
+// parseInbound checks name is a known inbound.
+func parseInbound(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if !slices.Contains(inbounds, name) {
+		return "", fmt.Errorf("inbound: %q unknown", name)
+	}
+	return name, nil
+}
+
+// parseSourcePrefix parses a cidr, or a single address standing for itself.
+func parseSourcePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("source: %s parse failed: %w", s, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("source: %s parse failed: %w", s, err)
+	}
+	addr = addr.WithZone("").Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// matchSource reports whether the route, of a source-matching type, matches
+// traffic from src.
+func (r *Route) matchSource(src Source) bool {
+	switch r.MatchType {
+	case TypeInbound:
+		return src.Inbound != "" && slices.Contains(r.cache.Inbounds, src.Inbound)
+	case TypeSource:
+		if !src.Addr.IsValid() {
+			return false
+		}
+		addr := src.Addr.WithZone("").Unmap()
+		for _, prefix := range r.cache.SourceCIDRs {
+			if prefix.Contains(addr) {
+				return true
+			}
+		}
+	case TypeUser:
+		return src.User != "" && slices.Contains(r.cache.Users, src.User)
+	}
+	return false
+}
+
+// matchesSource reports whether routes of t match by source rather than by
+// destination.
+func (t Type) matchesSource() bool {
+	return t == TypeInbound || t == TypeSource || t == TypeUser
+}
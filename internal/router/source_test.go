@@ -0,0 +1,98 @@
+package router
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestRoute_MatchFrom(t *testing.T) {
+	lan := netip.MustParseAddr("192.168.1.50")
+	for _, tc := range []struct {
+		name  string
+		route *Route
+		src   Source
+		want  bool
+	}{
+		{"inbound", &Route{MatchType: TypeInbound, Sources: []string{"SOCKS", "unix"}}, Source{Inbound: InboundUnix}, true},
+		{"other inbound", &Route{MatchType: TypeInbound, Sources: []string{"socks"}}, Source{Inbound: InboundHTTP}, false},
+		{"address", &Route{MatchType: TypeSource, Sources: []string{"192.168.1.50"}}, Source{Addr: lan}, true},
+		{"mapped address", &Route{MatchType: TypeSource, Sources: []string{"192.168.1.0/24"}}, Source{Addr: netip.MustParseAddr("::ffff:192.168.1.50")}, true},
+		{"other address", &Route{MatchType: TypeSource, Sources: []string{"192.168.1.50", "10.0.0.0/8"}}, Source{Addr: netip.MustParseAddr("192.168.1.51")}, false},
+		{"user", &Route{MatchType: TypeUser, Sources: []string{"alice", "bob"}}, Source{User: "bob"}, true},
+		{"user case", &Route{MatchType: TypeUser, Sources: []string{"alice"}}, Source{User: "Alice"}, false},
+		{"unknown source", &Route{MatchType: TypeSource, Sources: []string{"0.0.0.0/0"}}, Source{}, false},
+		{"unknown user", &Route{MatchType: TypeUser, Sources: []string{"alice"}}, Source{Inbound: InboundSocks, Addr: lan}, false},
+		{"destination", &Route{MatchType: TypeDomain, Sources: []string{"example.com"}}, Source{Inbound: InboundSocks}, true},
+	} {
+		if err := tc.route.GenerateCache(); err != nil {
+			t.Fatalf("%s: GenerateCache() error = %v", tc.name, err)
+		}
+		if got := tc.route.MatchFrom(tc.src, "example.com", 443); got != tc.want {
+			t.Errorf("%s: MatchFrom(%+v) = %t, want %t", tc.name, tc.src, got, tc.want)
+		}
+	}
+
+	// without a source, routes matching by it never match
+	r := &Route{MatchType: TypeSource, Sources: []string{"::/0", "0.0.0.0/0"}}
+	if err := r.GenerateCache(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Match("1.1.1.1", 443) {
+		t.Error("Match() of a source route = true")
+	}
+}
+
+func TestRoute_SourceInvalid(t *testing.T) {
+	for _, r := range []*Route{
+		{MatchType: TypeInbound, Sources: []string{"ftp"}},
+		{MatchType: TypeSource, Sources: []string{"lan"}},
+		{MatchType: TypeSource, Sources: []string{"192.168.1.0/33"}},
+	} {
+		if err := r.GenerateCache(); err == nil {
+			t.Errorf("GenerateCache() accepted %s route %q", r.MatchType, r.Sources)
+		}
+	}
+}
+
+func TestGetEgressFrom(t *testing.T) {
+	routesMu.RLock()
+	saved := routesCache
+	routesMu.RUnlock()
+	t.Cleanup(func() {
+		if err := SetRoutes(saved); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	err := SetRoutes(Routes{
+		{MatchType: TypeSource, Sources: []string{"192.168.1.50"}, Destination: EgressProxy},
+		{MatchType: TypeUser, Sources: []string{"guest"}, Destination: EgressBlock},
+		{MatchType: TypeDefault, Destination: EgressDirect},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same destination is decided, and the decision cached, per source
+	device := Source{Inbound: InboundSocks, Addr: netip.MustParseAddr("192.168.1.50")}
+	other := Source{Inbound: InboundSocks, Addr: netip.MustParseAddr("192.168.1.51")}
+	guest := Source{Inbound: InboundHTTP, Addr: netip.MustParseAddr("192.168.1.51"), User: "guest"}
+	for _, tc := range []struct {
+		src  Source
+		want Egress
+	}{
+		{device, EgressProxy},
+		{other, EgressDirect},
+		{device, EgressProxy},
+		{guest, EgressBlock},
+		{other, EgressDirect},
+		{Source{}, EgressDirect},
+	} {
+		got, err := GetEgressFrom(tc.src, "example.com", 443)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("GetEgressFrom(%+v, example.com) = %s, want %s", tc.src, got, tc.want)
+		}
+	}
+}
@@ -7,5 +7,12 @@ const (
 	TypeDomain  Type = "domain"
 	TypeCIDR    Type = "cidr"
 	TypeRegex   Type = "regex"
+	TypeGeoIP   Type = "geoip"
+	TypeKeyword Type = "keyword"
+	TypeGlob    Type = "glob"
+	TypePort    Type = "port"
+	TypeInbound Type = "inbound"
+	TypeSource  Type = "source"
+	TypeUser    Type = "user"
 	TypeDefault Type = "default"
 )
@@ -0,0 +1,115 @@
+package router
+
+import (
+	"context"
+	"io"
+	"log"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
+)
+
+const (
+	// routeFilesSyncInterval is how often the watched files are matched
+	// against the installed routes.
+	routeFilesSyncInterval = time.Second
+	// routeFilesSettle lets a burst of writes to a file end before it is
+	// read.
+	routeFilesSettle       = 200 * time.Millisecond
+	routeFilesPollInterval = 2 * time.Second
+)
+
+// fileWatcher reports changes to a set of files by their cleaned paths.
+type fileWatcher interface {
+	io.Closer
+	// Watch replaces the set of files watched.
+	Watch(paths []string) error
+	Changes() <-chan string
+}
+
+// WatchRouteFiles rebuilds the routes whose route-ext file changes, until
+// ctx is done. A file that fails to load is rejected and the route keeps its
+// rules. Rule sets fetched from a URL and routes of policies are not
+// watched.
+func WatchRouteFiles(ctx context.Context) {
+	w, err := newFileWatcher()
+	if err != nil {
+		log.Printf("route-ext: %v, polling files instead", err)
+		w = newPollWatcher(routeFilesPollInterval)
+	}
+	defer utils.Close(w)
+	watchRouteFiles(ctx, w)
+}
+
+func watchRouteFiles(ctx context.Context, w fileWatcher) {
+	ticker := time.NewTicker(routeFilesSyncInterval)
+	defer ticker.Stop()
+
+	var watched []string
+	sync := func() {
+		paths := routeFiles()
+		if slices.Equal(paths, watched) {
+			return
+		}
+		// files that could not be watched are retried when the routes change
+		if err := w.Watch(paths); err != nil {
+			log.Printf("route-ext: watch failed: %v", err)
+		}
+		watched = paths
+	}
+	sync()
+
+	changed := make(map[string]struct{})
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			sync()
+		case path := <-w.Changes():
+			changed[path] = struct{}{}
+			settle = time.After(routeFilesSettle)
+		case <-settle:
+			reloadRouteFiles(changed)
+			changed = make(map[string]struct{})
+			settle = nil
+		}
+	}
+}
+
+// routeFiles returns the sorted route-ext files of the installed routes.
+func routeFiles() []string {
+	routesMu.RLock()
+	defer routesMu.RUnlock()
+	var paths []string
+	for _, route := range routesCache {
+		if route != nil && route.Ext != "" && route.URL == "" {
+			paths = append(paths, filepath.Clean(route.Ext))
+		}
+	}
+	slices.Sort(paths)
+	return slices.Compact(paths)
+}
+
+func reloadRouteFiles(changed map[string]struct{}) {
+	due := func(r *Route) bool {
+		if r.Ext == "" || r.URL != "" {
+			return false
+		}
+		_, ok := changed[filepath.Clean(r.Ext)]
+		return ok
+	}
+	n := regenerateRoutes(due, func(r *Route, err error) bool {
+		if err != nil {
+			log.Printf("route-ext: %s rejected, keeping the current rules: %v", r.Ext, err)
+			return false
+		}
+		return true
+	})
+	if n > 0 {
+		log.Printf("route-ext: %d route(s) reloaded", n)
+	}
+}
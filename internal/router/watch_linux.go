@@ -0,0 +1,131 @@
+package router
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// inotifyMask catches a file written in place, replaced by a rename as
+// editors do, or removed.
+const inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_DELETE
+
+// inotifyWatcher watches the directories of the files, so that files
+// replaced rather than rewritten are still seen.
+type inotifyWatcher struct {
+	fd      int
+	f       *os.File
+	changes chan string
+	done    chan struct{}
+	once    sync.Once
+
+	mu    sync.Mutex
+	dirs  map[string]int // directory -> watch descriptor
+	wds   map[int]string
+	files map[string]struct{}
+}
+
+func newFileWatcher() (fileWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify: %w", err)
+	}
+	w := &inotifyWatcher{
+		// a non-blocking fd is served by the runtime poller, so closing
+		// the file ends a pending read
+		fd:      fd,
+		f:       os.NewFile(uintptr(fd), "inotify"),
+		changes: make(chan string),
+		done:    make(chan struct{}),
+		dirs:    make(map[string]int),
+		wds:     make(map[int]string),
+		files:   make(map[string]struct{}),
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Watch(paths []string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	files := make(map[string]struct{}, len(paths))
+	dirs := make(map[string]struct{})
+	for _, path := range paths {
+		files[path] = struct{}{}
+		dirs[filepath.Dir(path)] = struct{}{}
+	}
+	var errs []error
+	for dir := range dirs {
+		if _, ok := w.dirs[dir]; ok {
+			continue
+		}
+		wd, err := unix.InotifyAddWatch(w.fd, dir, inotifyMask)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("inotify: watch %s: %w", dir, err))
+			continue
+		}
+		w.dirs[dir] = wd
+		w.wds[wd] = dir
+	}
+	for dir, wd := range w.dirs {
+		if _, ok := dirs[dir]; !ok {
+			_, _ = unix.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.dirs, dir)
+			delete(w.wds, wd)
+		}
+	}
+	w.files = files
+	return errors.Join(errs...)
+}
+
+func (w *inotifyWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *inotifyWatcher) read() {
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+			if event.Len == 0 || offset > n {
+				continue
+			}
+			name := string(bytes.TrimRight(buf[nameStart:offset], "\x00"))
+
+			w.mu.Lock()
+			path := filepath.Join(w.wds[int(event.Wd)], name)
+			_, watched := w.files[path]
+			w.mu.Unlock()
+			if !watched {
+				continue
+			}
+			select {
+			case w.changes <- path:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+func (w *inotifyWatcher) Close() error {
+	var err error
+	w.once.Do(func() {
+		close(w.done)
+		err = w.f.Close()
+	})
+	return err
+}
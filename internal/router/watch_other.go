@@ -0,0 +1,7 @@
+//go:build !linux
+
+package router
+
+func newFileWatcher() (fileWatcher, error) {
+	return newPollWatcher(routeFilesPollInterval), nil
+}
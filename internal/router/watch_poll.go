@@ -0,0 +1,91 @@
+package router
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// pollWatcher finds changed files by comparing their modification time and
+// size every interval.
+type pollWatcher struct {
+	mu      sync.Mutex
+	files   map[string]fileStamp
+	changes chan string
+	done    chan struct{}
+	once    sync.Once
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func stampFile(path string) fileStamp {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{modTime: fi.ModTime(), size: fi.Size(), exists: true}
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{
+		files:   make(map[string]fileStamp),
+		changes: make(chan string),
+		done:    make(chan struct{}),
+	}
+	go w.poll(interval)
+	return w
+}
+
+func (w *pollWatcher) Watch(paths []string) error {
+	files := make(map[string]fileStamp, len(paths))
+	for _, path := range paths {
+		files[path] = stampFile(path)
+	}
+	w.mu.Lock()
+	w.files = files
+	w.mu.Unlock()
+	return nil
+}
+
+func (w *pollWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *pollWatcher) poll(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		var changed []string
+		w.mu.Lock()
+		for path, stamp := range w.files {
+			if now := stampFile(path); now != stamp {
+				w.files[path] = now
+				changed = append(changed, path)
+			}
+		}
+		w.mu.Unlock()
+
+		for _, path := range changed {
+			select {
+			case w.changes <- path:
+			case <-w.done:
+				return
+			}
+		}
+	}
+}
+
+func (w *pollWatcher) Close() error {
+	w.once.Do(func() { close(w.done) })
+	return nil
+}
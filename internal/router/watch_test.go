@@ -0,0 +1,103 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitEgress waits for dst to be routed to want.
+func waitEgress(t *testing.T, dst string, want Egress) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got, err := GetEgress(dst, 443)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("GetEgress(%s) = %s, want %s", dst, got, want)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func testWatchRouteFiles(t *testing.T, w fileWatcher) {
+	routesMu.RLock()
+	saved := routesCache
+	routesMu.RUnlock()
+	t.Cleanup(func() {
+		if err := SetRoutes(saved); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	dir := t.TempDir()
+	ext := filepath.Join(dir, "proxy.list")
+	if err := os.WriteFile(ext, []byte("10.0.0.0/8\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := SetRoutes(Routes{
+		{MatchType: TypeCIDR, Ext: ext, Destination: EgressProxy},
+		{MatchType: TypeDefault, Destination: EgressDirect},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitEgress(t, "10.1.1.1", EgressProxy)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchRouteFiles(ctx, w)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		_ = w.Close()
+	})
+
+	// rewritten in place
+	time.Sleep(100 * time.Millisecond)
+	if err = os.WriteFile(ext, []byte("192.168.0.0/16\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	waitEgress(t, "192.168.1.1", EgressProxy)
+	waitEgress(t, "10.1.1.1", EgressDirect)
+
+	// a broken file keeps the rules, and a fixed one replacing it by
+	// rename is picked up
+	if err = os.WriteFile(ext, []byte("not a cidr\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(routeFilesSettle + 300*time.Millisecond)
+	waitEgress(t, "192.168.1.1", EgressProxy)
+
+	tmp := filepath.Join(dir, "proxy.list.new")
+	if err = os.WriteFile(tmp, []byte("172.16.0.0/12\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(tmp, ext); err != nil {
+		t.Fatal(err)
+	}
+	waitEgress(t, "172.16.1.1", EgressProxy)
+	waitEgress(t, "192.168.1.1", EgressDirect)
+}
+
+func TestWatchRouteFiles(t *testing.T) {
+	w, err := newFileWatcher()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testWatchRouteFiles(t, w)
+}
+
+func TestWatchRouteFiles_Poll(t *testing.T) {
+	testWatchRouteFiles(t, newPollWatcher(50*time.Millisecond))
+}
@@ -12,6 +12,18 @@ import (
 )
 
 func TestHandleRequest_BindUnsupported(t *testing.T) {
+	// a blackhole route cannot take connections in
+	if err := router.SetRoutes(router.Routes{
+		{MatchType: router.TypeDefault, Destination: router.EgressBlackHole},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = router.SetRoutes(router.Routes{
+			{MatchType: router.TypeDefault, Destination: router.EgressDirect},
+		})
+	})
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 	s := New(ctx, &Config{})
@@ -47,6 +59,77 @@ func TestHandleRequest_BindUnsupported(t *testing.T) {
 	}
 }
 
+func TestHandleBind_SuccessDirect(t *testing.T) {
+	if err := router.SetRoutes(router.Routes{
+		{MatchType: router.TypeDefault, Destination: router.EgressDirect},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, &Config{})
+
+	serverSide, clientSide := net.Pipe()
+	defer clientSide.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		req := &Request{
+			Command:  BindCommand,
+			DestAddr: &AddrSpec{IP: net.ParseIP("127.0.0.1"), Port: 0},
+			bufConn:  serverSide,
+		}
+		done <- s.handleBind(ctx, serverSide, req)
+		_ = serverSide.Close()
+	}()
+
+	readReply := func() *AddrSpec {
+		t.Helper()
+		head := make([]byte, 4)
+		if _, err := io.ReadFull(clientSide, head); err != nil {
+			t.Fatalf("read reply: %v", err)
+		}
+		if head[1] != successReply {
+			t.Fatalf("reply code = %d, want success", head[1])
+		}
+		addr, err := readAddrSpec(io.MultiReader(bytes.NewReader(head[3:]), clientSide))
+		if err != nil {
+			t.Fatalf("read reply address: %v", err)
+		}
+		return addr
+	}
+
+	listening := readReply()
+	peer, err := net.Dial("tcp", listening.Address())
+	if err != nil {
+		t.Fatalf("dial bound address %s: %v", listening.Address(), err)
+	}
+	defer peer.Close()
+	if got := readReply(); got.Address() != peer.LocalAddr().String() {
+		t.Fatalf("second reply = %s, want peer %s", got.Address(), peer.LocalAddr())
+	}
+
+	if _, err := peer.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(clientSide, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("client read %q, %v", buf, err)
+	}
+
+	_ = peer.Close()
+	_ = clientSide.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("handleBind error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleBind did not return")
+	}
+}
+
 func TestHandleRequest_UnsupportedCommand(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
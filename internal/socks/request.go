@@ -7,9 +7,12 @@ import (
 	"io"
 	"log"
 	"net"
+	"net/netip"
 	"strconv"
 
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
 	"golang.org/x/sync/errgroup"
 )
@@ -74,6 +77,22 @@ type Request struct {
 	// AddrSpec of the desired destination
 	DestAddr *AddrSpec
 	bufConn  io.Reader
+	// inbound is the router inbound the request came in by.
+	inbound string
+}
+
+// source is where the request comes from, as routes see it.
+func (req *Request) source() router.Source {
+	src := router.Source{Inbound: req.inbound}
+	if req.RemoteAddr != nil {
+		if addr, ok := netip.AddrFromSlice(req.RemoteAddr.IP); ok {
+			src.Addr = addr.Unmap()
+		}
+	}
+	if req.AuthContext != nil {
+		src.User = req.AuthContext.Payload["Username"]
+	}
+	return src
 }
 
 type ConnWriter interface {
@@ -133,10 +152,11 @@ func (s *Server) handleConnect(ctx context.Context, conn ConnWriter, req *Reques
 	// set host dst
 	host := req.DestAddr.FQDN
 	if host == "" {
-		host = req.DestAddr.IP.String()
+		// an address handed out by the fake-IP DNS server routes by its name
+		host = fakeip.Resolve(req.DestAddr.IP.String())
 	}
 
-	route, err := router.GetRoute(host)
+	route, err := router.GetRouteFrom(req.source(), host, req.DestAddr.Port)
 	if err != nil {
 		log.Printf("socks: no route for %s: %v", host, err)
 		if err = sendReply(conn, ruleFailure, nil); err != nil {
@@ -186,11 +206,67 @@ func (s *Server) handleConnect(ctx context.Context, conn ConnWriter, req *Reques
 	return nil
 }
 
-// handleBind is used to handle a bind command
-func (s *Server) handleBind(_ context.Context, conn ConnWriter, _ *Request) error {
-	// TODO: Support bind
-	if err := sendReply(conn, commandNotSupported, nil); err != nil {
-		return fmt.Errorf("failed to send reply: %v", err)
+// handleBind is used to handle a bind command. The route of the expected
+// peer listens for it: the first reply carries the address listened on, the
+// second the address of the peer once it connected.
+func (s *Server) handleBind(ctx context.Context, conn ConnWriter, req *Request) error {
+	host := req.DestAddr.FQDN
+	if host == "" {
+		host = fakeip.Resolve(req.DestAddr.IP.String())
+	}
+
+	route, err := router.GetRouteFrom(req.source(), host, req.DestAddr.Port)
+	if err != nil {
+		log.Printf("socks: no route for %s: %v", host, err)
+		if err = sendReply(conn, ruleFailure, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %w", err)
+		}
+		return nil
+	}
+	defer utils.Close(route)
+
+	binder, ok := route.(transport.Binder)
+	if !ok {
+		log.Printf("socks: bind %s: not supported by %s", host, route)
+		if err = sendReply(conn, commandNotSupported, nil); err != nil {
+			return fmt.Errorf("failed to send reply: %w", err)
+		}
+		return nil
+	}
+
+	log.Printf("socks: bind %s:%d -> %s", host, req.DestAddr.Port, route)
+
+	addr := net.JoinHostPort(host, strconv.FormatUint(uint64(req.DestAddr.Port), 10))
+	errGroup, ctx := errgroup.WithContext(ctx)
+	bound := make(chan string)
+	errGroup.Go(func() error {
+		return binder.Bind(ctx, addr, bound, conn, req.bufConn)
+	})
+
+	errGroup.Go(func() error {
+		// the listening address, then the peer; a failure before either is
+		// reported in its place
+		for _, failure := range []uint8{networkUnreachable, hostUnreachable} {
+			addr, ok := <-bound
+			if !ok || addr == "" {
+				if err := sendReply(conn, failure, nil); err != nil {
+					return fmt.Errorf("failed to send reply: %w", err)
+				}
+				return fmt.Errorf("bind failed for %s", host)
+			}
+			ip, port, err := utils.SplitHostPort(addr)
+			if err != nil {
+				return fmt.Errorf("failed to split host and port: %w", err)
+			}
+			if err = sendReply(conn, successReply, &AddrSpec{IP: net.ParseIP(ip), Port: port}); err != nil {
+				return fmt.Errorf("failed to send reply: %w", err)
+			}
+		}
+		return nil
+	})
+
+	if err = errGroup.Wait(); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
+		log.Printf("socks: bind %s failed: %v", addr, err)
 	}
 	return nil
 }
@@ -238,6 +314,8 @@ func (s *Server) handleAssociate(ctx context.Context, conn ConnWriter, req *Requ
 		return fmt.Errorf("socks5: udp associate: %w", err)
 	}
 
+	relay.source = req.source()
+
 	// Parse the relay's bound address to build the SOCKS5 reply.
 	relayAddr := relay.RelayAddr().(*net.UDPAddr)
 	bind := &AddrSpec{IP: relayAddr.IP, Port: uint16(relayAddr.Port)}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"io"
 	"net"
+	"net/netip"
 	"reflect"
 	"testing"
 	"time"
@@ -211,3 +212,20 @@ func TestHandleAssociate_ContextCancelStopsRelay(t *testing.T) {
 		t.Fatal("handleAssociate() did not return after context cancellation")
 	}
 }
+
+func TestRequest_Source(t *testing.T) {
+	req := &Request{
+		AuthContext: &AuthContext{Method: UserPassAuth, Payload: map[string]string{"Username": "alice"}},
+		RemoteAddr:  &AddrSpec{IP: net.ParseIP("192.168.1.50"), Port: 51000},
+		inbound:     router.InboundUnix,
+	}
+	want := router.Source{Inbound: router.InboundUnix, Addr: netip.MustParseAddr("192.168.1.50"), User: "alice"}
+	if got := req.source(); got != want {
+		t.Errorf("source() = %+v, want %+v", got, want)
+	}
+
+	// nothing known about the client
+	if got := (&Request{}).source(); got != (router.Source{}) {
+		t.Errorf("source() = %+v, want zero", got)
+	}
+}
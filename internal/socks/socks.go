@@ -9,6 +9,7 @@ import (
 	"net"
 	"sync"
 
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
 )
 
@@ -143,6 +144,10 @@ func (s *Server) ServeConn(conn net.Conn) error {
 		return fmt.Errorf("read request: %w", err)
 	}
 	request.AuthContext = authContext
+	request.inbound = router.InboundSocks
+	if conn.LocalAddr().Network() == "unix" {
+		request.inbound = router.InboundUnix
+	}
 	if client, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
 		if client.Port < 0 || client.Port > 65535 {
 			return fmt.Errorf("%w: invalid port: %d", ErrIllegalRequest, client.Port)
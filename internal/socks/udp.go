@@ -11,8 +11,10 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
 	"golang.org/x/sync/singleflight"
 )
 
@@ -329,11 +331,14 @@ func (a domainAddr) String() string  { return string(a) }
 type natEntry struct {
 	conn       net.PacketConn
 	targetAddr net.Addr
-	route      transport.Transport
-	limiter    *udpResourceLimiter
-	clientKey  string
-	lastSeen   atomic.Int64 // unix nanoseconds
-	closeOnce  sync.Once
+	// replyAddr, when set, is reported to the client as the source of
+	// responses in place of targetAddr: the fake IP it sent to.
+	replyAddr net.Addr
+	route     transport.Transport
+	limiter   *udpResourceLimiter
+	clientKey string
+	lastSeen  atomic.Int64 // unix nanoseconds
+	closeOnce sync.Once
 }
 
 type natTable struct {
@@ -459,7 +464,9 @@ type UDPRelay struct {
 	associationLimiter *udpResourceLimiter
 	natLimiter         *udpResourceLimiter
 	associationHeld    bool
-	getRoute           func(string) (transport.Transport, error)
+	getRoute           func(string, uint16) (transport.Transport, error)
+	// source is where the association comes from, for routing its datagrams.
+	source router.Source
 }
 
 type udpListenFunc func(network, address string) (net.PacketConn, error)
@@ -528,7 +535,7 @@ func newUDPRelayWithListener(
 		_ = uc.SetWriteBuffer(udpSocketBuffer)
 	}
 
-	return &UDPRelay{
+	r := &UDPRelay{
 		relay:              relayConn,
 		clientIP:           clientIP,
 		jobs:               make(chan udpPacket, udpJobQueueSize),
@@ -538,8 +545,14 @@ func newUDPRelayWithListener(
 		associationLimiter: associationLimiter,
 		natLimiter:         natLimiter,
 		associationHeld:    true,
-		getRoute:           router.GetRoute,
-	}, nil
+	}
+	r.getRoute = r.route
+	return r, nil
+}
+
+// route returns the transport for datagrams to host at port.
+func (r *UDPRelay) route(host string, port uint16) (transport.Transport, error) {
+	return router.GetRouteFrom(r.source, host, port)
 }
 
 // RelayAddr returns the address the client should send UDP datagrams to.
@@ -690,16 +703,23 @@ func (r *UDPRelay) getOrCreateNAT(targetAddr string, clientAddr net.Addr) (*natE
 			return entry, nil
 		}
 
-		host, _, err := net.SplitHostPort(targetAddr)
+		host, port, err := net.SplitHostPort(targetAddr)
 		if err != nil {
 			host = targetAddr // fallback
 		}
+		dialAddr := targetAddr
+		var replyAddr net.Addr
+		if name, ok := fakeip.Lookup(host); ok {
+			host = name
+			dialAddr = net.JoinHostPort(name, port)
+			replyAddr = domainAddr(targetAddr)
+		}
 
 		getRoute := r.getRoute
 		if getRoute == nil {
-			getRoute = router.GetRoute
+			getRoute = r.route
 		}
-		route, err := getRoute(host)
+		route, err := getRoute(host, utils.ParsePort(port))
 		if err != nil {
 			return nil, fmt.Errorf("route error: %w", err)
 		}
@@ -721,7 +741,7 @@ func (r *UDPRelay) getOrCreateNAT(targetAddr string, clientAddr net.Addr) (*natE
 			return nil, ErrUDPNATLimit
 		}
 
-		outbound, resolved, err := dialPacketTarget(route, "udp", targetAddr)
+		outbound, resolved, err := dialPacketTarget(route, "udp", dialAddr)
 		if err != nil {
 			limiter.release(r.clientKey)
 			_ = route.Close()
@@ -731,6 +751,7 @@ func (r *UDPRelay) getOrCreateNAT(targetAddr string, clientAddr net.Addr) (*natE
 		entry := &natEntry{
 			conn:       outbound,
 			targetAddr: resolved,
+			replyAddr:  replyAddr,
 			route:      route,
 			limiter:    limiter,
 			clientKey:  r.clientKey,
@@ -863,6 +884,9 @@ func (r *UDPRelay) reverseRelay(entry *natEntry, key string, clientAddr net.Addr
 			continue
 		}
 
+		if entry.replyAddr != nil {
+			respAddr = entry.replyAddr
+		}
 		respSpec, ok := addrSpecFromNetAddr(respAddr)
 		if !ok {
 			continue
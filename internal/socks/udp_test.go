@@ -12,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
 )
@@ -725,7 +726,7 @@ func TestUDPRelayDialFailureReleasesNATLimitAndRoute(t *testing.T) {
 	dialErr := errors.New("dial failed")
 	outbound := &scriptedPacketConn{}
 	route := &targetAwareTransport{conn: outbound, dialErr: dialErr}
-	relay.getRoute = func(string) (transport.Transport, error) { return route, nil }
+	relay.getRoute = func(string, uint16) (transport.Transport, error) { return route, nil }
 
 	_, err = relay.getOrCreateNAT("proxy.example:53", testClientAddr())
 	if !errors.Is(err, dialErr) {
@@ -762,7 +763,7 @@ func TestUDPRelayNATLimitReleasedOnClose(t *testing.T) {
 	if err != nil {
 		t.Fatalf("newUDPRelay() error = %v", err)
 	}
-	relay.getRoute = func(host string) (transport.Transport, error) {
+	relay.getRoute = func(host string, _ uint16) (transport.Transport, error) {
 		return &targetAwareTransport{
 			conn:   newBlockingPacketConn(),
 			target: testPacketAddr(net.JoinHostPort(host, "53")),
@@ -1001,7 +1002,7 @@ func TestUDPRelay_NATKeyedByClientAddr(t *testing.T) {
 	}
 	defer relay.Close()
 
-	relay.getRoute = func(host string) (transport.Transport, error) {
+	relay.getRoute = func(host string, _ uint16) (transport.Transport, error) {
 		return &targetAwareTransport{
 			conn:   newBlockingPacketConn(),
 			target: testPacketAddr(net.JoinHostPort(host, "53")),
@@ -1239,3 +1240,75 @@ func TestNATKey(t *testing.T) {
 		t.Errorf("natKey(nil, %q) = %q, want %q", target, got, target)
 	}
 }
+
+// TestUDPRelay_FakeIPTarget verifies a datagram sent to a fake IP is routed and
+// dialed by the name it stands for, while responses keep reporting the fake IP
+// the client sent to.
+func TestUDPRelay_FakeIPTarget(t *testing.T) {
+	pool, err := fakeip.New(fakeip.DefaultRange, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeip.SetDefault(pool)
+	t.Cleanup(func() { fakeip.SetDefault(nil) })
+	fake, _ := pool.Get("game.example", false)
+	target := net.JoinHostPort(fake.String(), "53")
+
+	relay, err := newUDPRelayWithListener(
+		net.ParseIP("127.0.0.1"),
+		nil,
+		newUDPResourceLimiter(4, 4),
+		newUDPResourceLimiter(8, 8),
+		8,
+		func(_, _ string) (net.PacketConn, error) { return &scriptedPacketConn{}, nil },
+	)
+	if err != nil {
+		t.Fatalf("newUDPRelayWithListener() error = %v", err)
+	}
+	defer relay.Close()
+
+	var routed string
+	relay.getRoute = func(host string, _ uint16) (transport.Transport, error) {
+		routed = host
+		return &targetAwareTransport{
+			conn:   newBlockingPacketConn(),
+			target: testPacketAddr(net.JoinHostPort(host, "53")),
+		}, nil
+	}
+
+	entry, err := relay.getOrCreateNAT(target, testClientAddr())
+	if err != nil {
+		t.Fatalf("getOrCreateNAT() error = %v", err)
+	}
+	if routed != "game.example" {
+		t.Fatalf("routed host = %q, want game.example", routed)
+	}
+	if entry.targetAddr.String() != "game.example:53" {
+		t.Fatalf("dialed target = %v, want game.example:53", entry.targetAddr)
+	}
+	if entry.replyAddr == nil || entry.replyAddr.String() != target {
+		t.Fatalf("reply address = %v, want %s", entry.replyAddr, target)
+	}
+}
+
+func TestReverseRelay_ReportsFakeIPSource(t *testing.T) {
+	relaySock := &scriptedPacketConn{}
+	relay := &UDPRelay{relay: relaySock, done: make(chan struct{})}
+
+	outbound := &scriptedPacketConn{reads: []readEvent{
+		{data: []byte("PONG"), addr: testPacketAddr("game.example:53")},
+	}}
+	entry := &natEntry{conn: outbound, replyAddr: domainAddr("198.18.0.1:53")}
+	relay.reverseRelay(entry, natKey(testClientAddr(), "198.18.0.1:53"), testClientAddr(), "198.18.0.1:53")
+
+	if relaySock.writeCount() != 1 {
+		t.Fatalf("writes to client = %d, want 1", relaySock.writeCount())
+	}
+	hdr, err := ParseUDPHeader(relaySock.writes[0])
+	if err != nil {
+		t.Fatalf("relayed datagram header parse: %v", err)
+	}
+	if hdr.Addr.IP.String() != "198.18.0.1" || hdr.Addr.Port != 53 {
+		t.Errorf("relayed header = %v:%d, want 198.18.0.1:53", hdr.Addr.IP, hdr.Addr.Port)
+	}
+}
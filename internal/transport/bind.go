@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// BindTimeout bounds how long a BIND waits for the peer to connect.
+const BindTimeout = 2 * time.Minute
+
+// ListenBind listens on the local address that routes to addr, the peer a
+// BIND expects, and returns the listener with the address to report. A
+// connected UDP socket picks that address without sending anything. The port
+// of addr is often zero in BIND requests and does not matter for the route.
+// A peer the installed guard refuses to dial is refused here as well.
+func ListenBind(addr string) (net.Listener, string, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, "", fmt.Errorf("bind: invalid address %q: %w", addr, err)
+	}
+	probe, err := net.Dial(DialNetwork("udp"), net.JoinHostPort(host, "9"))
+	if err != nil {
+		return nil, "", fmt.Errorf("bind: no route to %s: %w", host, err)
+	}
+	local := probe.LocalAddr().(*net.UDPAddr).IP
+	peer := probe.RemoteAddr().String()
+	_ = probe.Close()
+	if g := GetGuard(); g != nil {
+		if err = g.CheckAddress(peer); err != nil {
+			return nil, "", fmt.Errorf("bind: %w", err)
+		}
+	}
+
+	ln, err := net.Listen(DialNetwork("tcp"), net.JoinHostPort(local.String(), "0"))
+	if err != nil {
+		return nil, "", fmt.Errorf("bind: %w", err)
+	}
+	return ln, ln.Addr().String(), nil
+}
+
+// AcceptBind takes the first connection on ln from the peer a BIND expects
+// and closes ln. When addr names an IP, connections from other hosts are
+// turned away; a name or an unspecified address admits any host the installed
+// guard does not refuse. It gives up after BindTimeout or once ctx is done.
+func AcceptBind(ctx context.Context, ln net.Listener, addr string) (net.Conn, error) {
+	defer func() { _ = ln.Close() }()
+	stop := context.AfterFunc(ctx, func() { _ = ln.Close() })
+	defer stop()
+	if tl, ok := ln.(*net.TCPListener); ok {
+		_ = tl.SetDeadline(time.Now().Add(BindTimeout))
+	}
+
+	var want netip.Addr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip, err := netip.ParseAddr(host); err == nil && !ip.IsUnspecified() {
+			want = ip.Unmap()
+		}
+	}
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("bind: accept: %w", err)
+		}
+		if want.IsValid() {
+			peer, err := netip.ParseAddrPort(conn.RemoteAddr().String())
+			if err != nil || peer.Addr().Unmap() != want {
+				log.Printf("bind: refused %s, expecting %s", conn.RemoteAddr(), want)
+				_ = conn.Close()
+				continue
+			}
+		}
+		if g := GetGuard(); g != nil {
+			if err = g.CheckAddress(conn.RemoteAddr().String()); err != nil {
+				log.Printf("bind: refused %s: %v", conn.RemoteAddr(), err)
+				_ = conn.Close()
+				continue
+			}
+		}
+		return conn, nil
+	}
+}
@@ -0,0 +1,35 @@
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAcceptBindRefusesOtherPeers(t *testing.T) {
+	ln, addr, err := ListenBind("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := AcceptBind(ctx, ln, "192.0.2.1:0")
+		errCh <- err
+	}()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection from an unexpected peer was not closed")
+	}
+	cancel()
+	if err := <-errCh; err == nil {
+		t.Fatal("AcceptBind() accepted an unexpected peer")
+	}
+}
@@ -5,6 +5,13 @@ import (
 	"time"
 )
 
+const (
+	// DefaultBufferSize is the transport buffer size in KB used when none is configured.
+	DefaultBufferSize uint16 = 32
+	// DefaultIdleTimeout is the idle timeout used when none is configured.
+	DefaultIdleTimeout = 30 * time.Minute
+)
+
 var (
 	bufferSize atomic.Int64
 	network    atomic.Value
@@ -23,10 +30,10 @@ var (
 
 func init() {
 	// BufferSize default: 32K (1K == 1024 Byte)
-	bufferSize.Store(int64(32 * 1024))
+	SetBufferSize(DefaultBufferSize)
 	network.Store("tcp")
 	dialTimeout.Store(int64(3 * time.Minute))
-	idleTimeout.Store(int64(30 * time.Minute))
+	idleTimeout.Store(int64(DefaultIdleTimeout))
 }
 
 // GetBufferSize returns the current buffer size.
@@ -0,0 +1,21 @@
+package direct
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindControl binds sockets to iface with SO_BINDTODEVICE, which takes
+// CAP_NET_RAW.
+func bindControl(iface string) (func(network, address string, c syscall.RawConn) error, error) {
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if ctrlErr := c.Control(func(fd uintptr) {
+			err = unix.BindToDevice(int(fd), iface)
+		}); ctrlErr != nil {
+			return ctrlErr
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,40 @@
+package direct
+
+import (
+	"net"
+	"testing"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+)
+
+func TestNewBoundRejectsUnknownInterface(t *testing.T) {
+	if _, err := NewBound("nonexistent0"); err == nil {
+		t.Fatal("NewBound() error = nil, want unknown interface")
+	}
+}
+
+// A socket bound to the loopback device reaches loopback peers over TCP
+// and UDP.
+func TestNewBoundDialsThroughInterface(t *testing.T) {
+	d, err := NewBound("lo")
+	if err != nil {
+		t.Fatalf("NewBound() error = %v", err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conn, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	_ = conn.Close()
+
+	pc, err := d.(transport.PacketDialer).DialPacket("udp", "127.0.0.1:9")
+	if err != nil {
+		t.Fatalf("DialPacket() error = %v", err)
+	}
+	_ = pc.Close()
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package direct
+
+import (
+	"errors"
+	"syscall"
+)
+
+func bindControl(string) (func(network, address string, c syscall.RawConn) error, error) {
+	return nil, errors.New("direct: binding to an interface is only supported on Linux")
+}
@@ -8,6 +8,7 @@ import (
 	"net"
 	"sync"
 	"sync/atomic"
+	"syscall"
 
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
@@ -18,22 +19,57 @@ const TransportName = "direct"
 
 // Direct is transport that connects directly to the destination.
 // Each call to Proxy is fully self-contained — no mutable state is stored.
-type Direct struct{}
+type Direct struct {
+	// control binds outgoing sockets to an interface, nil for the default
+	// route.
+	control func(network, address string, c syscall.RawConn) error
+}
 
 // Compile-time guarantee that direct egress can carry UDP, as claimed by
 // router's Egress.SupportsUDP for EgressDirect.
 var _ transport.PacketTargetDialer = (*Direct)(nil)
 
+var _ transport.Binder = (*Direct)(nil)
+
 func New() transport.Transport {
 	return &Direct{}
 }
 
+// NewBound returns a Direct whose connections leave through the network
+// interface named iface, whatever the routing table says.
+func NewBound(iface string) (transport.Transport, error) {
+	if _, err := net.InterfaceByName(iface); err != nil {
+		return nil, fmt.Errorf("direct: %w", err)
+	}
+	control, err := bindControl(iface)
+	if err != nil {
+		return nil, err
+	}
+	return &Direct{control: control}, nil
+}
+
 func (d *Direct) String() string {
 	return TransportName
 }
 
 func (d *Direct) Dial(network, addr string) (net.Conn, error) {
-	return net.DialTimeout(transport.DialNetwork(network), addr, transport.GetDialTimeout())
+	dialer := net.Dialer{Timeout: transport.GetDialTimeout(), Control: d.controlSocket}
+	return dialer.Dial(transport.DialNetwork(network), addr)
+}
+
+// controlSocket runs on every address a dial tries, after name resolution,
+// so the installed guard sees where the socket really connects and a name
+// rebound to a guarded address is refused.
+func (d *Direct) controlSocket(network, address string, c syscall.RawConn) error {
+	if g := transport.GetGuard(); g != nil {
+		if err := g.CheckAddress(address); err != nil {
+			return err
+		}
+	}
+	if d.control != nil {
+		return d.control(network, address, c)
+	}
+	return nil
 }
 
 // connectedPacketConn adapts a connected *net.UDPConn to net.PacketConn.
@@ -102,11 +138,12 @@ func (d *Direct) DialPacketTarget(network, addr string) (net.PacketConn, net.Add
 		return nil, nil, fmt.Errorf("direct: IPv6 disabled, cannot dial packet target %s", addr)
 	}
 
-	conn, err := net.DialUDP(dialNetwork, nil, raddr)
+	dialer := net.Dialer{Control: d.controlSocket}
+	conn, err := dialer.Dial(dialNetwork, raddr.String())
 	if err != nil {
 		return nil, nil, fmt.Errorf("direct: dial packet %s to %s: %w", dialNetwork, addr, err)
 	}
-	return &connectedPacketConn{UDPConn: conn, remote: raddr}, raddr, nil
+	return &connectedPacketConn{UDPConn: conn.(*net.UDPConn), remote: raddr}, raddr, nil
 }
 
 func (d *Direct) Close() error {
@@ -124,6 +161,35 @@ func (d *Direct) Proxy(ctx context.Context, addr string, localAddr chan<- string
 		return err
 	}
 	localAddr <- conn.LocalAddr().String()
+	return relay(ctx, conn, dst, src)
+}
+
+// Bind takes in a connection from addr and relays it like Proxy relays a
+// dialed one.
+func (d *Direct) Bind(ctx context.Context, addr string, bound chan<- string, dst io.Writer, src io.Reader) error {
+	defer close(bound)
+
+	ln, boundAddr, err := transport.ListenBind(addr)
+	if err != nil {
+		return err
+	}
+	bound <- boundAddr
+	conn, err := transport.AcceptBind(ctx, ln, addr)
+	if err != nil {
+		return err
+	}
+	select {
+	case bound <- conn.RemoteAddr().String():
+	case <-ctx.Done():
+		utils.Close(conn)
+		return ctx.Err()
+	}
+	return relay(ctx, conn, dst, src)
+}
+
+// relay copies between the client and conn until either side is done, and
+// closes conn.
+func relay(ctx context.Context, conn net.Conn, dst io.Writer, src io.Reader) (err error) {
 	defer utils.Close(conn)
 
 	sessionCtx, cancel := context.WithCancel(ctx)
@@ -3,6 +3,8 @@ package direct
 import (
 	"bytes"
 	"context"
+	"errors"
+	"io"
 	"net"
 	"strings"
 	"testing"
@@ -253,3 +255,75 @@ func TestDirect_DialPacketWriteToIgnoresAddr(t *testing.T) {
 		t.Error("datagram reached the address passed to WriteTo; a connected socket must ignore it")
 	}
 }
+
+func TestDirect_Bind(t *testing.T) {
+	d := New().(transport.Binder)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bound := make(chan string, 2)
+	clientSide, proxySide := net.Pipe()
+	defer clientSide.Close()
+	errCh := make(chan error, 1)
+	go func() { errCh <- d.Bind(ctx, "127.0.0.1:0", bound, proxySide, proxySide) }()
+
+	addr := <-bound
+	peer, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial bound address %s: %v", addr, err)
+	}
+	defer peer.Close()
+	if got, want := <-bound, peer.LocalAddr().String(); got != want {
+		t.Fatalf("peer address = %s, want %s", got, want)
+	}
+
+	if _, err := peer.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(clientSide, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("client read %q, %v", buf, err)
+	}
+	peer.Close()
+	if err := <-errCh; err != nil {
+		t.Fatalf("Bind() error = %v", err)
+	}
+	if _, ok := <-bound; ok {
+		t.Fatal("bound not closed after Bind returned")
+	}
+}
+
+func TestDirect_Guard(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	g, err := transport.NewGuard(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport.SetGuard(g)
+	t.Cleanup(func() { transport.SetGuard(nil) })
+
+	d := New().(*Direct)
+	// a name is checked on the address it resolves to
+	if _, err = d.Dial("tcp", net.JoinHostPort("localhost", port)); !errors.Is(err, transport.ErrGuarded) {
+		t.Fatalf("Dial() error = %v, want ErrGuarded", err)
+	}
+	if _, _, err = d.DialPacketTarget("udp", "127.0.0.1:9"); !errors.Is(err, transport.ErrGuarded) {
+		t.Fatalf("DialPacketTarget() error = %v, want ErrGuarded", err)
+	}
+
+	if g, err = transport.NewGuard([]string{"127.0.0.0/8", "::1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	transport.SetGuard(g)
+	conn, err := d.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() to an allowed address error = %v", err)
+	}
+	conn.Close()
+}
@@ -12,4 +12,5 @@ var (
 	ErrInvalidMessage       = errors.New("invalid message")
 	ErrInvalidPayload       = errors.New("invalid payload")
 	ErrProxyHandshakeFailed = errors.New("proxy handshake failed")
+	ErrGuarded              = errors.New("destination guarded")
 )
@@ -33,6 +33,11 @@ func New() transport.Transport {
 	return &Forward{dialer: dialer}
 }
 
+// NewDialer returns a Forward through d rather than the attached dialer.
+func NewDialer(d proxy.Dialer) transport.Transport {
+	return &Forward{dialer: d}
+}
+
 func (f *Forward) Attach(dialer proxy.Dialer) {
 	f.dialer = dialer
 }
@@ -0,0 +1,137 @@
+package transport
+
+import (
+	"fmt"
+	"net/netip"
+	"sync/atomic"
+)
+
+// GuardedPrefixes are the destinations a Guard denies unless allowed: the
+// host itself, private and shared networks, link-local addresses and the
+// cloud metadata endpoints living in them (169.254.169.254, 100.100.100.200,
+// fd00:ec2::254), benchmarking, multicast and reserved ranges, and the
+// local-use NAT64 prefix, whose embedding of IPv4 is up to the network.
+var GuardedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("10.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("127.0.0.0/8"),
+	netip.MustParsePrefix("169.254.0.0/16"),
+	netip.MustParsePrefix("172.16.0.0/12"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("192.168.0.0/16"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("224.0.0.0/4"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("::/128"),
+	netip.MustParsePrefix("::1/128"),
+	netip.MustParsePrefix("64:ff9b:1::/48"),
+	netip.MustParsePrefix("fc00::/7"),
+	netip.MustParsePrefix("fe80::/10"),
+}
+
+// Addresses of the well-known NAT64 prefix and of 6to4 reach the IPv4
+// address they embed, and are judged as that address.
+var (
+	nat64Prefix = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFour   = netip.MustParsePrefix("2002::/16")
+)
+
+// embeddedIPv4 returns the IPv4 address addr is translated to, if any.
+func embeddedIPv4(addr netip.Addr) (netip.Addr, bool) {
+	b := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[12:16])), true
+	case sixToFour.Contains(addr):
+		return netip.AddrFrom4([4]byte(b[2:6])), true
+	}
+	return netip.Addr{}, false
+}
+
+// Guard keeps dialed connections off destinations that clients of the server
+// should not reach. It judges resolved addresses, so a name resolving to a
+// guarded address is caught however it was obtained.
+type Guard struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+var guard atomic.Pointer[Guard]
+
+// NewGuard returns a Guard denying GuardedPrefixes and deny, except for the
+// addresses in allow. Each entry is a CIDR prefix or a single address.
+func NewGuard(allow, deny []string) (*Guard, error) {
+	g := &Guard{deny: append([]netip.Prefix(nil), GuardedPrefixes...)}
+	var err error
+	if g.allow, err = parsePrefixes(allow); err != nil {
+		return nil, fmt.Errorf("guard allow: %w", err)
+	}
+	extra, err := parsePrefixes(deny)
+	if err != nil {
+		return nil, fmt.Errorf("guard deny: %w", err)
+	}
+	g.deny = append(g.deny, extra...)
+	return g, nil
+}
+
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(s)
+			if addrErr != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Check returns an error wrapping ErrGuarded if addr must not be dialed.
+func (g *Guard) Check(addr netip.Addr) error {
+	// IPv4-mapped addresses reach the IPv4 host, and zones do not change the
+	// address dialed
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range g.allow {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	if v4, ok := embeddedIPv4(addr); ok {
+		if err := g.Check(v4); err != nil {
+			return fmt.Errorf("%w via %s", err, addr)
+		}
+	}
+	for _, prefix := range g.deny {
+		if prefix.Contains(addr) {
+			return fmt.Errorf("%w: %s", ErrGuarded, addr)
+		}
+	}
+	return nil
+}
+
+// CheckAddress is Check for a resolved "ip:port" as handed to socket
+// controls. Addresses that are not literal IPs pass.
+func (g *Guard) CheckAddress(address string) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return nil
+	}
+	return g.Check(addrPort.Addr())
+}
+
+// SetGuard installs the Guard direct dialing is checked against, nil to
+// dial anywhere.
+func SetGuard(g *Guard) {
+	guard.Store(g)
+}
+
+// GetGuard returns the installed Guard, nil if none.
+func GetGuard() *Guard {
+	return guard.Load()
+}
@@ -0,0 +1,113 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestGuard_Check(t *testing.T) {
+	g, err := NewGuard([]string{"10.1.0.0/16", "::1"}, []string{"203.0.113.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		addr    string
+		guarded bool
+	}{
+		{"127.0.0.1", true},
+		{"0.0.0.0", true},
+		{"10.0.0.1", true},
+		{"172.20.1.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.100.100.200", true},
+		{"::", true},
+		{"fe80::1%eth0", true},
+		{"fd00:ec2::254", true},
+		{"::ffff:127.0.0.1", true},
+		{"203.0.113.7", true},
+		{"198.18.0.1", true},
+		{"224.0.0.251", true},
+		{"255.255.255.255", true},
+		{"64:ff9b::a9fe:a9fe", true},
+		{"64:ff9b::7f00:1", true},
+		{"64:ff9b:1::808:808", true},
+		{"2002:c0a8:101::1", true},
+		{"64:ff9b::808:808", false},
+		{"64:ff9b::a01:203", false},
+		{"2002:808:808::1", false},
+		{"10.1.2.3", false},
+		{"::1", false},
+		{"8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+	} {
+		err := g.Check(netip.MustParseAddr(tc.addr))
+		if got := errors.Is(err, ErrGuarded); got != tc.guarded {
+			t.Errorf("Check(%s) = %v, want guarded %t", tc.addr, err, tc.guarded)
+		}
+	}
+}
+
+func TestGuard_CheckAddress(t *testing.T) {
+	g, err := NewGuard(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = g.CheckAddress("[::ffff:192.168.0.1]:80"); !errors.Is(err, ErrGuarded) {
+		t.Errorf("CheckAddress() = %v, want ErrGuarded", err)
+	}
+	if err = g.CheckAddress("1.1.1.1:443"); err != nil {
+		t.Errorf("CheckAddress() = %v, want nil", err)
+	}
+}
+
+func TestGuard_Bind(t *testing.T) {
+	g, err := NewGuard(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetGuard(g)
+	t.Cleanup(func() { SetGuard(nil) })
+
+	if _, _, err = ListenBind("127.0.0.1:0"); !errors.Is(err, ErrGuarded) {
+		t.Fatalf("ListenBind(127.0.0.1:0) = %v, want ErrGuarded", err)
+	}
+
+	// a name admits any peer but a guarded one
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := AcceptBind(ctx, ln, "peer.example:0")
+		errCh <- err
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("connection from a guarded peer was not closed")
+	}
+	cancel()
+	if err := <-errCh; err == nil {
+		t.Fatal("AcceptBind() accepted a guarded peer")
+	}
+}
+
+func TestNewGuard_InvalidPrefix(t *testing.T) {
+	if _, err := NewGuard([]string{"10.0.0.0/33"}, nil); err == nil {
+		t.Error("NewGuard() accepted an invalid allow prefix")
+	}
+	if _, err := NewGuard(nil, []string{"example.com"}); err == nil {
+		t.Error("NewGuard() accepted an invalid deny entry")
+	}
+}
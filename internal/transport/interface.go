@@ -29,3 +29,13 @@ type PacketTargetDialer interface {
 	PacketDialer
 	DialPacketTarget(network, addr string) (net.PacketConn, net.Addr, error)
 }
+
+// Binder is an optional interface implemented by transports that can take a
+// connection in on the client's behalf, as SOCKS5 BIND asks for.
+type Binder interface {
+	// Bind listens for a connection from addr, the peer the client expects.
+	// bound receives the address listened on, then the address of the peer
+	// once it connected, and is closed when Bind returns; the connection is
+	// then relayed like Proxy relays a dialed one.
+	Bind(ctx context.Context, addr string, bound chan<- string, dst io.Writer, src io.Reader) error
+}
@@ -20,7 +20,6 @@ import (
 	rpcutils "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/utils"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
 	"github.com/miekg/dns"
-	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -28,9 +27,9 @@ import (
 const TransportName = "rpc"
 
 var (
-	clientMu sync.RWMutex
-	uuidVal  string
-	queueVal *ConnQueue
+	clientMu     sync.RWMutex
+	uuidVal      string
+	upstreamsVal *upstreams
 )
 
 type Client struct {
@@ -43,6 +42,8 @@ type Client struct {
 // one cheaply, so the assertion lives here.
 var _ transport.PacketDialer = (*Client)(nil)
 
+var _ transport.Binder = (*Client)(nil)
+
 func SetUUID(uid string) {
 	clientMu.Lock()
 	uuidVal = uid
@@ -55,10 +56,10 @@ func getUUID() string {
 	return uuidVal
 }
 
-func getQueue() *ConnQueue {
+func getUpstreams() *upstreams {
 	clientMu.RLock()
 	defer clientMu.RUnlock()
-	return queueVal
+	return upstreamsVal
 }
 
 func setupGrpcCredential(tls bool, hostName string, customCA ...string) (credentials.TransportCredentials, error) {
@@ -118,74 +119,91 @@ func buildClientTLSConfig(cp *x509.CertPool, serverNameOverride string) (*tls.Co
 	return tlsConfig, nil
 }
 
+// Init connects the pool to a single server.
 func Init(server, hostName string, tls bool, mux uint8, cas []string) error {
-	credential, err := setupGrpcCredential(tls, hostName, cas...)
-	if err != nil {
-		return fmt.Errorf("setup grpc credential failed: %w", err)
-	}
+	return InitUpstreams([]Upstream{{Addr: server, Host: hostName}}, BalanceFailover, tls, mux, cas)
+}
 
-	params := NewParams(server, append(rpc.DialOptions(),
-		grpc.WithTransportCredentials(credential),
-		grpc.WithIdleTimeout(transport.GetIdleTimeout()),
-		grpc.WithUnaryInterceptor(rpc.UnaryClientAuthInterceptor(getUUID)),
-		grpc.WithStreamInterceptor(rpc.StreamClientAuthInterceptor(getUUID)),
-	)...)
-	q := NewConnQueue(int(mux), params)
-	if err := q.Init(); err != nil {
+// InitUpstreams connects a pool to each server. New sessions are spread by
+// balance across the healthy ones of the highest priority; health is probed
+// only when there is more than one.
+func InitUpstreams(list []Upstream, balance Balance, tls bool, mux uint8, cas []string) error {
+	us, err := newUpstreams(list, balance, getUUID, tls, mux, cas)
+	if err != nil {
 		return err
 	}
 
 	clientMu.Lock()
-	queueVal = q
+	upstreamsVal = us
 	clientMu.Unlock()
 	return nil
 }
 
 func Destroy() {
 	clientMu.Lock()
-	q := queueVal
-	queueVal = nil
+	us := upstreamsVal
+	upstreamsVal = nil
 	clientMu.Unlock()
-	if q != nil {
-		q.Destroy()
+	if us != nil {
+		us.destroy()
 	}
 }
 
 // GetConnectionStatus returns the current connection pool status
 func GetConnectionStatus() string {
-	q := getQueue()
-	if q == nil {
+	us := getUpstreams()
+	if us == nil {
 		return "Connection pool not initialized"
 	}
-	return q.GetConnectionStatus()
+	return us.status()
 }
 
 // GetConnectionSummary returns connection pool statistics
 func GetConnectionSummary() (total, active int, currentLoad uint32) {
-	q := getQueue()
-	if q == nil {
+	us := getUpstreams()
+	if us == nil {
 		return 0, 0, 0
 	}
-	return q.GetConnectionSummary()
+	for _, u := range us.list {
+		t, a, l := u.queue.GetConnectionSummary()
+		total, active, currentLoad = total+t, active+a, currentLoad+l
+	}
+	return total, active, currentLoad
 }
 
 // LogConnectionStatus logs detailed connection status
 func LogConnectionStatus() {
-	q := getQueue()
-	if q == nil {
+	us := getUpstreams()
+	if us == nil {
 		log.Println("gRPC connection queue is not initialized")
 		return
 	}
-	q.LogConnectionStatus()
+	for _, u := range us.list {
+		u.queue.LogConnectionStatus()
+	}
 }
 
 // GetConnectionDetails returns individual connection information for web display
 func GetConnectionDetails() []ConnectionDetail {
-	q := getQueue()
-	if q == nil {
+	us := getUpstreams()
+	if us == nil {
 		return nil
 	}
-	return q.GetConnectionDetails()
+	var details []ConnectionDetail
+	for _, u := range us.list {
+		details = append(details, u.queue.GetConnectionDetails()...)
+	}
+	return details
+}
+
+// GetUpstreamDetails returns the health of each upstream server, the active
+// one marked, for web display
+func GetUpstreamDetails() []UpstreamDetail {
+	us := getUpstreams()
+	if us == nil {
+		return nil
+	}
+	return us.details()
 }
 
 // Example usage and explanation of connection status display:
@@ -203,17 +221,57 @@ func (c *Client) Dial(_, _ string) (net.Conn, error) {
 }
 
 func New() (*Client, error) {
-	q := getQueue()
-	if q == nil {
+	return NewFor("")
+}
+
+// NewFor is New for a session to host, which the consistent_hash balance
+// keys on.
+func NewFor(host string) (*Client, error) {
+	us := getUpstreams()
+	if us == nil {
 		return nil, fmt.Errorf("connection pool not initialized")
 	}
-	client, doneFunc, err := q.GetClient()
+	return us.newClient(host)
+}
+
+func (us *upstreams) newClient(host string) (*Client, error) {
+	client, doneFunc, err := us.pick(host).queue.GetClient()
 	if err != nil {
 		return nil, err
 	}
 	return &Client{ProxyClient: client, DoneFunc: doneFunc}, nil
 }
 
+// Group is a set of upstreams apart from the one Init connects, which routes
+// name as an outbound.
+type Group struct {
+	us *upstreams
+}
+
+// NewGroup connects a pool to each server like InitUpstreams. An empty uuid
+// authenticates as the one SetUUID set.
+func NewGroup(list []Upstream, balance Balance, uuid string, tls bool, mux uint8, cas []string) (*Group, error) {
+	uuidFunc := getUUID
+	if uuid != "" {
+		uuidFunc = func() string { return uuid }
+	}
+	us, err := newUpstreams(list, balance, uuidFunc, tls, mux, cas)
+	if err != nil {
+		return nil, err
+	}
+	return &Group{us: us}, nil
+}
+
+// New is the package New for the upstreams of g.
+func (g *Group) New(host string) (*Client, error) {
+	return g.us.newClient(host)
+}
+
+// Destroy closes the pools of g.
+func (g *Group) Destroy() {
+	g.us.destroy()
+}
+
 func (c *Client) String() string {
 	return TransportName
 }
@@ -256,6 +314,16 @@ func (c *Client) DialPacket(network, addr string) (net.PacketConn, error) {
 }
 
 func (c *Client) Proxy(ctx context.Context, addr string, localAddr chan<- string, w io.Writer, r io.Reader) error {
+	return c.proxy(ctx, addr, proto.Mode_CONNECT, localAddr, w, r)
+}
+
+// Bind asks the server to take a connection in from addr. bound receives the
+// address the server listens on, then the address of the peer.
+func (c *Client) Bind(ctx context.Context, addr string, bound chan<- string, w io.Writer, r io.Reader) error {
+	return c.proxy(ctx, addr, proto.Mode_BIND, bound, w, r)
+}
+
+func (c *Client) proxy(ctx context.Context, addr string, mode proto.Mode, localAddr chan<- string, w io.Writer, r io.Reader) error {
 	defer close(localAddr)
 
 	sessionCtx, cancel := context.WithCancel(ctx)
@@ -271,6 +339,7 @@ func (c *Client) Proxy(ctx context.Context, addr string, localAddr chan<- string
 
 	//log.Printf("sending proto to rpc: %s", req.Host)
 	f := NewForwarder(sessionCtx, cancel, stream, w, r)
+	f.mode = mode
 	if err = f.Start(addr, localAddr); err != nil && !errors.Is(err, context.Canceled) {
 		// Pass the forwarder error through; outer layers (http/socks) add the
 		// front-end context. Avoid "rpc client: proto failed: rpc: …" chains.
@@ -287,11 +356,31 @@ type DnsRequest struct {
 	Fqdn      string
 	QType     uint16
 	BlockIPv6 bool
+	// EDNS is the OPT record of the client query, forwarded to the upstream.
+	EDNS *dns.OPT
+}
+
+// DnsAnswer merges the results of a DnsExchange.
+type DnsAnswer struct {
+	Records []dns.RR
+	// Rcode is the first response code that is not NOERROR, extended codes
+	// included.
+	Rcode int
+	// EDNS is the OPT record of the first upstream response that had one.
+	EDNS *dns.OPT
 }
 
+// DnsResolve resolves the requests through the server, see DnsExchange.
 func (c *Client) DnsResolve(ctx context.Context, requests []*DnsRequest) ([]dns.RR, int, error) {
+	answer, err := c.DnsExchange(ctx, requests)
+	return answer.Records, answer.Rcode, err
+}
+
+// DnsExchange resolves the requests through the server. On error the answer
+// only carries the response code to reply with.
+func (c *Client) DnsExchange(ctx context.Context, requests []*DnsRequest) (DnsAnswer, error) {
 	if len(requests) == 0 {
-		return nil, dns.RcodeSuccess, nil
+		return DnsAnswer{Rcode: dns.RcodeSuccess}, nil
 	}
 
 	// Create gRPC request
@@ -299,44 +388,60 @@ func (c *Client) DnsResolve(ctx context.Context, requests []*DnsRequest) ([]dns.
 
 	for i, request := range requests {
 		if request == nil {
-			return nil, dns.RcodeFormatError, fmt.Errorf("dns request %d is nil", i)
+			return DnsAnswer{Rcode: dns.RcodeFormatError}, fmt.Errorf("dns request %d is nil", i)
 		}
 		dnsReq := &proto.DnsRequestItem{
 			Fqdn:      request.Fqdn,
 			QType:     uint32(request.QType),
 			BlockIpv6: request.BlockIPv6,
 		}
+		if request.EDNS != nil {
+			opt, err := rpcutils.ConvertRRToProto(request.EDNS)
+			if err != nil {
+				return DnsAnswer{Rcode: dns.RcodeFormatError}, fmt.Errorf("dns: convert edns for %s: %w", request.Fqdn, err)
+			}
+			dnsReq.Edns = opt
+		}
 		req.Items = append(req.Items, dnsReq)
 	}
 
 	// Make gRPC call
 	resp, err := c.ProxyClient.DnsResolve(ctx, req)
 	if err != nil {
-		return nil, dns.RcodeServerFailure, fmt.Errorf("rpc dns: %w", err)
+		return DnsAnswer{Rcode: dns.RcodeServerFailure}, fmt.Errorf("rpc dns: %w", err)
 	}
 	return decodeDNSResponse(resp)
 }
 
-func decodeDNSResponse(resp *proto.DnsResponse) ([]dns.RR, int, error) {
+func decodeDNSResponse(resp *proto.DnsResponse) (DnsAnswer, error) {
 	if resp == nil {
-		return nil, dns.RcodeServerFailure, errors.New("gRPC DNS resolve returned a nil response")
+		return DnsAnswer{Rcode: dns.RcodeServerFailure}, errors.New("gRPC DNS resolve returned a nil response")
 	}
 
 	// Process results
-	results := make([]dns.RR, 0, len(resp.Result))
-	rcode := dns.RcodeSuccess
+	answer := DnsAnswer{
+		Records: make([]dns.RR, 0, len(resp.Result)),
+		Rcode:   dns.RcodeSuccess,
+	}
 
 	for _, item := range resp.Result {
 		if item == nil {
 			continue
 		}
-		// This proxy transports the base DNS header but not an EDNS OPT record,
-		// so only the header's four-bit RCODE can be represented safely.
-		if item.Rcode > 0xF {
-			return nil, dns.RcodeServerFailure, fmt.Errorf("dns: invalid response code %d for %s", item.Rcode, item.Fqdn)
+		opt, err := rpcutils.ConvertProtoToOPT(item.Edns)
+		if err != nil {
+			return DnsAnswer{Rcode: dns.RcodeServerFailure}, fmt.Errorf("dns: convert edns for %s: %w", item.Fqdn, err)
+		}
+		// The header holds four bits of the RCODE; the upper eight travel in
+		// the OPT record, so extended codes are only valid along with one.
+		if item.Rcode > 0xF && (opt == nil || item.Rcode > 0xFFF) {
+			return DnsAnswer{Rcode: dns.RcodeServerFailure}, fmt.Errorf("dns: invalid response code %d for %s", item.Rcode, item.Fqdn)
+		}
+		if answer.Rcode == dns.RcodeSuccess && item.Rcode != dns.RcodeSuccess {
+			answer.Rcode = int(item.Rcode)
 		}
-		if rcode == dns.RcodeSuccess && item.Rcode != dns.RcodeSuccess {
-			rcode = int(item.Rcode)
+		if answer.EDNS == nil {
+			answer.EDNS = opt
 		}
 		// Convert protobuf records back to DNS RR records using the new format
 		if len(item.Records) == 0 {
@@ -347,12 +452,12 @@ func decodeDNSResponse(resp *proto.DnsResponse) ([]dns.RR, int, error) {
 		// Use new complete record format
 		records, err := rpcutils.ConvertProtoToRRSlice(item.Records)
 		if err != nil {
-			return nil, dns.RcodeServerFailure, fmt.Errorf("dns: convert records for %s: %w", item.Fqdn, err)
+			return DnsAnswer{Rcode: dns.RcodeServerFailure}, fmt.Errorf("dns: convert records for %s: %w", item.Fqdn, err)
 		}
 		log.Printf("dns: resolved %s with %d records", item.Fqdn, len(records))
 
-		results = append(results, records...)
+		answer.Records = append(answer.Records, records...)
 	}
 
-	return results, rcode, nil
+	return answer, nil
 }
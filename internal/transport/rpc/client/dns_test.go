@@ -34,30 +34,30 @@ func TestDecodeDNSResponsePreservesRcodeAndRecords(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	records, rcode, err := decodeDNSResponse(&proto.DnsResponse{Result: []*proto.DnsResult{
+	answer, err := decodeDNSResponse(&proto.DnsResponse{Result: []*proto.DnsResult{
 		{Fqdn: "example.com.", Records: protoRecords},
 		{Fqdn: "missing.example.", Rcode: dns.RcodeNameError},
 	}})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if rcode != dns.RcodeNameError {
-		t.Fatalf("rcode = %d, want NXDOMAIN", rcode)
+	if answer.Rcode != dns.RcodeNameError {
+		t.Fatalf("rcode = %d, want NXDOMAIN", answer.Rcode)
 	}
-	if len(records) != 1 || records[0].String() != record.String() {
-		t.Fatalf("records = %v, want %v", records, record)
+	if len(answer.Records) != 1 || answer.Records[0].String() != record.String() {
+		t.Fatalf("records = %v, want %v", answer.Records, record)
 	}
 }
 
 func TestDecodeDNSResponseRejectsMalformedRecord(t *testing.T) {
-	_, rcode, err := decodeDNSResponse(&proto.DnsResponse{Result: []*proto.DnsResult{
+	answer, err := decodeDNSResponse(&proto.DnsResponse{Result: []*proto.DnsResult{
 		{Fqdn: "bad.example.", Records: []*proto.RR_Record{{WireData: []byte{0xff}}}},
 	}})
 	if err == nil {
 		t.Fatal("decodeDNSResponse() accepted malformed wire data")
 	}
-	if rcode != dns.RcodeServerFailure {
-		t.Fatalf("rcode = %d, want SERVFAIL", rcode)
+	if answer.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("rcode = %d, want SERVFAIL", answer.Rcode)
 	}
 }
 
@@ -73,14 +73,66 @@ func TestDnsResolveRejectsNilRequest(t *testing.T) {
 }
 
 func TestDecodeDNSResponseRejectsExtendedRcodeWithoutEDNS(t *testing.T) {
-	_, rcode, err := decodeDNSResponse(&proto.DnsResponse{Result: []*proto.DnsResult{
+	answer, err := decodeDNSResponse(&proto.DnsResponse{Result: []*proto.DnsResult{
 		{Fqdn: "example.com.", Rcode: 16},
 	}})
 	if err == nil {
 		t.Fatal("decodeDNSResponse() accepted an extended RCODE without EDNS")
 	}
-	if rcode != dns.RcodeServerFailure {
-		t.Fatalf("rcode = %d, want SERVFAIL", rcode)
+	if answer.Rcode != dns.RcodeServerFailure {
+		t.Fatalf("rcode = %d, want SERVFAIL", answer.Rcode)
+	}
+}
+
+func TestDecodeDNSResponseAcceptsExtendedRcodeWithEDNS(t *testing.T) {
+	opt := new(dns.OPT)
+	opt.Hdr = dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}
+	opt.SetUDPSize(1232)
+	opt.SetExtendedRcode(dns.RcodeBadVers)
+	record, err := rpcutils.ConvertRRToProto(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	answer, err := decodeDNSResponse(&proto.DnsResponse{Result: []*proto.DnsResult{
+		{Fqdn: "example.com.", Rcode: dns.RcodeBadVers, Edns: record},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Rcode != dns.RcodeBadVers {
+		t.Fatalf("rcode = %d, want BADVERS", answer.Rcode)
+	}
+	if answer.EDNS == nil || answer.EDNS.UDPSize() != 1232 {
+		t.Fatalf("EDNS = %v, want the upstream OPT record", answer.EDNS)
+	}
+}
+
+func TestDnsExchangeForwardsEDNS(t *testing.T) {
+	proxyClient := &dnsProxyClient{response: &proto.DnsResponse{Result: []*proto.DnsResult{
+		{Fqdn: "example.com."},
+	}}}
+	client := &Client{ProxyClient: proxyClient}
+
+	opt := new(dns.OPT)
+	opt.Hdr = dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}
+	opt.SetUDPSize(4096)
+	opt.SetDo()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("198.51.100.0").To4(),
+	})
+	if _, err := client.DnsExchange(context.Background(), []*DnsRequest{
+		{Fqdn: "example.com.", QType: dns.TypeA, EDNS: opt},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := rpcutils.ConvertProtoToOPT(proxyClient.request.Items[0].Edns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.String() != opt.String() {
+		t.Fatalf("forwarded EDNS = %v, want %v", got, opt)
 	}
 }
 
@@ -51,6 +51,9 @@ type Forwarder struct {
 	reader        io.Reader
 	localAddr     chan string
 	closeAddrOnce sync.Once
+	// mode tells the server to dial the target or to take a connection in
+	// from it; a bind gets two Accepted headers.
+	mode proxy.Mode
 
 	// Statistic for TX and RX
 	Statistic *Statistic
@@ -230,6 +233,7 @@ func (f *Forwarder) Start(addr string, localAddrChan chan<- string) error {
 		HeaderOrPayload: &proxy.ProxySRC_Header{
 			Header: &proxy.ProxySRC_ProxyHeader{
 				Addr: addr,
+				Mode: f.mode,
 			},
 		},
 	}
@@ -262,35 +266,15 @@ func (f *Forwarder) Start(addr string, localAddrChan chan<- string) error {
 
 	// ack timeout
 	errGroup.Go(func() error {
-		t := time.NewTimer(rpc.GeneralTimeout)
-		defer t.Stop()
-
-		select {
-		case <-ctx.Done():
-			// A sibling goroutine already failed the session — an auth rejection,
-			// an unreachable server, a refused target. Without this case the ack
-			// timer would still run to completion, so every fast failure would
-			// cost the caller the full timeout before it saw the real error.
-			return ctx.Err()
-		case <-t.C:
-			// Do not wrap os.ErrDeadlineExceeded: its Error() is "i/o timeout",
-			// which reads as a socket I/O failure rather than a missing server ack.
-			// Omit the target here — HTTP/SOCKS log the host once on the outer line.
-			return errServerAckTimeout
-		case localAddr, ok := <-f.localAddr:
-			if !ok {
-				return errServerRejected
-			}
-			select {
-			case localAddrChan <- localAddr:
-			case <-ctx.Done():
-				return ctx.Err()
-			}
-			// done
-			//log.Printf("rpc: server -> %s -> %s success", req.Host, localAddr)
+		if err := f.waitAccepted(ctx, rpc.GeneralTimeout, localAddrChan); err != nil {
+			return err
 		}
-
-		return nil
+		if f.mode != proxy.Mode_BIND {
+			return nil
+		}
+		// the server reports the peer once it connected, or gives up after
+		// the bind timeout
+		return f.waitAccepted(ctx, transport.BindTimeout+rpc.GeneralTimeout, localAddrChan)
 	})
 
 	if err := errGroup.Wait(); err != io.EOF {
@@ -299,6 +283,39 @@ func (f *Forwarder) Start(addr string, localAddrChan chan<- string) error {
 	return nil
 }
 
+// waitAccepted passes the address of the next Accepted header on to
+// localAddrChan, failing if none arrives within timeout.
+func (f *Forwarder) waitAccepted(ctx context.Context, timeout time.Duration, localAddrChan chan<- string) error {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		// A sibling goroutine already failed the session — an auth rejection,
+		// an unreachable server, a refused target. Without this case the ack
+		// timer would still run to completion, so every fast failure would
+		// cost the caller the full timeout before it saw the real error.
+		return ctx.Err()
+	case <-t.C:
+		// Do not wrap os.ErrDeadlineExceeded: its Error() is "i/o timeout",
+		// which reads as a socket I/O failure rather than a missing server ack.
+		// Omit the target here — HTTP/SOCKS log the host once on the outer line.
+		return errServerAckTimeout
+	case localAddr, ok := <-f.localAddr:
+		if !ok {
+			return errServerRejected
+		}
+		select {
+		case localAddrChan <- localAddr:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		// done
+		//log.Printf("rpc: server -> %s -> %s success", req.Host, localAddr)
+	}
+	return nil
+}
+
 func (f *Forwarder) addTx(n int) {
 	if n <= 0 {
 		return
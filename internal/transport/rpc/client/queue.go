@@ -213,5 +213,9 @@ func (q *ConnQueue) GetConnectionDetails() []ConnectionDetail {
 		return nil
 	}
 
-	return q.Conn.GetConnectionDetails()
+	details := q.Conn.GetConnectionDetails()
+	for i := range details {
+		details[i].Upstream = q.Params.Addr
+	}
+	return details
 }
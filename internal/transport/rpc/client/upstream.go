@@ -0,0 +1,363 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc"
+	proto "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	probeInterval = 15 * time.Second
+	probeTimeout  = 5 * time.Second
+	// failThreshold is how many probes in a row must fail before traffic
+	// moves off an upstream, so one lost probe does not cause a switch.
+	failThreshold = 2
+)
+
+// Balance is how new sessions are spread across upstreams.
+type Balance string
+
+const (
+	// BalanceFailover sends every session to the healthy upstream of the
+	// highest priority, listed first on a tie.
+	BalanceFailover Balance = "failover"
+	// The others spread sessions across the healthy upstreams sharing the
+	// highest priority.
+	BalanceRoundRobin     Balance = "round_robin"
+	BalanceLeastStreams   Balance = "least_streams"
+	BalanceLowestRTT      Balance = "lowest_rtt"
+	BalanceConsistentHash Balance = "consistent_hash" // by destination host
+)
+
+// ParseBalance checks s names a strategy, failover when empty.
+func ParseBalance(s string) (Balance, error) {
+	switch b := Balance(s); b {
+	case "":
+		return BalanceFailover, nil
+	case BalanceFailover, BalanceRoundRobin, BalanceLeastStreams, BalanceLowestRTT, BalanceConsistentHash:
+		return b, nil
+	}
+	return "", fmt.Errorf("unknown balance strategy %q", s)
+}
+
+// Upstream is a server the client can tunnel through.
+type Upstream struct {
+	Addr string
+	// Host overrides the TLS server name.
+	Host string
+	// Priority orders the upstreams: only the healthy ones with the lowest
+	// value carry new sessions, the others stand by.
+	Priority int
+}
+
+// UpstreamDetail holds upstream information for web display.
+type UpstreamDetail struct {
+	Addr      string  `json:"addr"`
+	Priority  int     `json:"priority"`
+	Healthy   bool    `json:"healthy"`
+	Active    bool    `json:"active"`     // carries new sessions
+	LatencyMs float64 `json:"latency_ms"` // round trip of the last probe
+	LastError string  `json:"last_error,omitempty"`
+}
+
+type upstream struct {
+	Upstream
+	queue *ConnQueue
+
+	mu       sync.Mutex
+	healthy  bool
+	failures int
+	latency  time.Duration
+	lastErr  error
+}
+
+// probe sends an empty DnsResolve, which servers answer without resolving
+// anything. Servers predating that reject it as a bad request, which proves
+// the upstream up and the user accepted just as well.
+func (u *upstream) probe(ctx context.Context) error {
+	conn, done, err := u.queue.GetConn()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = done() }()
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	start := time.Now()
+	_, err = NewDynamicProxyClient(conn).DnsResolve(ctx, new(proto.DnsRequest))
+	if err != nil && status.Code(err) != codes.Unknown {
+		return err
+	}
+
+	u.mu.Lock()
+	u.latency = time.Since(start)
+	u.mu.Unlock()
+	return nil
+}
+
+// report records the outcome of a probe and returns whether the upstream
+// changed between healthy and down.
+func (u *upstream) report(err error) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.lastErr = err
+	if err == nil {
+		u.failures = 0
+		changed := !u.healthy
+		u.healthy = true
+		return changed
+	}
+	u.failures++
+	if u.healthy && u.failures >= failThreshold {
+		u.healthy = false
+		return true
+	}
+	return false
+}
+
+func (u *upstream) isHealthy() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.healthy
+}
+
+func (u *upstream) rtt() time.Duration {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.latency
+}
+
+// streams counts the sessions open on the pool.
+func (u *upstream) streams() uint32 {
+	_, _, load := u.queue.GetConnectionSummary()
+	return load
+}
+
+// upstreams fails over between servers ordered by priority.
+type upstreams struct {
+	list    []*upstream
+	balance Balance
+	active  atomic.Pointer[upstream]
+	next    atomic.Uint32 // round robin cursor
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newUpstreams(list []Upstream, balance Balance, uuid func() string, tls bool, mux uint8, cas []string) (*upstreams, error) {
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no upstream server configured")
+	}
+	us := &upstreams{balance: balance, done: make(chan struct{})}
+	for _, cfg := range list {
+		credential, err := setupGrpcCredential(tls, cfg.Host, cas...)
+		if err != nil {
+			us.destroy()
+			return nil, fmt.Errorf("setup grpc credential for %s failed: %w", cfg.Addr, err)
+		}
+		params := NewParams(cfg.Addr, append(rpc.DialOptions(),
+			grpc.WithTransportCredentials(credential),
+			grpc.WithIdleTimeout(transport.GetIdleTimeout()),
+			grpc.WithUnaryInterceptor(rpc.UnaryClientAuthInterceptor(uuid)),
+			grpc.WithStreamInterceptor(rpc.StreamClientAuthInterceptor(uuid)),
+		)...)
+		q := NewConnQueue(int(mux), params)
+		if err = q.Init(); err != nil {
+			us.destroy()
+			return nil, fmt.Errorf("init upstream %s failed: %w", cfg.Addr, err)
+		}
+		us.list = append(us.list, &upstream{Upstream: cfg, queue: q, healthy: true})
+	}
+	slices.SortStableFunc(us.list, func(a, b *upstream) int { return a.Priority - b.Priority })
+	us.active.Store(us.list[0])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	us.cancel = cancel
+	if len(us.list) == 1 {
+		// nowhere to fail over to
+		close(us.done)
+		return us, nil
+	}
+	go us.probeLoop(ctx)
+	return us, nil
+}
+
+func (us *upstreams) probeLoop(ctx context.Context) {
+	defer close(us.done)
+	ticker := time.NewTicker(probeInterval)
+	defer ticker.Stop()
+	for {
+		us.probeAll(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeAll probes every upstream at once and moves new sessions to the
+// healthy upstream of the highest priority.
+func (us *upstreams) probeAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, u := range us.list {
+		wg.Go(func() {
+			err := u.probe(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if u.report(err) {
+				if err != nil {
+					log.Printf("rpc: upstream %s down: %v", u.Addr, err)
+				} else {
+					log.Printf("rpc: upstream %s up", u.Addr)
+				}
+			}
+		})
+	}
+	wg.Wait()
+	us.selectActive()
+}
+
+// selectActive picks the first healthy upstream. With none healthy, the
+// active one stays: nothing would do better.
+func (us *upstreams) selectActive() {
+	for _, u := range us.list {
+		if !u.isHealthy() {
+			continue
+		}
+		if prev := us.active.Swap(u); prev != u {
+			log.Printf("rpc: switching upstream %s -> %s", prev.Addr, u.Addr)
+		}
+		return
+	}
+}
+
+// candidates returns the healthy upstreams sharing the highest priority, or
+// the active one when none is healthy.
+func (us *upstreams) candidates() []*upstream {
+	var candidates []*upstream
+	for _, u := range us.list {
+		if len(candidates) > 0 && u.Priority != candidates[0].Priority {
+			break
+		}
+		if u.isHealthy() {
+			candidates = append(candidates, u)
+		}
+	}
+	if len(candidates) == 0 {
+		return []*upstream{us.active.Load()}
+	}
+	return candidates
+}
+
+// pick returns the upstream a new session to host goes through.
+func (us *upstreams) pick(host string) *upstream {
+	if len(us.list) == 1 || us.balance == BalanceFailover || us.balance == "" {
+		return us.active.Load()
+	}
+	candidates := us.candidates()
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	switch us.balance {
+	case BalanceLeastStreams:
+		picked, least := candidates[0], candidates[0].streams()
+		for _, u := range candidates[1:] {
+			if streams := u.streams(); streams < least {
+				picked, least = u, streams
+			}
+		}
+		return picked
+	case BalanceLowestRTT:
+		picked, lowest := candidates[0], candidates[0].rtt()
+		for _, u := range candidates[1:] {
+			if rtt := u.rtt(); rtt < lowest {
+				picked, lowest = u, rtt
+			}
+		}
+		return picked
+	case BalanceConsistentHash:
+		if host != "" {
+			return rendezvous(candidates, host)
+		}
+	}
+	return candidates[(us.next.Add(1)-1)%uint32(len(candidates))]
+}
+
+// rendezvous picks the upstream scoring highest for host, so a host keeps
+// its upstream unless that one leaves the candidates.
+func rendezvous(candidates []*upstream, host string) *upstream {
+	var picked *upstream
+	var best uint64
+	for _, u := range candidates {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(u.Addr))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(host))
+		if score := h.Sum64(); picked == nil || score > best {
+			picked, best = u, score
+		}
+	}
+	return picked
+}
+
+func (us *upstreams) destroy() {
+	if us.cancel != nil {
+		us.cancel()
+		<-us.done
+	}
+	for _, u := range us.list {
+		u.queue.Destroy()
+	}
+}
+
+func (us *upstreams) details() []UpstreamDetail {
+	active := []*upstream{us.active.Load()}
+	if us.balance != BalanceFailover && us.balance != "" {
+		active = us.candidates()
+	}
+	details := make([]UpstreamDetail, len(us.list))
+	for i, u := range us.list {
+		u.mu.Lock()
+		details[i] = UpstreamDetail{
+			Addr:      u.Addr,
+			Priority:  u.Priority,
+			Healthy:   u.healthy,
+			Active:    slices.Contains(active, u),
+			LatencyMs: float64(u.latency.Microseconds()) / 1000,
+		}
+		if u.lastErr != nil {
+			details[i].LastError = u.lastErr.Error()
+		}
+		u.mu.Unlock()
+	}
+	return details
+}
+
+// status joins the connection status of each upstream, labelled by address
+// when there is more than one.
+func (us *upstreams) status() string {
+	if len(us.list) == 1 {
+		return us.list[0].queue.GetConnectionStatus()
+	}
+	parts := make([]string, len(us.list))
+	for i, u := range us.list {
+		parts[i] = u.Addr + ": " + u.queue.GetConnectionStatus()
+	}
+	return strings.Join(parts, ", ")
+}
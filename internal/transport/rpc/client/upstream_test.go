@@ -0,0 +1,190 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func newTestUpstreams(addrs ...string) *upstreams {
+	us := new(upstreams)
+	for i, addr := range addrs {
+		us.list = append(us.list, &upstream{
+			Upstream: Upstream{Addr: addr, Priority: i},
+			queue:    &ConnQueue{Size: 1, Conn: ConnWrappers{{ID: 1}}},
+			healthy:  true,
+		})
+	}
+	us.active.Store(us.list[0])
+	return us
+}
+
+func TestUpstreamReportNeedsConsecutiveFailures(t *testing.T) {
+	u := &upstream{healthy: true}
+	errProbe := errors.New("probe failed")
+
+	// a success in between resets the count
+	for range failThreshold - 1 {
+		u.report(errProbe)
+	}
+	u.report(nil)
+	for i := 1; i < failThreshold; i++ {
+		if u.report(errProbe) || !u.isHealthy() {
+			t.Fatalf("failure %d marked the upstream down", i)
+		}
+	}
+
+	if !u.report(errProbe) || u.isHealthy() {
+		t.Fatalf("failure %d kept the upstream up", failThreshold)
+	}
+	if !u.report(nil) || !u.isHealthy() {
+		t.Fatal("successful probe did not bring the upstream back")
+	}
+}
+
+func TestUpstreamsFailOverAndBack(t *testing.T) {
+	us := newTestUpstreams("primary", "secondary", "tertiary")
+	primary, secondary := us.list[0], us.list[1]
+
+	primary.healthy = false
+	us.selectActive()
+	if got := us.active.Load(); got != secondary {
+		t.Fatalf("active = %s, want secondary", got.Addr)
+	}
+
+	primary.healthy = true
+	us.selectActive()
+	if got := us.active.Load(); got != primary {
+		t.Fatalf("active = %s, want primary back", got.Addr)
+	}
+}
+
+func TestUpstreamsKeepActiveWhenAllDown(t *testing.T) {
+	us := newTestUpstreams("primary", "secondary")
+	us.active.Store(us.list[1])
+	for _, u := range us.list {
+		u.healthy = false
+	}
+
+	us.selectActive()
+	if got := us.active.Load(); got != us.list[1] {
+		t.Fatalf("active = %s, want secondary kept", got.Addr)
+	}
+}
+
+func TestUpstreamDetailsMarkActive(t *testing.T) {
+	us := newTestUpstreams("primary", "secondary")
+	us.list[0].healthy = false
+	us.list[0].lastErr = errors.New("unreachable")
+	us.selectActive()
+
+	details := us.details()
+	if len(details) != 2 {
+		t.Fatalf("details = %d, want 2", len(details))
+	}
+	if details[0].Active || details[0].Healthy || details[0].LastError != "unreachable" {
+		t.Fatalf("primary detail = %+v", details[0])
+	}
+	if !details[1].Active || !details[1].Healthy {
+		t.Fatalf("secondary detail = %+v", details[1])
+	}
+}
+
+// newBalancedUpstreams returns upstreams sharing a priority, spread by balance.
+func newBalancedUpstreams(balance Balance, addrs ...string) *upstreams {
+	us := newTestUpstreams(addrs...)
+	for _, u := range us.list {
+		u.Priority = 0
+	}
+	us.balance = balance
+	return us
+}
+
+func TestParseBalance(t *testing.T) {
+	if b, err := ParseBalance(""); err != nil || b != BalanceFailover {
+		t.Fatalf(`ParseBalance("") = %q, %v, want failover`, b, err)
+	}
+	if b, err := ParseBalance("lowest_rtt"); err != nil || b != BalanceLowestRTT {
+		t.Fatalf(`ParseBalance("lowest_rtt") = %q, %v`, b, err)
+	}
+	if _, err := ParseBalance("random"); err == nil {
+		t.Fatal(`ParseBalance("random") succeeded`)
+	}
+}
+
+func TestPickFailoverIgnoresEqualPriority(t *testing.T) {
+	us := newBalancedUpstreams(BalanceFailover, "a", "b")
+	for range 3 {
+		if got := us.pick("example.com"); got != us.list[0] {
+			t.Fatalf("pick() = %s, want a", got.Addr)
+		}
+	}
+}
+
+func TestPickRoundRobinSkipsDownAndStandby(t *testing.T) {
+	us := newBalancedUpstreams(BalanceRoundRobin, "a", "b", "c", "standby")
+	us.list[3].Priority = 1
+	us.list[1].healthy = false
+
+	var got []string
+	for range 4 {
+		got = append(got, us.pick("").Addr)
+	}
+	if want := "[a c a c]"; fmt.Sprint(got) != want {
+		t.Fatalf("picks = %v, want %s", got, want)
+	}
+}
+
+func TestPickLeastStreams(t *testing.T) {
+	us := newBalancedUpstreams(BalanceLeastStreams, "a", "b", "c")
+	us.list[0].queue.Conn[0].InUse.Store(3)
+	us.list[1].queue.Conn[0].InUse.Store(1)
+	us.list[2].queue.Conn[0].InUse.Store(2)
+
+	if got := us.pick(""); got != us.list[1] {
+		t.Fatalf("pick() = %s, want b", got.Addr)
+	}
+}
+
+func TestPickLowestRTT(t *testing.T) {
+	us := newBalancedUpstreams(BalanceLowestRTT, "a", "b", "c")
+	us.list[0].latency = 80 * time.Millisecond
+	us.list[1].latency = 120 * time.Millisecond
+	us.list[2].latency = 30 * time.Millisecond
+
+	if got := us.pick(""); got != us.list[2] {
+		t.Fatalf("pick() = %s, want c", got.Addr)
+	}
+}
+
+func TestPickConsistentHashKeepsHosts(t *testing.T) {
+	us := newBalancedUpstreams(BalanceConsistentHash, "a", "b", "c")
+	hosts := make([]string, 64)
+	before := make(map[string]*upstream, len(hosts))
+	used := make(map[*upstream]bool)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("host%d.example.com", i)
+		before[hosts[i]] = us.pick(hosts[i])
+		used[before[hosts[i]]] = true
+		if again := us.pick(hosts[i]); again != before[hosts[i]] {
+			t.Fatalf("%s moved from %s to %s", hosts[i], before[hosts[i]].Addr, again.Addr)
+		}
+	}
+	if len(used) != len(us.list) {
+		t.Fatalf("hosts spread over %d upstreams, want %d", len(used), len(us.list))
+	}
+
+	// only the hosts of an upstream going down move
+	down := us.list[1]
+	down.healthy = false
+	for _, host := range hosts {
+		got := us.pick(host)
+		if got == down {
+			t.Fatalf("%s still on the upstream down", host)
+		}
+		if before[host] != down && got != before[host] {
+			t.Fatalf("%s moved from %s to %s", host, before[host].Addr, got.Addr)
+		}
+	}
+}
@@ -17,6 +17,7 @@ type ConnectionDetail struct {
 	Status            string `json:"status"`             // active/idle based on load
 	ConnectivityState string `json:"connectivity_state"` // gRPC connectivity state
 	HealthStatus      string `json:"health_status"`      // derived health status
+	Upstream          string `json:"upstream"`           // server the connection goes to
 }
 
 type ConnWrapper struct {
@@ -0,0 +1,95 @@
+package rpc_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/client"
+	proto "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/proto"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
+)
+
+// startConnectProxy runs an HTTP CONNECT proxy that reports the target and
+// credentials of each tunnel it opens.
+func startConnectProxy(t *testing.T) (string, <-chan *http.Request) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("proxy listen: %v", err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	requests := make(chan *http.Request, 16)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				br := bufio.NewReader(conn)
+				req, err := http.ReadRequest(br)
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				requests <- req
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = fmt.Fprint(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer target.Close()
+				_, _ = fmt.Fprint(conn, "HTTP/1.1 200 OK\r\n\r\n")
+				go func() { _, _ = io.Copy(target, br) }()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+	return ln.Addr().String(), requests
+}
+
+// TestEndToEnd_ThroughUpstreamProxy reaches the server only by way of an
+// authenticated HTTP CONNECT proxy.
+func TestEndToEnd_ThroughUpstreamProxy(t *testing.T) {
+	proxyAddr, requests := startConnectProxy(t)
+	d, err := utils.LoadProxy("http://corp:secret@" + proxyAddr)
+	if err != nil {
+		t.Fatalf("LoadProxy() error = %v", err)
+	}
+	rpc.SetUpstreamProxy(d)
+	t.Cleanup(func() { rpc.SetUpstreamProxy(nil) })
+
+	serverAddr := startProxyServer(t)
+	connectClient(t, serverAddr)
+
+	c, err := client.New()
+	if err != nil {
+		t.Fatalf("client.New() error = %v", err)
+	}
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err = c.ProxyClient.DnsResolve(ctx, new(proto.DnsRequest)); err != nil {
+		t.Fatalf("DnsResolve() through the proxy error = %v", err)
+	}
+
+	select {
+	case req := <-requests:
+		if req.Host != serverAddr {
+			t.Errorf("proxy tunnelled to %s, want %s", req.Host, serverAddr)
+		}
+		if req.Header.Get("Proxy-Authorization") == "" {
+			t.Error("proxy got no credentials")
+		}
+	default:
+		t.Fatal("the connection did not go through the proxy")
+	}
+}
@@ -24,6 +24,8 @@ import (
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/client"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/server"
 	serverconfig "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 const testUUID = "e2e-test-user"
@@ -47,6 +49,14 @@ func freeLoopbackAddr(t *testing.T) string {
 // startProxyServer runs a real gRPC proxy server and returns its address.
 func startProxyServer(t *testing.T) string {
 	t.Helper()
+	addr, _ := startProxyServerInstance(t)
+	return addr
+}
+
+// startProxyServerInstance is startProxyServer for tests that manage the
+// server while it runs.
+func startProxyServerInstance(t *testing.T) (string, *server.Server) {
+	t.Helper()
 
 	addr := freeLoopbackAddr(t)
 	ctx, cancel := context.WithCancel(context.Background())
@@ -70,7 +80,7 @@ func startProxyServer(t *testing.T) string {
 	})
 
 	waitForListener(t, addr)
-	return addr
+	return addr, srv
 }
 
 func waitForListener(t *testing.T, addr string) {
@@ -317,6 +327,67 @@ func TestEndToEnd_TCPRoundTripOverGRPC(t *testing.T) {
 	}
 }
 
+// TestEndToEnd_TCPBindOverGRPC drives a BIND through the tunnel: the server
+// listens, reports the address, and relays the connection the peer makes to it.
+func TestEndToEnd_TCPBindOverGRPC(t *testing.T) {
+	routeAllDirect(t)
+	connectClient(t, startProxyServer(t))
+
+	c, err := client.New()
+	if err != nil {
+		t.Fatalf("client.New() error = %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	srcReader, srcWriter := io.Pipe()
+	payload := []byte("hello from the peer")
+	received := make(chan []byte, 1)
+	dst := &signalWriter{want: len(payload), done: received}
+
+	bound := make(chan string, 2)
+	bindErr := make(chan error, 1)
+	go func() {
+		bindErr <- c.Bind(ctx, "127.0.0.1:0", bound, dst, srcReader)
+	}()
+
+	var listenAddr string
+	select {
+	case listenAddr = <-bound:
+	case err := <-bindErr:
+		t.Fatalf("Bind() returned before the listening address: %v", err)
+	}
+	peer, err := net.Dial("tcp", listenAddr)
+	if err != nil {
+		t.Fatalf("dialing the bound address %s: %v", listenAddr, err)
+	}
+	defer peer.Close()
+
+	if got := <-bound; got != peer.LocalAddr().String() {
+		t.Errorf("peer address = %s, want %s", got, peer.LocalAddr())
+	}
+	if _, err := peer.Write(payload); err != nil {
+		t.Fatalf("peer write: %v", err)
+	}
+	select {
+	case got := <-received:
+		if !bytes.Equal(got, payload) {
+			t.Errorf("relayed payload = %q, want %q", got, payload)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("the peer's payload never arrived")
+	}
+
+	_ = srcWriter.Close()
+	select {
+	case <-bindErr:
+	case <-time.After(20 * time.Second):
+		t.Error("Bind() did not return after the source closed")
+	}
+}
+
 // TestEndToEnd_FailFastWhenServerUnreachable verifies an unreachable server
 // produces a prompt error rather than a hang.
 //
@@ -357,3 +428,131 @@ func TestEndToEnd_FailFastWhenServerUnreachable(t *testing.T) {
 			"so WaitForReady must stay unset")
 	}
 }
+
+// TestEndToEnd_RevokeTerminatesStreams verifies removing a user with terminate
+// set tears down its in-flight stream and refuses new ones.
+func TestEndToEnd_RevokeTerminatesStreams(t *testing.T) {
+	routeAllDirect(t)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("tcp echo listen: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	addr, srv := startProxyServerInstance(t)
+	if err := srv.AddUser(&serverconfig.User{UUID: "e2e-other-user"}); err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+	connectClient(t, addr)
+
+	c, err := client.New()
+	if err != nil {
+		t.Fatalf("client.New() error = %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	srcReader, srcWriter := io.Pipe()
+	defer srcWriter.Close()
+	payload := []byte("before revocation")
+	received := make(chan []byte, 1)
+	dst := &signalWriter{want: len(payload), done: received}
+
+	proxyErr := make(chan error, 1)
+	go func() {
+		proxyErr <- c.Proxy(ctx, ln.Addr().String(), make(chan string, 1), dst, srcReader)
+	}()
+	if _, err := srcWriter.Write(payload); err != nil {
+		t.Fatalf("writing to the proxied source: %v", err)
+	}
+	select {
+	case <-received:
+	case err := <-proxyErr:
+		t.Fatalf("Proxy() returned before the reply arrived: %v", err)
+	case <-time.After(30 * time.Second):
+		t.Fatal("no reply completed the round trip")
+	}
+
+	n, err := srv.RemoveUser(testUUID, true)
+	if err != nil {
+		t.Fatalf("RemoveUser() error = %v", err)
+	}
+	if n != 1 {
+		t.Errorf("RemoveUser() terminated %d streams, want 1", n)
+	}
+	select {
+	case err := <-proxyErr:
+		if status.Code(err) != codes.PermissionDenied {
+			t.Errorf("revoked Proxy() = %v, want PermissionDenied", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Proxy() kept running after the user was revoked")
+	}
+
+	c2, err := client.New()
+	if err != nil {
+		t.Fatalf("client.New() error = %v", err)
+	}
+	defer c2.Close()
+	// keep the source open so only the server can end the session
+	srcReader2, srcWriter2 := io.Pipe()
+	defer srcWriter2.Close()
+	err = c2.Proxy(ctx, ln.Addr().String(), make(chan string, 1), io.Discard, srcReader2)
+	if err == nil {
+		t.Fatal("Proxy() succeeded for a revoked user")
+	}
+}
+
+// TestEndToEnd_UpstreamsProbedAndOrdered connects two servers and checks the
+// one of the highest priority carries sessions while both answer probes.
+func TestEndToEnd_UpstreamsProbedAndOrdered(t *testing.T) {
+	standby, primary := startProxyServer(t), startProxyServer(t)
+
+	client.SetUUID(testUUID)
+	if err := client.InitUpstreams([]client.Upstream{
+		{Addr: standby, Priority: 10},
+		{Addr: primary},
+	}, client.BalanceFailover, false, 1, nil); err != nil {
+		t.Fatalf("client.InitUpstreams() error = %v", err)
+	}
+	t.Cleanup(client.Destroy)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		details := client.GetUpstreamDetails()
+		if len(details) != 2 {
+			t.Fatalf("GetUpstreamDetails() = %d upstreams, want 2", len(details))
+		}
+		if details[0].Addr != primary || !details[0].Active || details[1].Active {
+			t.Fatalf("GetUpstreamDetails() = %+v, want %s first and active", details, primary)
+		}
+		if details[0].LatencyMs > 0 && details[1].LatencyMs > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upstreams never answered a probe: %+v", details)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	for _, detail := range client.GetConnectionDetails() {
+		if detail.Upstream != primary && detail.Upstream != standby {
+			t.Errorf("connection upstream = %q, want one of the servers", detail.Upstream)
+		}
+	}
+}
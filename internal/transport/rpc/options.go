@@ -6,9 +6,11 @@ import (
 	"net"
 	"runtime"
 	"slices"
+	"sync"
 	"time"
 
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"golang.org/x/net/proxy"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/backoff"
 	"google.golang.org/grpc/experimental"
@@ -78,6 +80,25 @@ func payloadBufferPool() mem.BufferPool {
 	return mem.NewTieredBufferPool(slices.Compact(sizes)...)
 }
 
+var (
+	upstreamProxyMu sync.RWMutex
+	upstreamProxy   proxy.Dialer
+)
+
+// SetUpstreamProxy makes connections to spaceship servers go through d, nil
+// to dial them directly. It affects the connections dialed afterward.
+func SetUpstreamProxy(d proxy.Dialer) {
+	upstreamProxyMu.Lock()
+	defer upstreamProxyMu.Unlock()
+	upstreamProxy = d
+}
+
+func getUpstreamProxy() proxy.Dialer {
+	upstreamProxyMu.RLock()
+	defer upstreamProxyMu.RUnlock()
+	return upstreamProxy
+}
+
 // dialContext dials the control connection to the spaceship server.
 //
 // This deliberately uses "tcp" rather than transport.DialNetwork: the ipv6
@@ -85,7 +106,16 @@ func payloadBufferPool() mem.BufferPool {
 // server. Forcing IPv4 here would break a v6-only server endpoint for an
 // operator who merely wanted IPv6 destinations blocked.
 func dialContext(ctx context.Context, addr string) (net.Conn, error) {
-	return (&net.Dialer{Timeout: GeneralTimeout}).DialContext(ctx, "tcp", addr)
+	d := getUpstreamProxy()
+	if d == nil {
+		return (&net.Dialer{Timeout: GeneralTimeout}).DialContext(ctx, "tcp", addr)
+	}
+	ctx, cancel := context.WithTimeout(ctx, GeneralTimeout)
+	defer cancel()
+	if cd, ok := d.(proxy.ContextDialer); ok {
+		return cd.DialContext(ctx, "tcp", addr)
+	}
+	return d.Dial("tcp", addr)
 }
 
 // clientKeepaliveParams and serverKeepaliveParams are separate functions purely
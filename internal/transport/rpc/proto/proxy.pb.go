@@ -70,6 +70,57 @@ func (Network) EnumDescriptor() ([]byte, []int) {
 	return file_proxy_proto_rawDescGZIP(), []int{0}
 }
 
+// Mode selects what the server does with the target address.
+// CONNECT is the zero value so that clients that omit it keep dialing.
+type Mode int32
+
+const (
+	// dial the target
+	Mode_CONNECT Mode = 0
+	// listen for a connection from the target, reporting the listening address
+	// and then the peer address in two Accepted headers
+	Mode_BIND Mode = 1
+)
+
+// Enum value maps for Mode.
+var (
+	Mode_name = map[int32]string{
+		0: "CONNECT",
+		1: "BIND",
+	}
+	Mode_value = map[string]int32{
+		"CONNECT": 0,
+		"BIND":    1,
+	}
+)
+
+func (x Mode) Enum() *Mode {
+	p := new(Mode)
+	*p = x
+	return p
+}
+
+func (x Mode) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Mode) Descriptor() protoreflect.EnumDescriptor {
+	return file_proxy_proto_enumTypes[1].Descriptor()
+}
+
+func (Mode) Type() protoreflect.EnumType {
+	return &file_proxy_proto_enumTypes[1]
+}
+
+func (x Mode) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Mode.Descriptor instead.
+func (Mode) EnumDescriptor() ([]byte, []int) {
+	return file_proxy_proto_rawDescGZIP(), []int{1}
+}
+
 type ProxyStatus int32
 
 const (
@@ -106,11 +157,11 @@ func (x ProxyStatus) String() string {
 }
 
 func (ProxyStatus) Descriptor() protoreflect.EnumDescriptor {
-	return file_proxy_proto_enumTypes[1].Descriptor()
+	return file_proxy_proto_enumTypes[2].Descriptor()
 }
 
 func (ProxyStatus) Type() protoreflect.EnumType {
-	return &file_proxy_proto_enumTypes[1]
+	return &file_proxy_proto_enumTypes[2]
 }
 
 func (x ProxyStatus) Number() protoreflect.EnumNumber {
@@ -119,7 +170,7 @@ func (x ProxyStatus) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use ProxyStatus.Descriptor instead.
 func (ProxyStatus) EnumDescriptor() ([]byte, []int) {
-	return file_proxy_proto_rawDescGZIP(), []int{1}
+	return file_proxy_proto_rawDescGZIP(), []int{2}
 }
 
 type ProxySRC struct {
@@ -295,10 +346,12 @@ func (*ProxyDST_Header) isProxyDST_HeaderOrPayload() {}
 func (*ProxyDST_Payload) isProxyDST_HeaderOrPayload() {}
 
 type DnsRequestItem struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Fqdn          string                 `protobuf:"bytes,1,opt,name=fqdn,proto3" json:"fqdn,omitempty"`
-	QType         uint32                 `protobuf:"varint,2,opt,name=qType,proto3" json:"qType,omitempty"`
-	BlockIpv6     bool                   `protobuf:"varint,3,opt,name=blockIpv6,proto3" json:"blockIpv6,omitempty"`
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Fqdn      string                 `protobuf:"bytes,1,opt,name=fqdn,proto3" json:"fqdn,omitempty"`
+	QType     uint32                 `protobuf:"varint,2,opt,name=qType,proto3" json:"qType,omitempty"`
+	BlockIpv6 bool                   `protobuf:"varint,3,opt,name=blockIpv6,proto3" json:"blockIpv6,omitempty"`
+	// EDNS0 OPT record of the client query, passed on to the upstream.
+	Edns          *RR_Record `protobuf:"bytes,4,opt,name=edns,proto3" json:"edns,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -354,6 +407,13 @@ func (x *DnsRequestItem) GetBlockIpv6() bool {
 	return false
 }
 
+func (x *DnsRequestItem) GetEdns() *RR_Record {
+	if x != nil {
+		return x.Edns
+	}
+	return nil
+}
+
 // Complete DNS Resource Record
 type RR_Record struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
@@ -493,8 +553,11 @@ type DnsResult struct {
 	Fqdn string `protobuf:"bytes,1,opt,name=fqdn,proto3" json:"fqdn,omitempty"`
 	// resolved DNS records (updated to use complete RR format)
 	Records []*RR_Record `protobuf:"bytes,2,rep,name=records,proto3" json:"records,omitempty"`
-	// DNS response code returned by the upstream resolver.
-	Rcode         uint32 `protobuf:"varint,3,opt,name=rcode,proto3" json:"rcode,omitempty"`
+	// DNS response code returned by the upstream resolver. Codes above 15 are
+	// only valid together with edns, which carries their upper bits.
+	Rcode uint32 `protobuf:"varint,3,opt,name=rcode,proto3" json:"rcode,omitempty"`
+	// EDNS0 OPT record of the upstream response.
+	Edns          *RR_Record `protobuf:"bytes,4,opt,name=edns,proto3" json:"edns,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -550,6 +613,13 @@ func (x *DnsResult) GetRcode() uint32 {
 	return 0
 }
 
+func (x *DnsResult) GetEdns() *RR_Record {
+	if x != nil {
+		return x.Edns
+	}
+	return nil
+}
+
 type DnsResponse struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
 	// fqdn resolved result
@@ -600,7 +670,9 @@ type ProxySRC_ProxyHeader struct {
 	// target address, host:port
 	Addr string `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
 	// transport network for the connection (defaults to TCP)
-	Network       Network `protobuf:"varint,2,opt,name=network,proto3,enum=proxy.Network" json:"network,omitempty"`
+	Network Network `protobuf:"varint,2,opt,name=network,proto3,enum=proxy.Network" json:"network,omitempty"`
+	// what to do with the target (defaults to CONNECT)
+	Mode          Mode `protobuf:"varint,3,opt,name=mode,proto3,enum=proxy.Mode" json:"mode,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -649,6 +721,13 @@ func (x *ProxySRC_ProxyHeader) GetNetwork() Network {
 	return Network_TCP
 }
 
+func (x *ProxySRC_ProxyHeader) GetMode() Mode {
+	if x != nil {
+		return x.Mode
+	}
+	return Mode_CONNECT
+}
+
 type ProxyDST_ProxyHeader struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Addr          string                 `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
@@ -697,13 +776,14 @@ var File_proxy_proto protoreflect.FileDescriptor
 
 const file_proxy_proto_rawDesc = "" +
 	"\n" +
-	"\vproxy.proto\x12\x05proxy\"\xbf\x01\n" +
+	"\vproxy.proto\x12\x05proxy\"\xe0\x01\n" +
 	"\bProxySRC\x125\n" +
 	"\x06header\x18\x01 \x01(\v2\x1b.proxy.ProxySRC.ProxyHeaderH\x00R\x06header\x12\x1a\n" +
-	"\apayload\x18\x02 \x01(\fH\x00R\apayload\x1aK\n" +
+	"\apayload\x18\x02 \x01(\fH\x00R\apayload\x1al\n" +
 	"\vProxyHeader\x12\x12\n" +
 	"\x04addr\x18\x01 \x01(\tR\x04addr\x12(\n" +
-	"\anetwork\x18\x02 \x01(\x0e2\x0e.proxy.NetworkR\anetworkB\x13\n" +
+	"\anetwork\x18\x02 \x01(\x0e2\x0e.proxy.NetworkR\anetwork\x12\x1f\n" +
+	"\x04mode\x18\x03 \x01(\x0e2\v.proxy.ModeR\x04modeB\x13\n" +
 	"\x11header_or_payload\"\xc1\x01\n" +
 	"\bProxyDST\x12*\n" +
 	"\x06status\x18\x01 \x01(\x0e2\x12.proxy.ProxyStatusR\x06status\x125\n" +
@@ -711,11 +791,12 @@ const file_proxy_proto_rawDesc = "" +
 	"\apayload\x18\x03 \x01(\fH\x00R\apayload\x1a!\n" +
 	"\vProxyHeader\x12\x12\n" +
 	"\x04addr\x18\x01 \x01(\tR\x04addrB\x13\n" +
-	"\x11header_or_payload\"X\n" +
+	"\x11header_or_payload\"~\n" +
 	"\x0eDnsRequestItem\x12\x12\n" +
 	"\x04fqdn\x18\x01 \x01(\tR\x04fqdn\x12\x14\n" +
 	"\x05qType\x18\x02 \x01(\rR\x05qType\x12\x1c\n" +
-	"\tblockIpv6\x18\x03 \x01(\bR\tblockIpv6\"|\n" +
+	"\tblockIpv6\x18\x03 \x01(\bR\tblockIpv6\x12$\n" +
+	"\x04edns\x18\x04 \x01(\v2\x10.proxy.RR_RecordR\x04edns\"|\n" +
 	"\tRR_Record\x12\x1b\n" +
 	"\twire_data\x18\x01 \x01(\fR\bwireData\x12\x12\n" +
 	"\x04name\x18\x02 \x01(\tR\x04name\x12\x16\n" +
@@ -725,16 +806,20 @@ const file_proxy_proto_rawDesc = "" +
 	"\n" +
 	"DnsRequest\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12+\n" +
-	"\x05items\x18\x02 \x03(\v2\x15.proxy.DnsRequestItemR\x05items\"a\n" +
+	"\x05items\x18\x02 \x03(\v2\x15.proxy.DnsRequestItemR\x05items\"\x87\x01\n" +
 	"\tDnsResult\x12\x12\n" +
 	"\x04fqdn\x18\x01 \x01(\tR\x04fqdn\x12*\n" +
 	"\arecords\x18\x02 \x03(\v2\x10.proxy.RR_RecordR\arecords\x12\x14\n" +
-	"\x05rcode\x18\x03 \x01(\rR\x05rcode\"7\n" +
+	"\x05rcode\x18\x03 \x01(\rR\x05rcode\x12$\n" +
+	"\x04edns\x18\x04 \x01(\v2\x10.proxy.RR_RecordR\x04edns\"7\n" +
 	"\vDnsResponse\x12(\n" +
 	"\x06result\x18\x01 \x03(\v2\x10.proxy.DnsResultR\x06result*\x1b\n" +
 	"\aNetwork\x12\a\n" +
 	"\x03TCP\x10\x00\x12\a\n" +
-	"\x03UDP\x10\x01*<\n" +
+	"\x03UDP\x10\x01*\x1d\n" +
+	"\x04Mode\x12\v\n" +
+	"\aCONNECT\x10\x00\x12\b\n" +
+	"\x04BIND\x10\x01*<\n" +
 	"\vProxyStatus\x12\v\n" +
 	"\aSession\x10\x00\x12\t\n" +
 	"\x05Error\x10\x01\x12\f\n" +
@@ -757,38 +842,42 @@ func file_proxy_proto_rawDescGZIP() []byte {
 	return file_proxy_proto_rawDescData
 }
 
-var file_proxy_proto_enumTypes = make([]protoimpl.EnumInfo, 2)
+var file_proxy_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
 var file_proxy_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_proxy_proto_goTypes = []any{
 	(Network)(0),                 // 0: proxy.Network
-	(ProxyStatus)(0),             // 1: proxy.ProxyStatus
-	(*ProxySRC)(nil),             // 2: proxy.ProxySRC
-	(*ProxyDST)(nil),             // 3: proxy.ProxyDST
-	(*DnsRequestItem)(nil),       // 4: proxy.DnsRequestItem
-	(*RR_Record)(nil),            // 5: proxy.RR_Record
-	(*DnsRequest)(nil),           // 6: proxy.DnsRequest
-	(*DnsResult)(nil),            // 7: proxy.DnsResult
-	(*DnsResponse)(nil),          // 8: proxy.DnsResponse
-	(*ProxySRC_ProxyHeader)(nil), // 9: proxy.ProxySRC.ProxyHeader
-	(*ProxyDST_ProxyHeader)(nil), // 10: proxy.ProxyDST.ProxyHeader
+	(Mode)(0),                    // 1: proxy.Mode
+	(ProxyStatus)(0),             // 2: proxy.ProxyStatus
+	(*ProxySRC)(nil),             // 3: proxy.ProxySRC
+	(*ProxyDST)(nil),             // 4: proxy.ProxyDST
+	(*DnsRequestItem)(nil),       // 5: proxy.DnsRequestItem
+	(*RR_Record)(nil),            // 6: proxy.RR_Record
+	(*DnsRequest)(nil),           // 7: proxy.DnsRequest
+	(*DnsResult)(nil),            // 8: proxy.DnsResult
+	(*DnsResponse)(nil),          // 9: proxy.DnsResponse
+	(*ProxySRC_ProxyHeader)(nil), // 10: proxy.ProxySRC.ProxyHeader
+	(*ProxyDST_ProxyHeader)(nil), // 11: proxy.ProxyDST.ProxyHeader
 }
 var file_proxy_proto_depIdxs = []int32{
-	9,  // 0: proxy.ProxySRC.header:type_name -> proxy.ProxySRC.ProxyHeader
-	1,  // 1: proxy.ProxyDST.status:type_name -> proxy.ProxyStatus
-	10, // 2: proxy.ProxyDST.header:type_name -> proxy.ProxyDST.ProxyHeader
-	4,  // 3: proxy.DnsRequest.items:type_name -> proxy.DnsRequestItem
-	5,  // 4: proxy.DnsResult.records:type_name -> proxy.RR_Record
-	7,  // 5: proxy.DnsResponse.result:type_name -> proxy.DnsResult
-	0,  // 6: proxy.ProxySRC.ProxyHeader.network:type_name -> proxy.Network
-	6,  // 7: proxy.Proxy.DnsResolve:input_type -> proxy.DnsRequest
-	2,  // 8: proxy.Proxy.Proxy:input_type -> proxy.ProxySRC
-	8,  // 9: proxy.Proxy.DnsResolve:output_type -> proxy.DnsResponse
-	3,  // 10: proxy.Proxy.Proxy:output_type -> proxy.ProxyDST
-	9,  // [9:11] is the sub-list for method output_type
-	7,  // [7:9] is the sub-list for method input_type
-	7,  // [7:7] is the sub-list for extension type_name
-	7,  // [7:7] is the sub-list for extension extendee
-	0,  // [0:7] is the sub-list for field type_name
+	10, // 0: proxy.ProxySRC.header:type_name -> proxy.ProxySRC.ProxyHeader
+	2,  // 1: proxy.ProxyDST.status:type_name -> proxy.ProxyStatus
+	11, // 2: proxy.ProxyDST.header:type_name -> proxy.ProxyDST.ProxyHeader
+	6,  // 3: proxy.DnsRequestItem.edns:type_name -> proxy.RR_Record
+	5,  // 4: proxy.DnsRequest.items:type_name -> proxy.DnsRequestItem
+	6,  // 5: proxy.DnsResult.records:type_name -> proxy.RR_Record
+	6,  // 6: proxy.DnsResult.edns:type_name -> proxy.RR_Record
+	8,  // 7: proxy.DnsResponse.result:type_name -> proxy.DnsResult
+	0,  // 8: proxy.ProxySRC.ProxyHeader.network:type_name -> proxy.Network
+	1,  // 9: proxy.ProxySRC.ProxyHeader.mode:type_name -> proxy.Mode
+	7,  // 10: proxy.Proxy.DnsResolve:input_type -> proxy.DnsRequest
+	3,  // 11: proxy.Proxy.Proxy:input_type -> proxy.ProxySRC
+	9,  // 12: proxy.Proxy.DnsResolve:output_type -> proxy.DnsResponse
+	4,  // 13: proxy.Proxy.Proxy:output_type -> proxy.ProxyDST
+	12, // [12:14] is the sub-list for method output_type
+	10, // [10:12] is the sub-list for method input_type
+	10, // [10:10] is the sub-list for extension type_name
+	10, // [10:10] is the sub-list for extension extendee
+	0,  // [0:10] is the sub-list for field type_name
 }
 
 func init() { file_proxy_proto_init() }
@@ -809,7 +898,7 @@ func file_proxy_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proxy_proto_rawDesc), len(file_proxy_proto_rawDesc)),
-			NumEnums:      2,
+			NumEnums:      3,
 			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
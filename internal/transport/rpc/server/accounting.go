@@ -0,0 +1,338 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	config "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// stateSaveInterval bounds how much accounting a crash can lose.
+	stateSaveInterval = time.Minute
+	// quotaUnit converts the configured quota (MB) to bytes.
+	quotaUnit = 1024 * 1024
+	// periodLayout names an accounting period; quotas reset monthly.
+	periodLayout = "2006-01"
+)
+
+// UserTraffic is a snapshot of one user's accounted traffic. Up is client to
+// target, Down is target to client, matching server.Limit.
+type UserTraffic struct {
+	UUID           string `json:"uuid"`
+	Remark         string `json:"remark,omitempty"`
+	Period         string `json:"period"`
+	UpBytes        uint64 `json:"up_bytes"`
+	DownBytes      uint64 `json:"down_bytes"`
+	TotalUpBytes   uint64 `json:"total_up_bytes"`
+	TotalDownBytes uint64 `json:"total_down_bytes"`
+	QuotaBytes     uint64 `json:"quota_bytes,omitempty"`
+	QuotaExceeded  bool   `json:"quota_exceeded,omitempty"`
+}
+
+// GetUserTraffic returns the per-user counters of the running server, sorted by
+// user id.
+func GetUserTraffic() ([]UserTraffic, error) {
+	s := getActiveServer()
+	if s == nil {
+		return nil, ErrNotRunning
+	}
+	return s.accounting.Snapshot(), nil
+}
+
+// userCounter holds one user's counters. The period counters back the quota
+// and are zeroed when the month rolls over; the totals never reset.
+type userCounter struct {
+	up        atomic.Uint64
+	down      atomic.Uint64
+	totalUp   atomic.Uint64
+	totalDown atomic.Uint64
+	quota     atomic.Uint64 // bytes, 0 = none
+	remark    string        // guarded by accounting.mu
+}
+
+func (c *userCounter) addUp(n int) {
+	if n > 0 {
+		c.up.Add(uint64(n))
+		c.totalUp.Add(uint64(n))
+	}
+}
+
+func (c *userCounter) addDown(n int) {
+	if n > 0 {
+		c.down.Add(uint64(n))
+		c.totalDown.Add(uint64(n))
+	}
+}
+
+func (c *userCounter) exceeded() bool {
+	quota := c.quota.Load()
+	return quota > 0 && c.up.Load()+c.down.Load() >= quota
+}
+
+// accounting tracks traffic per user id — the identity the stream interceptor
+// validated — and optionally persists it to a state file.
+type accounting struct {
+	mu        sync.RWMutex
+	users     map[string]*userCounter
+	period    string
+	statePath string
+	now       func() time.Time
+}
+
+func newAccounting(users config.Users) *accounting {
+	a := &accounting{
+		users: make(map[string]*userCounter, len(users)),
+		now:   time.Now,
+	}
+	a.period = a.currentPeriod()
+	a.SetUsers(users)
+	return a
+}
+
+// SetUsers applies the quota and remark of users. Counters are kept, including
+// those of users no longer configured, so re-adding a user cannot reset its
+// monthly usage.
+func (a *accounting) SetUsers(users config.Users) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	configured := make(map[string]struct{}, len(users))
+	for _, user := range users {
+		if user == nil {
+			continue
+		}
+		configured[user.UUID] = struct{}{}
+		c, ok := a.users[user.UUID]
+		if !ok {
+			c = new(userCounter)
+			a.users[user.UUID] = c
+		}
+		c.quota.Store(user.Quota * quotaUnit)
+		c.remark = user.Remark
+	}
+	for uid, c := range a.users {
+		if _, ok := configured[uid]; !ok {
+			c.quota.Store(0)
+		}
+	}
+}
+
+func (a *accounting) currentPeriod() string {
+	return a.now().UTC().Format(periodLayout)
+}
+
+// counter returns the counter of uid, creating one for ids that are not in
+// the configured user list so their traffic is still accounted.
+func (a *accounting) counter(uid string) *userCounter {
+	a.mu.RLock()
+	c, ok := a.users[uid]
+	a.mu.RUnlock()
+	if ok {
+		return c
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if c, ok = a.users[uid]; !ok {
+		c = new(userCounter)
+		a.users[uid] = c
+	}
+	return c
+}
+
+// rollover zeroes the period counters once the calendar month has changed.
+func (a *accounting) rollover() {
+	period := a.currentPeriod()
+	a.mu.RLock()
+	same := period == a.period
+	a.mu.RUnlock()
+	if same {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if period == a.period {
+		return
+	}
+	log.Printf("accounting: period %s ended, resetting monthly counters", a.period)
+	a.period = period
+	for _, c := range a.users {
+		c.up.Store(0)
+		c.down.Store(0)
+	}
+}
+
+// CheckQuota returns a ResourceExhausted status when uid has used up its
+// monthly quota, which the client surfaces as the reason its request failed.
+func (a *accounting) CheckQuota(uid string) error {
+	a.rollover()
+	if c := a.counter(uid); c.exceeded() {
+		return status.Errorf(codes.ResourceExhausted, "monthly traffic quota of %d MB exceeded", c.quota.Load()/quotaUnit)
+	}
+	return nil
+}
+
+// Snapshot returns the counters of every known user sorted by user id.
+func (a *accounting) Snapshot() []UserTraffic {
+	a.rollover()
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	out := make([]UserTraffic, 0, len(a.users))
+	for uid, c := range a.users {
+		out = append(out, UserTraffic{
+			UUID:           uid,
+			Remark:         c.remark,
+			Period:         a.period,
+			UpBytes:        c.up.Load(),
+			DownBytes:      c.down.Load(),
+			TotalUpBytes:   c.totalUp.Load(),
+			TotalDownBytes: c.totalDown.Load(),
+			QuotaBytes:     c.quota.Load(),
+			QuotaExceeded:  c.exceeded(),
+		})
+	}
+	slices.SortFunc(out, func(x, y UserTraffic) int {
+		return cmp.Compare(x.UUID, y.UUID)
+	})
+	return out
+}
+
+// Conn wraps the target connection of a stream so every byte is charged to c.
+func (c *userCounter) Conn(conn net.Conn) net.Conn {
+	if c == nil {
+		return conn
+	}
+	return &countedConn{Conn: conn, counter: c}
+}
+
+type countedConn struct {
+	net.Conn
+	counter *userCounter
+}
+
+func (c *countedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.counter.addDown(n)
+	return n, err
+}
+
+func (c *countedConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.counter.addUp(n)
+	return n, err
+}
+
+// --- persistence ---
+
+type stateFile struct {
+	Period string                    `json:"period"`
+	Users  map[string]userStateEntry `json:"users"`
+}
+
+type userStateEntry struct {
+	Up        uint64 `json:"up"`
+	Down      uint64 `json:"down"`
+	TotalUp   uint64 `json:"total_up"`
+	TotalDown uint64 `json:"total_down"`
+}
+
+// Load restores counters from path and remembers it for later saves. A missing
+// file is not an error: it is created on the first save. Counters from an
+// earlier period only contribute to the lifetime totals.
+func (a *accounting) Load(path string) error {
+	a.mu.Lock()
+	a.statePath = path
+	a.mu.Unlock()
+
+	b, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("read state file: %w", err)
+	}
+	var state stateFile
+	if err = json.Unmarshal(b, &state); err != nil {
+		return fmt.Errorf("parse state file %s: %w", path, err)
+	}
+
+	a.rollover()
+	samePeriod := state.Period == a.period
+	for uid, entry := range state.Users {
+		c := a.counter(uid)
+		c.totalUp.Store(entry.TotalUp)
+		c.totalDown.Store(entry.TotalDown)
+		if samePeriod {
+			c.up.Store(entry.Up)
+			c.down.Store(entry.Down)
+		}
+	}
+	log.Printf("accounting: restored %d users from %s", len(state.Users), path)
+	return nil
+}
+
+// Save writes the counters to the state file, if one was loaded. The file is
+// replaced atomically so a crash mid-write never leaves it truncated.
+func (a *accounting) Save() error {
+	a.rollover()
+	a.mu.RLock()
+	path := a.statePath
+	state := stateFile{Period: a.period, Users: make(map[string]userStateEntry, len(a.users))}
+	for uid, c := range a.users {
+		state.Users[uid] = userStateEntry{
+			Up:        c.up.Load(),
+			Down:      c.down.Load(),
+			TotalUp:   c.totalUp.Load(),
+			TotalDown: c.totalDown.Load(),
+		}
+	}
+	a.mu.RUnlock()
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("write state file: %w", err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace state file: %w", err)
+	}
+	return nil
+}
+
+// saveLoop persists the counters periodically until done is closed, then
+// saves one final time.
+func (a *accounting) saveLoop(done <-chan struct{}) {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := a.Save(); err != nil {
+				log.Printf("accounting: %v", err)
+			}
+		case <-done:
+			if err := a.Save(); err != nil {
+				log.Printf("accounting: %v", err)
+			}
+			return
+		}
+	}
+}
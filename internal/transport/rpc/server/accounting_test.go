@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc"
+	proto "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/proto"
+	config "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestCountedConnChargesBothDirections(t *testing.T) {
+	a := newAccounting(config.Users{{UUID: "u"}})
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	conn := a.counter("u").Conn(c1)
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, 5)
+		_, _ = io.ReadFull(c2, buf)
+		_, _ = c2.Write([]byte("abc"))
+	}()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if _, err := io.ReadFull(conn, make([]byte, 3)); err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	got := a.Snapshot()
+	if len(got) != 1 || got[0].UpBytes != 5 || got[0].DownBytes != 3 {
+		t.Fatalf("Snapshot() = %+v, want 5 up / 3 down", got)
+	}
+	if got[0].TotalUpBytes != 5 || got[0].TotalDownBytes != 3 {
+		t.Fatalf("lifetime totals = %+v, want 5 up / 3 down", got[0])
+	}
+}
+
+func TestAccountingQuota(t *testing.T) {
+	a := newAccounting(config.Users{{UUID: "capped", Quota: 1}, {UUID: "free"}})
+	if err := a.CheckQuota("capped"); err != nil {
+		t.Fatalf("CheckQuota() before use = %v", err)
+	}
+
+	a.counter("capped").addDown(quotaUnit)
+	a.counter("free").addDown(10 * quotaUnit)
+
+	err := a.CheckQuota("capped")
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("CheckQuota() = %v, want ResourceExhausted", err)
+	}
+	if err = a.CheckQuota("free"); err != nil {
+		t.Fatalf("CheckQuota() for a user without quota = %v", err)
+	}
+}
+
+func TestAccountingRolloverResetsPeriodOnly(t *testing.T) {
+	now := time.Date(2026, 1, 31, 23, 0, 0, 0, time.UTC)
+	a := newAccounting(config.Users{{UUID: "u", Quota: 1}})
+	a.now = func() time.Time { return now }
+	a.period = a.currentPeriod()
+
+	a.counter("u").addUp(quotaUnit)
+	if err := a.CheckQuota("u"); err == nil {
+		t.Fatal("quota not enforced within the period")
+	}
+
+	now = now.Add(2 * time.Hour) // February
+	if err := a.CheckQuota("u"); err != nil {
+		t.Fatalf("quota still enforced after the month rolled over: %v", err)
+	}
+	got := a.Snapshot()[0]
+	if got.Period != "2026-02" || got.UpBytes != 0 || got.TotalUpBytes != quotaUnit {
+		t.Fatalf("after rollover = %+v, want period reset and lifetime kept", got)
+	}
+}
+
+func TestAccountingStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+
+	a := newAccounting(config.Users{{UUID: "u"}})
+	if err := a.Load(path); err != nil {
+		t.Fatalf("Load() of a missing file = %v", err)
+	}
+	a.counter("u").addUp(7)
+	a.counter("u").addDown(11)
+	if err := a.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	b := newAccounting(config.Users{{UUID: "u"}})
+	if err := b.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	got := b.Snapshot()[0]
+	if got.UpBytes != 7 || got.DownBytes != 11 || got.TotalUpBytes != 7 || got.TotalDownBytes != 11 {
+		t.Fatalf("restored = %+v, want 7 up / 11 down", got)
+	}
+}
+
+func TestAccountingStateFromEarlierPeriod(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	state := `{"period":"2000-01","users":{"u":{"up":5,"down":5,"total_up":50,"total_down":60}}}`
+	if err := os.WriteFile(path, []byte(state), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newAccounting(config.Users{{UUID: "u"}})
+	if err := a.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	got := a.Snapshot()[0]
+	if got.UpBytes != 0 || got.DownBytes != 0 || got.TotalUpBytes != 50 || got.TotalDownBytes != 60 {
+		t.Fatalf("restored = %+v, want only lifetime totals", got)
+	}
+}
+
+func TestAccountingLoadRejectsCorruptState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := newAccounting(nil).Load(path); err == nil {
+		t.Fatal("Load() accepted a corrupt state file")
+	}
+}
+
+// DnsResolve must refuse a user over quota before touching the resolver.
+func TestDnsResolveRejectsExhaustedQuota(t *testing.T) {
+	s, err := NewServer(context.Background(), config.Users{{UUID: "capped", Quota: 1}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.accounting.counter("capped").addUp(quotaUnit)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(rpc.MetadataKeyUserID, "capped"))
+	interceptor := rpc.UnaryServerAuthInterceptor(func(string) bool { return true })
+	_, err = interceptor(ctx, &proto.DnsRequest{}, &grpc.UnaryServerInfo{},
+		func(ctx context.Context, req any) (any, error) {
+			return s.DnsResolve(ctx, req.(*proto.DnsRequest))
+		})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("DnsResolve() = %v, want ResourceExhausted", err)
+	}
+}
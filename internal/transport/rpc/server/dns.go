@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"log"
 	"time"
 
@@ -9,30 +10,37 @@ import (
 
 const DNSClientTimeout = 5 * time.Second
 
-// resolveDNSRecords performs actual DNS resolution using the shared miekg/dns client.
-func (s *Server) resolveDNSRecords(fqdn string, qtype uint16) ([]dns.RR, int) {
+// resolveDNSRecords performs actual DNS resolution using the configured upstream.
+// The client's OPT record, if any, goes out with the query, and the
+// upstream's comes back along with the answer.
+func (s *Server) resolveDNSRecords(ctx context.Context, fqdn string, qtype uint16, edns *dns.OPT) ([]dns.RR, int, *dns.OPT) {
 	// Create DNS query message
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(fqdn), qtype)
 	m.RecursionDesired = true
+	if edns != nil {
+		m.Extra = append(m.Extra, edns)
+	}
 
 	// Query DNS server using the shared client (safe for concurrent use)
-	response, _, err := s.dnsClient.Exchange(m, s.dnsAddr)
+	ctx, cancel := context.WithTimeout(ctx, DNSClientTimeout)
+	defer cancel()
+	response, err := s.resolver.Exchange(ctx, m)
 	if err != nil {
-		log.Printf("dns: resolve %s via %s failed: %v", fqdn, s.dnsAddr, err)
-		return nil, dns.RcodeServerFailure
+		log.Printf("dns: resolve %s via %s failed: %v", fqdn, s.dnsConfig.Address(), err)
+		return nil, dns.RcodeServerFailure, nil
 	}
 
 	if response == nil {
 		log.Printf("dns: resolve %s: empty response", fqdn)
-		return nil, dns.RcodeServerFailure
+		return nil, dns.RcodeServerFailure, nil
 	}
 	if response.Rcode != dns.RcodeSuccess {
 		log.Printf("dns: resolve %s: rcode %d", fqdn, response.Rcode)
 	}
 
 	// Return all answer records
-	return response.Answer, response.Rcode
+	return response.Answer, response.Rcode, response.IsEdns0()
 }
 
 // safeUint32ToUint16 safely converts uint32 to uint16, returning an error if overflow would occur
@@ -2,12 +2,14 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"testing"
-	"time"
 
 	proto "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/proto"
 	rpcutils "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/utils"
+	config "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+	pkgdns "github.com/SuzukiHonoka/spaceship/v2/pkg/dns"
 	"github.com/miekg/dns"
 )
 
@@ -29,6 +31,18 @@ func startTestDNSServer(t *testing.T, handler dns.Handler) string {
 	return conn.LocalAddr().String()
 }
 
+// newResolvingServer returns a bare Server whose upstream is the plain
+// resolver at addr.
+func newResolvingServer(t *testing.T, addr string) *Server {
+	t.Helper()
+	cfg := &pkgdns.DNS{Type: pkgdns.TypeCommon, Server: addr}
+	resolver, err := cfg.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Server{dnsConfig: cfg, resolver: resolver}
+}
+
 func TestDnsResolvePreservesUpstreamRcode(t *testing.T) {
 	addr := startTestDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
 		response := new(dns.Msg)
@@ -36,10 +50,7 @@ func TestDnsResolvePreservesUpstreamRcode(t *testing.T) {
 		response.Rcode = dns.RcodeNameError
 		_ = w.WriteMsg(response)
 	}))
-	srv := &Server{
-		dnsAddr:   addr,
-		dnsClient: &dns.Client{Net: "udp", Timeout: time.Second},
-	}
+	srv := newResolvingServer(t, addr)
 
 	response, err := srv.DnsResolve(context.Background(), &proto.DnsRequest{Items: []*proto.DnsRequestItem{
 		{Fqdn: "missing.example.", QType: uint32(dns.TypeA)},
@@ -68,7 +79,7 @@ func TestDnsResolveReturnsUpstreamRecords(t *testing.T) {
 		}}
 		_ = w.WriteMsg(response)
 	}))
-	srv := &Server{dnsAddr: addr, dnsClient: &dns.Client{Net: "udp", Timeout: time.Second}}
+	srv := newResolvingServer(t, addr)
 
 	response, err := srv.DnsResolve(context.Background(), &proto.DnsRequest{Items: []*proto.DnsRequestItem{
 		{Fqdn: "example.com.", QType: uint32(dns.TypeA)},
@@ -88,6 +99,65 @@ func TestDnsResolveReturnsUpstreamRecords(t *testing.T) {
 	}
 }
 
+func TestDnsResolvePassesEDNSThrough(t *testing.T) {
+	var got *dns.OPT
+	addr := startTestDNSServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+		got = request.IsEdns0()
+		response := new(dns.Msg)
+		response.SetReply(request)
+		response.SetEdns0(1232, true)
+		response.Rcode = dns.RcodeBadVers
+		_ = w.WriteMsg(response)
+	}))
+	srv := newResolvingServer(t, addr)
+
+	opt := new(dns.OPT)
+	opt.Hdr = dns.RR_Header{Name: ".", Rrtype: dns.TypeOPT}
+	opt.SetUDPSize(4096)
+	opt.SetDo()
+	opt.Option = append(opt.Option, &dns.EDNS0_SUBNET{
+		Code: dns.EDNS0SUBNET, Family: 1, SourceNetmask: 24, Address: net.ParseIP("198.51.100.0").To4(),
+	})
+	edns, err := rpcutils.ConvertRRToProto(opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := srv.DnsResolve(context.Background(), &proto.DnsRequest{Items: []*proto.DnsRequestItem{
+		{Fqdn: "example.com.", QType: uint32(dns.TypeA), Edns: edns},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.String() != opt.String() {
+		t.Fatalf("upstream EDNS = %v, want %v", got, opt)
+	}
+	result := response.Result[0]
+	if result.Rcode != dns.RcodeBadVers {
+		t.Fatalf("rcode = %d, want BADVERS", result.Rcode)
+	}
+	respOPT, err := rpcutils.ConvertProtoToOPT(result.Edns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if respOPT == nil || respOPT.UDPSize() != 1232 || !respOPT.Do() {
+		t.Fatalf("response EDNS = %v, want the upstream OPT record", respOPT)
+	}
+}
+
+func TestDnsResolveRejectsMalformedEDNS(t *testing.T) {
+	srv := new(Server)
+	response, err := srv.DnsResolve(context.Background(), &proto.DnsRequest{Items: []*proto.DnsRequestItem{
+		{Fqdn: "example.com.", QType: uint32(dns.TypeA), Edns: &proto.RR_Record{WireData: []byte{0xff}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Result) != 1 || response.Result[0].Rcode != dns.RcodeFormatError {
+		t.Fatalf("result = %+v, want FORMERR", response.Result)
+	}
+}
+
 func TestDnsResolveReturnsResultForLocallyHandledQuestions(t *testing.T) {
 	srv := new(Server)
 	tests := []struct {
@@ -119,3 +189,54 @@ func TestDnsResolveReturnsResultForLocallyHandledQuestions(t *testing.T) {
 		})
 	}
 }
+
+// DnsResolve must reach a DOT upstream configured through NewServer.
+func TestDnsResolveOverDOT(t *testing.T) {
+	certPath, keyPath := writeSelfSigned(t)
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := &dns.Server{Listener: ln, Net: "tcp-tls", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, request *dns.Msg) {
+		response := new(dns.Msg)
+		response.SetReply(request)
+		response.Answer = []dns.RR{&dns.A{
+			Hdr: dns.RR_Header{Name: request.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.30").To4(),
+		}}
+		_ = w.WriteMsg(response)
+	})}
+	go func() { _ = upstream.ActivateAndServe() }()
+	t.Cleanup(func() { _ = upstream.Shutdown() })
+
+	srv, err := NewServer(context.Background(), config.Users{{UUID: "u"}}, nil,
+		&pkgdns.DNS{Type: pkgdns.TypeDOT, Server: ln.Addr().String(), CA: certPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := srv.DnsResolve(context.Background(), &proto.DnsRequest{Items: []*proto.DnsRequestItem{
+		{Fqdn: "example.com.", QType: uint32(dns.TypeA)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Result) != 1 || response.Result[0].Rcode != dns.RcodeSuccess || len(response.Result[0].Records) != 1 {
+		t.Fatalf("result = %+v, want one record over DOT", response.Result)
+	}
+}
+
+// An empty request is the client's health probe and must be answered
+// without a resolver.
+func TestDnsResolveAnswersEmptyProbe(t *testing.T) {
+	response, err := new(Server).DnsResolve(context.Background(), new(proto.DnsRequest))
+	if err != nil {
+		t.Fatalf("DnsResolve() error = %v", err)
+	}
+	if len(response.Result) != 0 {
+		t.Fatalf("DnsResolve() results = %d, want 0", len(response.Result))
+	}
+}
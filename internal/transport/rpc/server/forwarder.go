@@ -9,9 +9,9 @@ import (
 	"strings"
 	"sync"
 
-	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
 	proto "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/proto"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -62,7 +62,18 @@ type Forwarder struct {
 	// network is the dial network chosen during handshake ("tcp"/"udp"/…).
 	// Used to decide whether an empty payload ends the session (TCP) or is a
 	// valid zero-length datagram (UDP).
-	network   string
+	network string
+	// bind is set when the client asked the server to take a connection in
+	// from the target instead of dialing it.
+	bind bool
+	// limiter paces the target connection by the user's bandwidth caps; nil
+	// when the user is unlimited.
+	limiter *bandwidthLimiter
+	// policy routes the user's traffic; nil routes by the shared table.
+	policy *userPolicy
+	// counter charges the target connection's traffic to the user; nil when
+	// the stream is not attributed to a user.
+	counter   *userCounter
 	Ack       chan struct{}
 	closeOnce sync.Once
 }
@@ -101,17 +112,13 @@ func (f *Forwarder) CopyTargetToClient(ctx context.Context) (err error) {
 		return ctx.Err()
 	}
 
-	// send local addr to client for nat
-	msgAccept := &proto.ProxyDST{
-		Status: proto.ProxyStatus_Accepted,
-		HeaderOrPayload: &proto.ProxyDST_Header{
-			Header: &proto.ProxyDST_ProxyHeader{
-				Addr: f.Conn.LocalAddr().String(),
-			},
-		},
+	// send local addr to client for nat, or the peer that connected for bind
+	addr := f.Conn.LocalAddr()
+	if f.bind {
+		addr = f.Conn.RemoteAddr()
 	}
-	if err = f.Stream.Send(msgAccept); err != nil {
-		return fmt.Errorf("send accept: %w", err)
+	if err = f.sendAccepted(addr.String()); err != nil {
+		return err
 	}
 
 	// Closing the target connection is sufficient to interrupt a blocked Read.
@@ -166,7 +173,22 @@ func (f *Forwarder) copyTargetToClient(buf []byte, dstData *proto.ProxyDST, payl
 	return err
 }
 
-func (f *Forwarder) handshake() error {
+func (f *Forwarder) sendAccepted(addr string) error {
+	msgAccept := &proto.ProxyDST{
+		Status: proto.ProxyStatus_Accepted,
+		HeaderOrPayload: &proto.ProxyDST_Header{
+			Header: &proto.ProxyDST_ProxyHeader{
+				Addr: addr,
+			},
+		},
+	}
+	if err := f.Stream.Send(msgAccept); err != nil {
+		return fmt.Errorf("send accept: %w", err)
+	}
+	return nil
+}
+
+func (f *Forwarder) handshake(ctx context.Context) error {
 	req, err := f.Stream.Recv()
 	if err != nil {
 		return err
@@ -183,18 +205,22 @@ func (f *Forwarder) handshake() error {
 	f.target = addr
 
 	// Auth is handled by the stream interceptor.
-	host, _, err := net.SplitHostPort(addr)
+	host, port, err := net.SplitHostPort(addr)
 	if err != nil {
 		return fmt.Errorf("invalid address %q: %w", addr, err)
 	}
-	route, err := router.GetRoute(host)
+	route, err := f.policy.route(host, utils.ParsePort(port))
 	if err != nil {
 		return fmt.Errorf("route: %w", err)
 	}
+	if header.GetMode() == proto.Mode_BIND {
+		log.Printf("rpc: bind accepted [%s] %s -> %s", network, host, route)
+		return f.bindHandshake(ctx, route, addr)
+	}
 	log.Printf("rpc: proxy accepted [%s] %s -> %s", network, host, route)
 
 	// dial to target
-	f.Conn, err = route.Dial(network, addr)
+	conn, err := route.Dial(network, addr)
 	if err != nil {
 		_ = f.Stream.Send(&proto.ProxyDST{
 			Status: proto.ProxyStatus_Error,
@@ -202,6 +228,42 @@ func (f *Forwarder) handshake() error {
 		// Keep the dial error text from net (includes host); outer log adds target once.
 		return fmt.Errorf("dial: %w", err)
 	}
+	f.Conn = f.counter.Conn(f.limiter.Conn(conn))
+	return nil
+}
+
+// bindHandshake listens for a connection from addr on the client's behalf.
+// The listening address goes back in an Accepted header right away; the peer
+// address follows in the usual one once the peer connected. Of the server's
+// egresses only direct can take connections in.
+func (f *Forwarder) bindHandshake(ctx context.Context, route transport.Transport, addr string) error {
+	if _, ok := route.(transport.Binder); !ok || isUDPNetwork(f.network) {
+		_ = f.Stream.Send(&proto.ProxyDST{
+			Status: proto.ProxyStatus_Error,
+		})
+		return fmt.Errorf("bind: not supported by %s over %s", route, f.network)
+	}
+
+	ln, boundAddr, err := transport.ListenBind(addr)
+	if err != nil {
+		_ = f.Stream.Send(&proto.ProxyDST{
+			Status: proto.ProxyStatus_Error,
+		})
+		return err
+	}
+	if err = f.sendAccepted(boundAddr); err != nil {
+		_ = ln.Close()
+		return err
+	}
+	conn, err := transport.AcceptBind(ctx, ln, addr)
+	if err != nil {
+		_ = f.Stream.Send(&proto.ProxyDST{
+			Status: proto.ProxyStatus_Error,
+		})
+		return err
+	}
+	f.bind = true
+	f.Conn = f.counter.Conn(f.limiter.Conn(conn))
 	return nil
 }
 
@@ -209,7 +271,7 @@ func (f *Forwarder) CopyClientToTarget(ctx context.Context) error {
 	defer close(f.Ack)
 
 	// do the handshake first — return as-is (no "handshake error:" wrapper).
-	if err := f.handshake(); err != nil {
+	if err := f.handshake(ctx); err != nil {
 		return err
 	}
 
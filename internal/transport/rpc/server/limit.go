@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	config "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+	"golang.org/x/time/rate"
+)
+
+// bandwidthLimiter throttles one user's traffic with token buckets. A single
+// instance is shared by every stream the user has open, so the configured cap
+// applies to the user as a whole rather than per connection.
+type bandwidthLimiter struct {
+	limit config.Limit
+	up    *rate.Limiter // client -> target, nil when unlimited
+	down  *rate.Limiter // target -> client, nil when unlimited
+	total *rate.Limiter // both directions, nil when unlimited
+}
+
+// newBandwidthLimiter returns nil when l imposes no cap, so unlimited users
+// skip the wrapping entirely.
+func newBandwidthLimiter(l *config.Limit) *bandwidthLimiter {
+	if l.Unlimited() {
+		return nil
+	}
+	return &bandwidthLimiter{
+		limit: *l,
+		up:    newKBpsLimiter(l.UpLink),
+		down:  newKBpsLimiter(l.DownLink),
+		total: newKBpsLimiter(l.Bandwidth),
+	}
+}
+
+// newKBpsLimiter builds a bucket refilled at kbps KB/s that holds one second of
+// traffic, letting a stream that was idle burst briefly before it is paced.
+func newKBpsLimiter(kbps uint) *rate.Limiter {
+	if kbps == 0 {
+		return nil
+	}
+	bytesPerSecond := int(kbps) * 1024
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), bytesPerSecond)
+}
+
+func (b *bandwidthLimiter) waitUp(ctx context.Context, n int) error {
+	if err := waitN(ctx, b.up, n); err != nil {
+		return err
+	}
+	return waitN(ctx, b.total, n)
+}
+
+func (b *bandwidthLimiter) waitDown(ctx context.Context, n int) error {
+	if err := waitN(ctx, b.down, n); err != nil {
+		return err
+	}
+	return waitN(ctx, b.total, n)
+}
+
+// waitN blocks until n tokens are available. WaitN rejects requests larger than
+// the burst outright, and a payload chunk (a full transport buffer, or a 64K
+// datagram) may well exceed one second of a low cap, so take it in pieces.
+func waitN(ctx context.Context, l *rate.Limiter, n int) error {
+	if l == nil {
+		return nil
+	}
+	for n > 0 {
+		chunk := min(n, l.Burst())
+		if err := l.WaitN(ctx, chunk); err != nil {
+			return err
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+// Conn wraps the target connection of a stream so both copy directions are
+// paced by the user's buckets. Closing the returned conn aborts a pending wait,
+// which is how forwarder cancellation interrupts a throttled copy.
+func (b *bandwidthLimiter) Conn(conn net.Conn) net.Conn {
+	if b == nil {
+		return conn
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &limitedConn{Conn: conn, limiter: b, ctx: ctx, cancel: cancel}
+}
+
+type limitedConn struct {
+	net.Conn
+	limiter   *bandwidthLimiter
+	ctx       context.Context
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Read charges the bytes after they arrive: how much a Read returns is not
+// known in advance, and delaying the next Read paces the stream just the same.
+func (c *limitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if waitErr := c.limiter.waitDown(c.ctx, n); waitErr != nil && err == nil {
+			err = net.ErrClosed
+		}
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	if err := c.limiter.waitUp(c.ctx, len(p)); err != nil {
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *limitedConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.closeErr = c.Conn.Close()
+	})
+	return c.closeErr
+}
+
+// userLimiters maps a user id to its shared limiter. Users without a limit are
+// absent, so a lookup miss means unlimited.
+type userLimiters map[string]*bandwidthLimiter
+
+// newUserLimiters builds the limiters for users. A user whose limit is the same
+// as in prev keeps its limiter, so streams admitted before a reload keep
+// sharing one bucket with those admitted after it.
+func newUserLimiters(users config.Users, prev userLimiters) userLimiters {
+	limiters := make(userLimiters)
+	for _, user := range users {
+		if user == nil || user.Limit.Unlimited() {
+			continue
+		}
+		if l, ok := prev[user.UUID]; ok && l.limit == *user.Limit {
+			limiters[user.UUID] = l
+			continue
+		}
+		limiters[user.UUID] = newBandwidthLimiter(user.Limit)
+	}
+	return limiters
+}
+
+// Get returns the limiter for uid, or nil when the user is unlimited.
+func (m userLimiters) Get(uid string) *bandwidthLimiter {
+	return m[uid]
+}
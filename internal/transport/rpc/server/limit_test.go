@@ -0,0 +1,141 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	config "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+)
+
+func TestNewUserLimitersSkipsUnlimited(t *testing.T) {
+	limiters := newUserLimiters(config.Users{
+		{UUID: "free"},
+		{UUID: "zero", Limit: &config.Limit{}},
+		{UUID: "capped", Limit: &config.Limit{UpLink: 1}},
+		nil,
+	}, nil)
+	if len(limiters) != 1 {
+		t.Fatalf("limiters = %d, want 1", len(limiters))
+	}
+	if limiters.Get("free") != nil || limiters.Get("zero") != nil {
+		t.Fatal("unlimited users got a limiter")
+	}
+	if limiters.Get("capped") == nil {
+		t.Fatal("capped user has no limiter")
+	}
+}
+
+func TestNewUserLimitersKeepsUnchangedLimiters(t *testing.T) {
+	prev := newUserLimiters(config.Users{
+		{UUID: "same", Limit: &config.Limit{UpLink: 1}},
+		{UUID: "changed", Limit: &config.Limit{UpLink: 1}},
+	}, nil)
+	next := newUserLimiters(config.Users{
+		{UUID: "same", Limit: &config.Limit{UpLink: 1}},
+		{UUID: "changed", Limit: &config.Limit{UpLink: 2}},
+	}, prev)
+	if next.Get("same") != prev.Get("same") {
+		t.Error("unchanged limit got a new bucket")
+	}
+	if next.Get("changed") == prev.Get("changed") {
+		t.Error("changed limit kept the old bucket")
+	}
+}
+
+func TestBandwidthLimiterNilConnPassesThrough(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	var l *bandwidthLimiter
+	if got := l.Conn(c1); got != c1 {
+		t.Fatal("nil limiter wrapped the connection")
+	}
+}
+
+// The uplink bucket holds one second of traffic, so writing three seconds'
+// worth must take about two seconds beyond the initial burst.
+func TestLimitedConnPacesUpload(t *testing.T) {
+	const kbps = 64
+	l := newBandwidthLimiter(&config.Limit{UpLink: kbps})
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	conn := l.Conn(c1)
+	defer conn.Close()
+	go func() { _, _ = io.Copy(io.Discard, c2) }()
+
+	payload := make([]byte, kbps*1024/4)
+	start := time.Now()
+	for i := 0; i < 6; i++ { // 1.5s of traffic: 1s burst + 0.5s paced
+		if _, err := conn.Write(payload); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("upload finished in %v, want it paced to about 500ms", elapsed)
+	}
+}
+
+// Two streams of the same user draw from one bucket.
+func TestBandwidthLimiterSharedAcrossConns(t *testing.T) {
+	const kbps = 64
+	l := newBandwidthLimiter(&config.Limit{Bandwidth: kbps})
+
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		c1, c2 := net.Pipe()
+		defer c2.Close()
+		conn := l.Conn(c1)
+		defer conn.Close()
+		go func() { _, _ = io.Copy(io.Discard, c2) }()
+		conns = append(conns, conn)
+	}
+
+	payload := make([]byte, kbps*1024/4)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		for _, conn := range conns {
+			if _, err := conn.Write(payload); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Fatalf("combined upload finished in %v, want the shared cap to pace it", elapsed)
+	}
+}
+
+func TestLimitedConnCloseAbortsWait(t *testing.T) {
+	l := newBandwidthLimiter(&config.Limit{UpLink: 1})
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	conn := l.Conn(c1)
+	go func() { _, _ = io.Copy(io.Discard, c2) }()
+
+	// Drain the burst so the next write has to wait for several seconds.
+	if _, err := conn.Write(make([]byte, 1024)); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 8*1024))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_ = conn.Close()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, net.ErrClosed) {
+			t.Fatalf("Write() error = %v, want net.ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close did not abort the throttled write")
+	}
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	config "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+)
+
+// userPolicy is how the traffic of a user is routed apart from the route
+// table everyone shares.
+type userPolicy struct {
+	user  *config.User
+	named *router.Policy
+	// forward stands in for the forward egress.
+	forward router.Egress
+	// routes are consulted before the shared table, nil when the user has
+	// neither routes nor a named policy.
+	routes *router.Policy
+}
+
+// userPolicies maps a user id to its policy. Users routed like everyone else
+// are absent.
+type userPolicies map[string]*userPolicy
+
+// newUserPolicies builds the policies of users, looking named policies up by
+// policy. A user unchanged since prev, its named policy included, keeps its
+// policy rather than having its routes prepared again.
+func newUserPolicies(users config.Users, prev userPolicies, policy func(string) (*router.Policy, bool)) (userPolicies, error) {
+	policies := make(userPolicies)
+	for _, user := range users {
+		if user == nil || (user.Forward == "" && len(user.Routes) == 0 && user.Policy == "") {
+			continue
+		}
+		var named *router.Policy
+		if user.Policy != "" {
+			var ok bool
+			if named, ok = policy(user.Policy); !ok {
+				return nil, fmt.Errorf("user %s: unknown policy %q", user.UUID, user.Policy)
+			}
+		}
+		if p, ok := prev[user.UUID]; ok && p.user == user && p.named == named {
+			policies[user.UUID] = p
+			continue
+		}
+
+		p := &userPolicy{user: user, forward: router.Egress(user.Forward), named: named, routes: named}
+		if len(user.Routes) > 0 {
+			routes, err := router.NewPolicy(user.Routes, named)
+			if err != nil {
+				return nil, fmt.Errorf("user %s: %w", user.UUID, err)
+			}
+			p.routes = routes
+		}
+		policies[user.UUID] = p
+	}
+	return policies, nil
+}
+
+// Get returns the policy of uid, or nil when the user has none.
+func (m userPolicies) Get(uid string) *userPolicy {
+	return m[uid]
+}
+
+// route returns the transport for traffic to host at port. A nil policy
+// routes by the shared table alone.
+func (p *userPolicy) route(host string, port uint16) (transport.Transport, error) {
+	if p == nil {
+		return router.GetRoute(host, port)
+	}
+	var egress router.Egress
+	var err error
+	if p.routes != nil {
+		egress, err = p.routes.GetEgress(host, port)
+	} else {
+		egress, err = router.GetEgress(host, port)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if egress == router.EgressForward && p.forward != "" {
+		egress = p.forward
+	}
+	return egress.GetTransportFor(host)
+}
+
+// validatePolicy checks the outbound and the policy named by user exist, and
+// its routes are those a config accepts of a user.
+func validatePolicy(user *config.User) error {
+	if user.Forward != "" && !router.Egress(user.Forward).Declared() {
+		return fmt.Errorf("user %s: unknown forward %q", user.UUID, user.Forward)
+	}
+	if _, ok := router.GetPolicy(user.Policy); user.Policy != "" && !ok {
+		return fmt.Errorf("user %s: unknown policy %q", user.UUID, user.Policy)
+	}
+	if err := router.CheckNestedRoutes(user.Routes, router.Egress.Declared); err != nil {
+		return fmt.Errorf("user %s: %w", user.UUID, err)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	config "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+)
+
+// namedOutbound hands out a transport reporting the outbound name.
+type namedOutbound string
+
+func (o namedOutbound) Transport(string) (transport.Transport, error) {
+	return namedTransport{name: string(o)}, nil
+}
+
+func (namedOutbound) SupportsUDP() bool {
+	return false
+}
+
+type namedTransport struct {
+	transport.Transport
+	name string
+}
+
+func (t namedTransport) String() string {
+	return t.name
+}
+
+func TestNewUserPoliciesSkipsUsersWithoutOne(t *testing.T) {
+	policies, err := newUserPolicies(config.Users{
+		{UUID: "plain"},
+		{UUID: "corp", Forward: "corp-proxy"},
+		nil,
+	}, nil, router.GetPolicy)
+	if err != nil {
+		t.Fatalf("newUserPolicies() error = %v", err)
+	}
+	if len(policies) != 1 || policies.Get("plain") != nil {
+		t.Fatalf("policies = %v, want corp only", policies)
+	}
+	if p := policies.Get("corp"); p == nil || p.forward != "corp-proxy" {
+		t.Fatalf("corp policy = %+v", p)
+	}
+}
+
+func TestUserPolicyReplacesForward(t *testing.T) {
+	router.SetOutbounds(map[string]router.Outbound{"corp-proxy": namedOutbound("corp-proxy")})
+	t.Cleanup(func() { router.SetOutbounds(nil) })
+	if err := router.SetRoutes(router.Routes{
+		{MatchType: router.TypeExact, Sources: []string{"partner.example"}, Destination: router.EgressForward},
+		{MatchType: router.TypeDefault, Destination: router.EgressDirect},
+	}); err != nil {
+		t.Fatalf("SetRoutes() error = %v", err)
+	}
+
+	policy := &userPolicy{forward: "corp-proxy"}
+	for _, tt := range []struct {
+		policy *userPolicy
+		host   string
+		want   string
+	}{
+		{policy, "partner.example", "corp-proxy"},
+		{policy, "other.example", "direct"},
+		{nil, "partner.example", "forward"},
+	} {
+		route, err := tt.policy.route(tt.host, 443)
+		if err != nil {
+			t.Fatalf("route(%s) error = %v", tt.host, err)
+		}
+		if got := route.String(); got != tt.want {
+			t.Errorf("policy %v: route(%s) = %s, want %s", tt.policy, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePolicyRejectsUnknownForward(t *testing.T) {
+	router.SetOutbounds(nil)
+	if err := validatePolicy(&config.User{UUID: "u", Forward: "nowhere"}); err == nil {
+		t.Fatal("validatePolicy() accepted an undeclared outbound")
+	}
+	if err := validatePolicy(&config.User{UUID: "u", Forward: "forward"}); err != nil {
+		t.Fatalf("validatePolicy() error = %v", err)
+	}
+}
+
+func TestValidatePolicyRejectsBadRoutes(t *testing.T) {
+	router.SetOutbounds(nil)
+	for _, routes := range []router.Routes{
+		{{MatchType: router.TypeDefault, Destination: "nope"}},
+		{{MatchType: router.TypeDomain, URL: "https://example.com/ads.list", Destination: router.EgressBlock}},
+	} {
+		if err := validatePolicy(&config.User{UUID: "u", Routes: routes}); err == nil {
+			t.Errorf("validatePolicy() accepted routes %+v", routes[0])
+		}
+	}
+	routes := router.Routes{{MatchType: router.TypeDefault, Destination: router.EgressBlock}}
+	if err := validatePolicy(&config.User{UUID: "u", Routes: routes}); err != nil {
+		t.Fatalf("validatePolicy() error = %v", err)
+	}
+}
+
+func TestUserPolicyRoutesBeforeSharedTable(t *testing.T) {
+	if err := router.SetRoutes(router.Routes{{MatchType: router.TypeDefault, Destination: router.EgressDirect}}); err != nil {
+		t.Fatalf("SetRoutes() error = %v", err)
+	}
+	if err := router.SetPolicies(map[string]router.Routes{
+		"guest": {{MatchType: router.TypeCIDR, Sources: []string{"10.0.0.0/8"}, Destination: router.EgressBlock}},
+	}); err != nil {
+		t.Fatalf("SetPolicies() error = %v", err)
+	}
+	t.Cleanup(func() { _ = router.SetPolicies(nil) })
+
+	policies, err := newUserPolicies(config.Users{
+		{UUID: "guest", Policy: "guest"},
+		{UUID: "admin", Policy: "guest", Routes: router.Routes{
+			{MatchType: router.TypeCIDR, Sources: []string{"10.1.0.0/16"}, Destination: router.EgressBlackHole},
+		}},
+	}, nil, router.GetPolicy)
+	if err != nil {
+		t.Fatalf("newUserPolicies() error = %v", err)
+	}
+
+	for _, tt := range []struct {
+		uid, host string
+		want      string // transport name, empty for blocked
+	}{
+		{"guest", "10.1.2.3", ""},
+		{"admin", "10.1.2.3", "blackHole"},
+		{"admin", "10.9.9.9", ""},
+		{"guest", "example.com", "direct"},
+		{"nobody", "10.1.2.3", "direct"},
+	} {
+		route, err := policies.Get(tt.uid).route(tt.host, 443)
+		if tt.want == "" {
+			if !errors.Is(err, transport.ErrBlocked) {
+				t.Errorf("%s: route(%s) error = %v, want blocked", tt.uid, tt.host, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: route(%s) error = %v", tt.uid, tt.host, err)
+		}
+		if got := route.String(); got != tt.want {
+			t.Errorf("%s: route(%s) = %s, want %s", tt.uid, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestNewUserPoliciesKeepsUnchangedUsers(t *testing.T) {
+	if err := router.SetPolicies(map[string]router.Routes{"guest": nil}); err != nil {
+		t.Fatalf("SetPolicies() error = %v", err)
+	}
+	t.Cleanup(func() { _ = router.SetPolicies(nil) })
+
+	user := &config.User{UUID: "admin", Policy: "guest", Routes: router.Routes{{MatchType: router.TypeDefault, Destination: router.EgressDirect}}}
+	prev, err := newUserPolicies(config.Users{user}, nil, router.GetPolicy)
+	if err != nil {
+		t.Fatalf("newUserPolicies() error = %v", err)
+	}
+	next, err := newUserPolicies(config.Users{user}, prev, router.GetPolicy)
+	if err != nil {
+		t.Fatalf("newUserPolicies() error = %v", err)
+	}
+	if next.Get("admin") != prev.Get("admin") {
+		t.Error("unchanged user got its routes prepared again")
+	}
+
+	if err = router.SetPolicies(map[string]router.Routes{"guest": nil}); err != nil {
+		t.Fatalf("SetPolicies() error = %v", err)
+	}
+	next, err = newUserPolicies(config.Users{user}, prev, router.GetPolicy)
+	if err != nil {
+		t.Fatalf("newUserPolicies() error = %v", err)
+	}
+	if next.Get("admin") == prev.Get("admin") {
+		t.Error("user kept the policy it had before the named one changed")
+	}
+}
+
+func TestNewUserPoliciesRejectsUnknownPolicy(t *testing.T) {
+	_ = router.SetPolicies(nil)
+	if _, err := newUserPolicies(config.Users{{UUID: "u", Policy: "nowhere"}}, nil, router.GetPolicy); err == nil {
+		t.Fatal("newUserPolicies() accepted an unknown policy")
+	}
+}
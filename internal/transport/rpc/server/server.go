@@ -8,6 +8,8 @@ import (
 	"io"
 	"log"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
@@ -27,10 +29,20 @@ import (
 
 type Server struct {
 	proto.UnimplementedProxyServer
-	Ctx       context.Context
-	srv       *grpc.Server
-	dnsAddr   string
-	dnsClient *mdns.Client
+	Ctx        context.Context
+	srv        *grpc.Server
+	dnsConfig  *dns.DNS
+	resolver   dns.Client
+	accounting *accounting
+	sessions   *sessions
+
+	// usersMu serializes user list updates; the derived lookups are swapped
+	// atomically so the auth interceptor never takes a lock.
+	usersMu  sync.Mutex
+	userList config.Users
+	users    atomic.Pointer[config.UsersMatchMap]
+	limiters atomic.Pointer[userLimiters]
+	policies atomic.Pointer[userPolicies]
 }
 
 func buildTLSConfig(certFile, keyFile string) (*tls.Config, error) {
@@ -70,24 +82,32 @@ func NewServer(ctx context.Context, users config.Users, ssl *config.SSL, dnsConf
 		transportOption = grpc.Creds(insecure.NewCredentials())
 	}
 
-	dnsAddr := "8.8.8.8:53" // default to google dns
-	if dnsConfig != nil {
-		dnsAddr = dnsConfig.Address()
+	if dnsConfig == nil {
+		dnsConfig = &dns.DNS{Type: dns.TypeCommon, Server: "8.8.8.8"} // default to google dns
+	}
+	resolver, err := dnsConfig.NewClient()
+	if err != nil {
+		return nil, fmt.Errorf("setup dns: %w", err)
+	}
+
+	wrapper := &Server{
+		Ctx:        ctx,
+		dnsConfig:  dnsConfig,
+		resolver:   resolver,
+		accounting: newAccounting(nil),
+		sessions:   newSessions(),
+	}
+	if err := wrapper.SetUsers(users); err != nil {
+		return nil, err
 	}
 
 	// create grpc server and register
-	matchMap := users.ToMatchMap()
 	s := grpc.NewServer(append(rpc.ServerOptions(),
 		transportOption,
-		grpc.UnaryInterceptor(rpc.UnaryServerAuthInterceptor(matchMap.Match)),
-		grpc.StreamInterceptor(rpc.StreamServerAuthInterceptor(matchMap.Match)),
+		grpc.UnaryInterceptor(rpc.UnaryServerAuthInterceptor(wrapper.matchUser)),
+		grpc.StreamInterceptor(rpc.StreamServerAuthInterceptor(wrapper.matchUser)),
 	)...)
-	wrapper := &Server{
-		Ctx:       ctx,
-		srv:       s,
-		dnsAddr:   dnsAddr,
-		dnsClient: &mdns.Client{Timeout: DNSClientTimeout},
-	}
+	wrapper.srv = s
 
 	// Use dynamic proxy server registration for configurable service names
 	dynamicServer := NewDynamicProxyServer(wrapper)
@@ -96,6 +116,12 @@ func NewServer(ctx context.Context, users config.Users, ssl *config.SSL, dnsConf
 	return wrapper, nil
 }
 
+// LoadState restores per-user traffic counters from path and keeps them
+// persisted there while the server runs.
+func (s *Server) LoadState(path string) error {
+	return s.accounting.Load(path)
+}
+
 // ListenAndServe starts the server at the given address
 func (s *Server) ListenAndServe(addr string) error {
 	listener, err := net.Listen("tcp", addr)
@@ -105,6 +131,22 @@ func (s *Server) ListenAndServe(addr string) error {
 	defer utils.Close(listener)
 	log.Printf("rpc: listening at %s", addr)
 
+	// user management and traffic accounting, exposed to the management api
+	// while serving
+	defer setActiveServer(s)()
+
+	// persist accounting until serving stops, with a final save on the way out
+	saveDone := make(chan struct{})
+	saved := make(chan struct{})
+	go func() {
+		s.accounting.saveLoop(saveDone)
+		close(saved)
+	}()
+	defer func() {
+		close(saveDone)
+		<-saved
+	}()
+
 	serveDone := make(chan struct{})
 	go func() {
 		select {
@@ -134,15 +176,33 @@ func (s *Server) ListenAndServe(addr string) error {
 
 func (s *Server) Proxy(stream proto.Proxy_ProxyServer) error {
 	//log.Println("rpc server incomes")
-	// cancel forwarder
-	ctx, cancel := context.WithCancel(s.Ctx)
-	defer cancel()
+	// cancel forwarder, with errUserRevoked as the cause on revocation
+	ctx, cancel := context.WithCancelCause(s.Ctx)
+	defer cancel(nil)
 
 	// create forwarder
 	f := NewForwarder(ctx, stream)
 	defer utils.Close(f)
+	if uid, ok := rpc.UserIDFromContext(stream.Context()); ok {
+		if err := s.accounting.CheckQuota(uid); err != nil {
+			log.Printf("rpc: proxy rejected for %s: %v", uid, err)
+			return err
+		}
+		f.limiter = s.limiters.Load().Get(uid)
+		f.policy = s.policies.Load().Get(uid)
+		f.counter = s.accounting.counter(uid)
+		defer s.sessions.add(uid, cancel)()
+		// a revocation between auth and registration would miss this stream
+		if !s.matchUser(uid) {
+			return status.Error(codes.PermissionDenied, errUserRevoked.Error())
+		}
+	}
 
-	if err := f.Start(); err != nil && err != io.EOF && !errors.Is(err, context.Canceled) {
+	err := f.Start()
+	if errors.Is(context.Cause(ctx), errUserRevoked) {
+		return status.Error(codes.PermissionDenied, errUserRevoked.Error())
+	}
+	if err != nil && err != io.EOF && !errors.Is(err, context.Canceled) {
 		if ev, ok := status.FromError(err); ok {
 			if ev.Code() == codes.Canceled {
 				return nil
@@ -162,13 +222,21 @@ func (s *Server) Proxy(stream proto.Proxy_ProxyServer) error {
 	})
 }
 
-func (s *Server) DnsResolve(_ context.Context, request *proto.DnsRequest) (*proto.DnsResponse, error) {
+func (s *Server) DnsResolve(ctx context.Context, request *proto.DnsRequest) (*proto.DnsResponse, error) {
 	// Auth is handled by the server interceptor.
+	if uid, ok := rpc.UserIDFromContext(ctx); ok {
+		if err := s.accounting.CheckQuota(uid); err != nil {
+			return nil, err
+		}
+	}
+
 	// Validate request
-	if request == nil || len(request.Items) == 0 {
+	if request == nil {
 		return nil, transport.ErrBadRequest
 	}
 
+	// A request without items is a health probe from the client, answered
+	// as is.
 	resp := new(proto.DnsResponse)
 
 	for _, item := range request.Items {
@@ -196,9 +264,23 @@ func (s *Server) DnsResolve(_ context.Context, request *proto.DnsRequest) (*prot
 			continue
 		}
 
+		edns, err := rpcutils.ConvertProtoToOPT(item.Edns)
+		if err != nil {
+			log.Printf("dns: invalid EDNS record for %s: %v", item.Fqdn, err)
+			result.Rcode = mdns.RcodeFormatError
+			resp.Result = append(resp.Result, result)
+			continue
+		}
+
 		// Perform actual DNS resolution using configured DNS server
-		records, rcode := s.resolveDNSRecords(item.Fqdn, qtype)
+		records, rcode, respEdns := s.resolveDNSRecords(ctx, item.Fqdn, qtype, edns)
 		result.Rcode = uint32(rcode)
+		if respEdns != nil {
+			if result.Edns, err = rpcutils.ConvertRRToProto(respEdns); err != nil {
+				log.Printf("dns: failed to convert EDNS record for %s: %v", item.Fqdn, err)
+				result.Rcode = mdns.RcodeServerFailure
+			}
+		}
 
 		// Filter out IPv6 (AAAA) records if blocking is enabled
 		if item.BlockIpv6 {
@@ -27,6 +27,48 @@ func TestNewServerEmptyUsers(t *testing.T) {
 	}
 }
 
+func TestServerSetUsers(t *testing.T) {
+	s, err := NewServer(context.Background(), config.Users{
+		{UUID: "old", Limit: &config.Limit{UpLink: 1}},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := s.limiters.Load().Get("old")
+
+	if err = s.SetUsers(nil); err == nil {
+		t.Fatal("SetUsers accepted empty users")
+	}
+	if !s.matchUser("old") {
+		t.Fatal("a rejected SetUsers changed the accepted users")
+	}
+
+	err = s.SetUsers(config.Users{
+		{UUID: "old", Limit: &config.Limit{UpLink: 1}},
+		{UUID: "new", Quota: 1},
+	})
+	if err != nil {
+		t.Fatalf("SetUsers() error = %v", err)
+	}
+	if !s.matchUser("old") || !s.matchUser("new") {
+		t.Fatal("users not swapped in")
+	}
+	if s.limiters.Load().Get("old") != limiter {
+		t.Error("unchanged limit lost its bucket on swap")
+	}
+	s.accounting.counter("new").addUp(quotaUnit)
+	if err = s.accounting.CheckQuota("new"); err == nil {
+		t.Error("quota of an added user not enforced")
+	}
+
+	if err = s.SetUsers(config.Users{{UUID: "new"}}); err != nil {
+		t.Fatalf("SetUsers() error = %v", err)
+	}
+	if s.matchUser("old") {
+		t.Error("removed user still accepted")
+	}
+}
+
 func TestNewServerAndListenCancel(t *testing.T) {
 	if err := router.SetRoutes(router.Routes{
 		{MatchType: router.TypeDefault, Destination: router.EgressDirect},
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"sync"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	config "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+)
+
+var (
+	ErrNotRunning   = errors.New("rpc server is not running")
+	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound = errors.New("user not found")
+	ErrLastUser     = errors.New("can not remove the last user")
+
+	errUserRevoked = errors.New("user revoked")
+)
+
+var (
+	activeMu     sync.RWMutex
+	activeServer *Server
+)
+
+func getActiveServer() *Server {
+	activeMu.RLock()
+	defer activeMu.RUnlock()
+	return activeServer
+}
+
+// setActiveServer makes s the running server until the returned func is
+// called.
+func setActiveServer(s *Server) (unset func()) {
+	activeMu.Lock()
+	activeServer = s
+	activeMu.Unlock()
+	return func() {
+		activeMu.Lock()
+		if activeServer == s {
+			activeServer = nil
+		}
+		activeMu.Unlock()
+	}
+}
+
+// UserInfo describes a user accepted by the running server.
+type UserInfo struct {
+	*config.User
+	// Streams is the number of proxy streams the user has open.
+	Streams int `json:"streams"`
+}
+
+// ListUsers returns the users of the running server sorted by user id.
+func ListUsers() ([]UserInfo, error) {
+	s := getActiveServer()
+	if s == nil {
+		return nil, ErrNotRunning
+	}
+	return s.ListUsers(), nil
+}
+
+// AddUser adds a user to the running server.
+func AddUser(user *config.User) error {
+	s := getActiveServer()
+	if s == nil {
+		return ErrNotRunning
+	}
+	return s.AddUser(user)
+}
+
+// RemoveUser revokes a user of the running server, see Server.RemoveUser.
+func RemoveUser(uid string, terminate bool) (int, error) {
+	s := getActiveServer()
+	if s == nil {
+		return 0, ErrNotRunning
+	}
+	return s.RemoveUser(uid, terminate)
+}
+
+// SetUsers atomically replaces the accepted users along with their bandwidth
+// limits and quotas. Streams already admitted are left running; a removed
+// user is only refused on its next request.
+func (s *Server) SetUsers(users config.Users) error {
+	apply, err := s.PrepareUsers(users, router.GetPolicy)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PrepareUsers checks users can replace the accepted ones, with named
+// policies looked up by policy, and returns the func that replaces them like
+// SetUsers. Nothing is replaced on error.
+func (s *Server) PrepareUsers(users config.Users, policy func(name string) (*router.Policy, bool)) (func(), error) {
+	if len(users) == 0 {
+		return nil, errors.New("users can not be empty")
+	}
+	users = slices.Clone(users)
+	var prev userPolicies
+	if p := s.policies.Load(); p != nil {
+		prev = *p
+	}
+	policies, err := newUserPolicies(users, prev, policy)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		s.usersMu.Lock()
+		defer s.usersMu.Unlock()
+		s.storeUsersLocked(users, policies)
+	}, nil
+}
+
+// AddUser accepts a new user from its next request on. Users added at runtime
+// are not written back to the config, so a reload drops them.
+func (s *Server) AddUser(user *config.User) error {
+	if user == nil || user.UUID == "" {
+		return errors.New("user id can not be empty")
+	}
+	if err := validatePolicy(user); err != nil {
+		return err
+	}
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	if s.users.Load().Match(user.UUID) {
+		return ErrUserExists
+	}
+	if err := s.applyUsersLocked(append(slices.Clone(s.userList), user)); err != nil {
+		return err
+	}
+	log.Printf("rpc: user %s added", user.UUID)
+	return nil
+}
+
+// RemoveUser stops accepting uid. With terminate set, the user's open proxy
+// streams are cancelled as well, otherwise they run until they end on their
+// own. It returns the number of streams terminated.
+func (s *Server) RemoveUser(uid string, terminate bool) (int, error) {
+	s.usersMu.Lock()
+	defer s.usersMu.Unlock()
+	i := slices.IndexFunc(s.userList, func(u *config.User) bool {
+		return u != nil && u.UUID == uid
+	})
+	if i < 0 {
+		return 0, ErrUserNotFound
+	}
+	if len(s.userList) == 1 {
+		return 0, ErrLastUser
+	}
+	if err := s.applyUsersLocked(slices.Delete(slices.Clone(s.userList), i, i+1)); err != nil {
+		return 0, err
+	}
+
+	var n int
+	if terminate {
+		n = s.sessions.revoke(uid)
+	}
+	log.Printf("rpc: user %s removed, %d streams terminated", uid, n)
+	return n, nil
+}
+
+// ListUsers returns the accepted users sorted by user id.
+func (s *Server) ListUsers() []UserInfo {
+	s.usersMu.Lock()
+	users := slices.Clone(s.userList)
+	s.usersMu.Unlock()
+
+	out := make([]UserInfo, 0, len(users))
+	for _, user := range users {
+		if user != nil {
+			out = append(out, UserInfo{User: user, Streams: s.sessions.count(user.UUID)})
+		}
+	}
+	slices.SortFunc(out, func(x, y UserInfo) int {
+		return cmp.Compare(x.UUID, y.UUID)
+	})
+	return out
+}
+
+func (s *Server) applyUsersLocked(users config.Users) error {
+	var prevPolicies userPolicies
+	if p := s.policies.Load(); p != nil {
+		prevPolicies = *p
+	}
+	policies, err := newUserPolicies(users, prevPolicies, router.GetPolicy)
+	if err != nil {
+		return err
+	}
+	s.storeUsersLocked(users, policies)
+	return nil
+}
+
+// storeUsersLocked makes users, routed by policies, the accepted ones.
+// usersMu must be held.
+func (s *Server) storeUsersLocked(users config.Users, policies userPolicies) {
+	var prev userLimiters
+	if p := s.limiters.Load(); p != nil {
+		prev = *p
+	}
+	limiters := newUserLimiters(users, prev)
+	if len(limiters) > 0 {
+		log.Printf("bandwidth limits applied to %d users", len(limiters))
+	}
+
+	s.userList = users
+	s.users.Store(users.ToMatchMap())
+	s.limiters.Store(&limiters)
+	s.policies.Store(&policies)
+	s.accounting.SetUsers(users)
+}
+
+func (s *Server) matchUser(id string) bool {
+	return s.users.Load().Match(id)
+}
+
+// sessions tracks the open proxy streams of each user so they can be
+// terminated when the user is revoked.
+type sessions struct {
+	mu     sync.Mutex
+	byUser map[string]map[*session]struct{}
+}
+
+type session struct {
+	cancel context.CancelCauseFunc
+}
+
+func newSessions() *sessions {
+	return &sessions{byUser: make(map[string]map[*session]struct{})}
+}
+
+// add registers a stream of uid and returns the function that unregisters it.
+func (s *sessions) add(uid string, cancel context.CancelCauseFunc) func() {
+	entry := &session{cancel: cancel}
+	s.mu.Lock()
+	set, ok := s.byUser[uid]
+	if !ok {
+		set = make(map[*session]struct{})
+		s.byUser[uid] = set
+	}
+	set[entry] = struct{}{}
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if set := s.byUser[uid]; set != nil {
+			delete(set, entry)
+			if len(set) == 0 {
+				delete(s.byUser, uid)
+			}
+		}
+	}
+}
+
+// revoke cancels every stream of uid and returns how many there were.
+func (s *sessions) revoke(uid string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	set := s.byUser[uid]
+	delete(s.byUser, uid)
+	for entry := range set {
+		entry.cancel(errUserRevoked)
+	}
+	return len(set)
+}
+
+func (s *sessions) count(uid string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.byUser[uid])
+}
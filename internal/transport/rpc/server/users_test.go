@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	config "github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+)
+
+func TestServerAddRemoveUser(t *testing.T) {
+	s, err := NewServer(context.Background(), config.Users{{UUID: "a"}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.AddUser(&config.User{}); err == nil {
+		t.Error("AddUser accepted an empty id")
+	}
+	if err = s.AddUser(&config.User{UUID: "a"}); !errors.Is(err, ErrUserExists) {
+		t.Errorf("AddUser() duplicate = %v, want ErrUserExists", err)
+	}
+	if err = s.AddUser(&config.User{UUID: "b"}); err != nil {
+		t.Fatalf("AddUser() error = %v", err)
+	}
+	if !s.matchUser("b") {
+		t.Fatal("added user not accepted")
+	}
+	if got := s.ListUsers(); len(got) != 2 || got[0].UUID != "a" || got[1].UUID != "b" {
+		t.Fatalf("ListUsers() = %+v, want a, b", got)
+	}
+
+	if _, err = s.RemoveUser("missing", false); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("RemoveUser() missing = %v, want ErrUserNotFound", err)
+	}
+	if _, err = s.RemoveUser("a", false); err != nil {
+		t.Fatalf("RemoveUser() error = %v", err)
+	}
+	if s.matchUser("a") {
+		t.Error("removed user still accepted")
+	}
+	if _, err = s.RemoveUser("b", false); !errors.Is(err, ErrLastUser) {
+		t.Errorf("RemoveUser() last = %v, want ErrLastUser", err)
+	}
+}
+
+func TestServerPrepareUsers(t *testing.T) {
+	s, err := NewServer(context.Background(), config.Users{{UUID: "a"}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guest, err := router.NewPolicy(router.Routes{{MatchType: router.TypeDefault, Destination: router.EgressBlock}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := func(name string) (*router.Policy, bool) {
+		return guest, name == "guest"
+	}
+
+	if _, err = s.PrepareUsers(config.Users{{UUID: "b", Policy: "admin"}}, policy); err == nil {
+		t.Fatal("PrepareUsers() accepted an unknown policy")
+	}
+	// the policy is looked up by the func rather than among the installed ones
+	apply, err := s.PrepareUsers(config.Users{{UUID: "b", Policy: "guest"}}, policy)
+	if err != nil {
+		t.Fatalf("PrepareUsers() error = %v", err)
+	}
+	if !s.matchUser("a") || s.matchUser("b") {
+		t.Fatal("PrepareUsers() replaced the users before they were applied")
+	}
+	apply()
+	if s.matchUser("a") || !s.matchUser("b") {
+		t.Fatal("prepared users not applied")
+	}
+	if p := s.policies.Load().Get("b"); p == nil || p.named != guest {
+		t.Errorf("policy of b = %+v, want guest", p)
+	}
+}
+
+func TestSessionsRevoke(t *testing.T) {
+	ss := newSessions()
+	ctx1, cancel1 := context.WithCancelCause(context.Background())
+	ctx2, cancel2 := context.WithCancelCause(context.Background())
+	done1 := ss.add("u", cancel1)
+	done2 := ss.add("other", cancel2)
+	defer done2()
+
+	if n := ss.count("u"); n != 1 {
+		t.Fatalf("count() = %d, want 1", n)
+	}
+	if n := ss.revoke("u"); n != 1 {
+		t.Fatalf("revoke() = %d, want 1", n)
+	}
+	if !errors.Is(context.Cause(ctx1), errUserRevoked) {
+		t.Errorf("revoked stream cause = %v, want errUserRevoked", context.Cause(ctx1))
+	}
+	if ctx2.Err() != nil {
+		t.Error("revoke cancelled another user's stream")
+	}
+	done1() // unregistering after revocation must be harmless
+	if n := ss.count("u"); n != 0 {
+		t.Errorf("count() after revoke = %d, want 0", n)
+	}
+}
+
+func TestActiveServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s, err := NewServer(ctx, config.Users{{UUID: "a"}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ListUsers(); !errors.Is(err, ErrNotRunning) {
+		t.Fatalf("ListUsers() before serving = %v, want ErrNotRunning", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_ = s.ListenAndServe("127.0.0.1:0")
+		close(done)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, err = ListUsers(); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ListUsers() while serving = %v", err)
+		}
+	}
+
+	cancel()
+	<-done
+	if _, err = GetUserTraffic(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("GetUserTraffic() after serving = %v, want ErrNotRunning", err)
+	}
+	if err = AddUser(&config.User{UUID: "b"}); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("AddUser() after serving = %v, want ErrNotRunning", err)
+	}
+}
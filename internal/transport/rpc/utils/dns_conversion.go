@@ -55,6 +55,25 @@ func ConvertProtoToRR(protoRR *proto.RR_Record) (dns.RR, error) {
 	return rr, nil
 }
 
+// ConvertProtoToOPT converts a protobuf RR_Record carrying an EDNS0 OPT record
+// back to a dns.OPT. A nil record yields a nil OPT.
+func ConvertProtoToOPT(protoRR *proto.RR_Record) (*dns.OPT, error) {
+	if protoRR == nil {
+		return nil, nil
+	}
+
+	rr, err := ConvertProtoToRR(protoRR)
+	if err != nil {
+		return nil, err
+	}
+	opt, ok := rr.(*dns.OPT)
+	if !ok {
+		return nil, fmt.Errorf("got %s record, want OPT", GetRRTypeString(rr.Header().Rrtype))
+	}
+
+	return opt, nil
+}
+
 // ConvertRRSliceToProto converts a slice of dns.RR to protobuf RR_Records.
 func ConvertRRSliceToProto(rrs []dns.RR) ([]*proto.RR_Record, error) {
 	if len(rrs) == 0 {
@@ -0,0 +1,35 @@
+package tun
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
+	"gvisor.dev/gvisor/pkg/tcpip/link/tun"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// openDevice returns an endpoint over the device fd, or over the device name
+// opened here and closed by the returned func.
+func openDevice(fd int, name string, mtu uint32) (stack.LinkEndpoint, func(), error) {
+	closeDevice := func() {}
+	if fd <= 0 {
+		if name == "" {
+			return nil, nil, errors.New("no device fd or name")
+		}
+		var err error
+		if fd, err = tun.Open(name); err != nil {
+			return nil, nil, err
+		}
+		closeDevice = func() { _ = unix.Close(fd) }
+	}
+	ep, err := fdbased.New(&fdbased.Options{
+		FDs: []int{fd},
+		MTU: mtu,
+	})
+	if err != nil {
+		closeDevice()
+		return nil, nil, err
+	}
+	return ep, closeDevice, nil
+}
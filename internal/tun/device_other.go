@@ -0,0 +1,9 @@
+//go:build !linux
+
+package tun
+
+import "gvisor.dev/gvisor/pkg/tcpip/stack"
+
+func openDevice(int, string, uint32) (stack.LinkEndpoint, func(), error) {
+	return nil, nil, ErrUnsupported
+}
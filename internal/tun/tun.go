@@ -0,0 +1,301 @@
+// Package tun terminates the TCP and UDP flows of a TUN device in a userspace
+// network stack and dispatches each through the router like the SOCKS5 and
+// HTTP inbounds, for devices where no system proxy can be set.
+package tun
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	DefaultMTU = 1500
+	nicID      = 1
+	// tcpMaxInFlight bounds the handshakes being routed at once; further
+	// SYNs are dropped and retried by the sender.
+	tcpMaxInFlight  = 2048
+	udpIdleTimeout  = 2 * time.Minute
+	maxUDPPacketLen = 65535
+)
+
+var ErrUnsupported = errors.New("tun: only supported on Linux")
+
+type Config struct {
+	// FD is an already opened TUN device, as handed out by mobile platforms.
+	// It is used as is and left for the caller to close.
+	FD int
+	// Name is the device to open when FD is not set.
+	Name string
+	// MTU defaults to DefaultMTU.
+	MTU uint32
+}
+
+type Server struct {
+	ctx      context.Context
+	config   *Config
+	getRoute func(router.Source, string, uint16) (transport.Transport, error)
+
+	mu        sync.Mutex
+	stack     *stack.Stack
+	closeOnce sync.Once
+}
+
+func New(ctx context.Context, cfg *Config) *Server {
+	return &Server{
+		ctx:      ctx,
+		config:   cfg,
+		getRoute: router.GetRouteFrom,
+	}
+}
+
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		log.Println("tun: shutting down")
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.stack != nil {
+			// Stack.Close leaves the NIC reading the device, which may
+			// outlive the server when the caller owns it
+			_ = s.stack.RemoveNIC(nicID)
+			s.stack.Close()
+		}
+	})
+	return nil
+}
+
+// Serve runs the stack over the device until the context is done.
+func (s *Server) Serve() error {
+	mtu := s.config.MTU
+	if mtu == 0 {
+		mtu = DefaultMTU
+	}
+	ep, closeDevice, err := openDevice(s.config.FD, s.config.Name, mtu)
+	if err != nil {
+		return fmt.Errorf("tun: %w", err)
+	}
+	defer closeDevice()
+	if err = s.start(ep); err != nil {
+		return err
+	}
+	log.Printf("tun: serving %s (mtu %d)", deviceName(s.config), mtu)
+
+	<-s.ctx.Done()
+	utils.Close(s)
+	return s.ctx.Err()
+}
+
+func deviceName(cfg *Config) string {
+	if cfg.FD > 0 {
+		return "fd " + strconv.Itoa(cfg.FD)
+	}
+	return cfg.Name
+}
+
+// start attaches a stack to ep that takes every flow in, whatever its
+// destination.
+func (s *Server) start(ep stack.LinkEndpoint) error {
+	st := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol, ipv6.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+	sack := tcpip.TCPSACKEnabled(true)
+	st.SetTransportProtocolOption(tcp.ProtocolNumber, &sack)
+
+	st.SetTransportProtocolHandler(tcp.ProtocolNumber, tcp.NewForwarder(st, 0, tcpMaxInFlight, s.handleTCP).HandlePacket)
+	st.SetTransportProtocolHandler(udp.ProtocolNumber, udp.NewForwarder(st, s.handleUDP).HandlePacket)
+
+	if err := st.CreateNIC(nicID, ep); err != nil {
+		st.Close()
+		return fmt.Errorf("tun: create nic: %s", err)
+	}
+	// accept packets for any address, and answer from it
+	if err := st.SetPromiscuousMode(nicID, true); err != nil {
+		st.Close()
+		return fmt.Errorf("tun: promiscuous mode: %s", err)
+	}
+	if err := st.SetSpoofing(nicID, true); err != nil {
+		st.Close()
+		return fmt.Errorf("tun: spoofing: %s", err)
+	}
+	st.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: nicID},
+		{Destination: header.IPv6EmptySubnet, NIC: nicID},
+	})
+
+	s.mu.Lock()
+	s.stack = st
+	s.mu.Unlock()
+	return nil
+}
+
+// endpointDst is the destination a flow was headed to.
+func endpointDst(id stack.TransportEndpointID) netip.AddrPort {
+	addr, _ := netip.AddrFromSlice(id.LocalAddress.AsSlice())
+	return netip.AddrPortFrom(addr.Unmap(), id.LocalPort)
+}
+
+// endpointSrc is the app a flow came from.
+func endpointSrc(id stack.TransportEndpointID) netip.Addr {
+	addr, _ := netip.AddrFromSlice(id.RemoteAddress.AsSlice())
+	return addr.Unmap()
+}
+
+// route picks the egress of traffic from src to dst; a fake address routes
+// by its name.
+func (s *Server) route(src netip.Addr, dst netip.AddrPort) (transport.Transport, string, error) {
+	host := fakeip.Resolve(dst.Addr().String())
+	route, err := s.getRoute(router.Source{Inbound: router.InboundTun, Addr: src}, host, dst.Port())
+	if err != nil {
+		return nil, "", fmt.Errorf("no route for %s: %w", host, err)
+	}
+	return route, net.JoinHostPort(host, strconv.Itoa(int(dst.Port()))), nil
+}
+
+// handleTCP routes a connection before completing its handshake, so one
+// that cannot be routed is reset.
+func (s *Server) handleTCP(r *tcp.ForwarderRequest) {
+	dst := endpointDst(r.ID())
+	route, addr, err := s.route(endpointSrc(r.ID()), dst)
+	if err != nil {
+		log.Printf("tun: %v", err)
+		r.Complete(true)
+		return
+	}
+	defer utils.Close(route)
+
+	var wq waiter.Queue
+	ep, terr := r.CreateEndpoint(&wq)
+	if terr != nil {
+		log.Printf("tun: %s: create endpoint: %s", addr, terr)
+		r.Complete(true)
+		return
+	}
+	r.Complete(false)
+	conn := gonet.NewTCPConn(&wq, ep)
+	defer utils.Close(conn)
+
+	log.Printf("tun: %s -> %s", addr, route)
+
+	localAddr := make(chan string)
+	go func() {
+		// the local address has no one to be reported to
+		for range localAddr {
+		}
+	}()
+	if err = route.Proxy(s.ctx, addr, localAddr, conn, conn); err != nil &&
+		!errors.Is(err, io.EOF) && !errors.Is(err, context.Canceled) {
+		log.Printf("tun: %s failed: %v", addr, err)
+	}
+}
+
+// handleUDP opens a session for the first datagram of a flow. It runs on the
+// packet path, so the dial happens elsewhere.
+func (s *Server) handleUDP(r *udp.ForwarderRequest) bool {
+	src, dst := endpointSrc(r.ID()), endpointDst(r.ID())
+	var wq waiter.Queue
+	ep, terr := r.CreateEndpoint(&wq)
+	if terr != nil {
+		log.Printf("tun: udp %s: create endpoint: %s", dst, terr)
+		return true
+	}
+	conn := gonet.NewUDPConn(&wq, ep)
+	go func() {
+		defer utils.Close(conn)
+		if err := s.serveUDP(conn, src, dst); err != nil {
+			log.Printf("tun: udp %s: %v", dst, err)
+		}
+	}()
+	return true
+}
+
+// serveUDP relays a flow until it has been idle for udpIdleTimeout in both
+// directions.
+func (s *Server) serveUDP(conn *gonet.UDPConn, src netip.Addr, dst netip.AddrPort) error {
+	route, addr, err := s.route(src, dst)
+	if err != nil {
+		return err
+	}
+	defer utils.Close(route)
+	// the egress must carry UDP itself; sending from a local socket instead
+	// would bypass the tunnel
+	pd, ok := route.(transport.PacketDialer)
+	if !ok {
+		return fmt.Errorf("egress %s does not support UDP", route)
+	}
+	out, err := pd.DialPacket("udp", addr)
+	if err != nil {
+		return fmt.Errorf("dial packet: %w", err)
+	}
+	defer utils.Close(out)
+	log.Printf("tun: udp %s -> %s", addr, route)
+
+	var lastSeen atomic.Int64
+	lastSeen.Store(time.Now().UnixNano())
+	idle := func(err error) bool {
+		ne, ok := errors.AsType[net.Error](err)
+		return !ok || !ne.Timeout() || time.Since(time.Unix(0, lastSeen.Load())) >= udpIdleTimeout
+	}
+
+	go func() {
+		defer utils.Close(out)
+		buf := make([]byte, maxUDPPacketLen)
+		target := net.UDPAddrFromAddrPort(dst)
+		for {
+			_ = conn.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+			n, err := conn.Read(buf)
+			if err != nil {
+				if idle(err) {
+					return
+				}
+				continue
+			}
+			lastSeen.Store(time.Now().UnixNano())
+			nw, err := out.WriteTo(buf[:n], target)
+			if err != nil {
+				return
+			}
+			transport.GlobalStats.AddTx(uint64(nw))
+		}
+	}()
+
+	buf := make([]byte, maxUDPPacketLen)
+	for {
+		_ = out.SetReadDeadline(time.Now().Add(udpIdleTimeout))
+		n, _, err := out.ReadFrom(buf)
+		if err != nil {
+			if idle(err) {
+				return nil
+			}
+			continue
+		}
+		lastSeen.Store(time.Now().UnixNano())
+		nw, err := conn.Write(buf[:n])
+		if err != nil {
+			return nil
+		}
+		transport.GlobalStats.AddRx(uint64(nw))
+	}
+}
@@ -0,0 +1,159 @@
+package tun
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/dns/fakeip"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/direct"
+	"golang.org/x/sys/unix"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/adapters/gonet"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/fdbased"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+// newDevice serves a socket pair end as the TUN device and returns a stack
+// on the other end, standing in for the apps behind the device.
+func newDevice(t *testing.T) *stack.Stack {
+	t.Helper()
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = unix.Close(fds[0])
+		_ = unix.Close(fds[1])
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := New(ctx, &Config{FD: fds[0]})
+	s.getRoute = func(router.Source, string, uint16) (transport.Transport, error) { return direct.New(), nil }
+	done := make(chan error, 1)
+	go func() { done <- s.Serve() }()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	ep, err := fdbased.New(&fdbased.Options{FDs: []int{fds[1]}, MTU: DefaultMTU})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+	t.Cleanup(func() {
+		_ = app.RemoveNIC(nicID)
+		app.Close()
+	})
+	if err := app.CreateNIC(nicID, ep); err != nil {
+		t.Fatal(err)
+	}
+	addr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddrFrom4([4]byte{10, 0, 0, 2}).WithPrefix(),
+	}
+	if err := app.AddProtocolAddress(nicID, addr, stack.AddressProperties{}); err != nil {
+		t.Fatal(err)
+	}
+	app.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+	return app
+}
+
+// fakeTarget maps the loopback address of a local server to a fake address,
+// which the app dials through the device.
+func fakeTarget(t *testing.T, addr string) tcpip.FullAddress {
+	t.Helper()
+	pool, err := fakeip.New(fakeip.DefaultRange, "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeip.SetDefault(pool)
+	t.Cleanup(func() { fakeip.SetDefault(nil) })
+
+	ap := netip.MustParseAddrPort(addr)
+	fake, _ := pool.Get(ap.Addr().String(), false)
+	return tcpip.FullAddress{NIC: nicID, Addr: tcpip.AddrFrom4(fake.As4()), Port: ap.Port()}
+}
+
+func TestServeRelaysTCP(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		conn, err := echo.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _ = io.Copy(conn, conn)
+	}()
+
+	app := newDevice(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := gonet.DialContextTCP(ctx, app, fakeTarget(t, echo.Addr().String()), ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("dial through the device: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("echo = %q, %v", buf, err)
+	}
+}
+
+func TestServeRelaysUDP(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	app := newDevice(t)
+	target := fakeTarget(t, echo.LocalAddr().String())
+	conn, err := gonet.DialUDP(app, nil, &target, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatalf("dial through the device: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "ping" {
+		t.Fatalf("echo = %q, %v", buf[:n], err)
+	}
+}
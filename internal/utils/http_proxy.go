@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// httpProxy dials through an HTTP proxy with the CONNECT method, over TLS
+// for the https scheme.
+type httpProxy struct {
+	addr       string
+	serverName string // TLS server name, empty for plain http
+	auth       string // Proxy-Authorization value
+}
+
+func newHTTPProxy(u *url.URL) *httpProxy {
+	p := &httpProxy{addr: u.Host}
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		p.addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	if u.Scheme == "https" {
+		p.serverName = u.Hostname()
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		p.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+password))
+	}
+	return p
+}
+
+func (p *httpProxy) Dial(network, addr string) (net.Conn, error) {
+	return p.DialContext(context.Background(), network, addr)
+}
+
+// DialContext asks the proxy to connect to addr. ctx bounds the whole
+// exchange, not the connection returned.
+func (p *httpProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("http proxy: unsupported network %s", network)
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	// interrupt the exchange once ctx is done
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	if p.serverName != "" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: p.serverName})
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			Close(conn)
+			return nil, fmt.Errorf("http proxy: tls handshake: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if p.auth != "" {
+		req.Header.Set("Proxy-Authorization", p.auth)
+	}
+	if err = req.Write(conn); err != nil {
+		Close(conn)
+		return nil, fmt.Errorf("http proxy: %w", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		Close(conn)
+		return nil, fmt.Errorf("http proxy: %w", err)
+	}
+	Close(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		Close(conn)
+		return nil, fmt.Errorf("http proxy: connect %s: %s", addr, resp.Status)
+	}
+
+	if !stop() {
+		// ctx ended meanwhile and poisoned the deadline
+		Close(conn)
+		return nil, ctx.Err()
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads what the proxy sent after its response first.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
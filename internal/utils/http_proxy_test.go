@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// startConnectProxy serves one CONNECT, answering with status and echoing
+// the tunnel when it is 200. The request seen is sent on the channel.
+func startConnectProxy(t *testing.T, status int) (string, <-chan *http.Request) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Close(ln) })
+
+	requests := make(chan *http.Request, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer Close(conn)
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		requests <- req
+		if _, err = fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\n\r\n", status, http.StatusText(status)); err != nil {
+			return
+		}
+		if status != http.StatusOK {
+			return
+		}
+		_, _ = io.Copy(conn, br)
+	}()
+	return ln.Addr().String(), requests
+}
+
+func TestLoadProxyHTTPConnect(t *testing.T) {
+	addr, requests := startConnectProxy(t, http.StatusOK)
+
+	d, err := LoadProxy("http://user:secret@" + addr)
+	if err != nil {
+		t.Fatalf("LoadProxy() error = %v", err)
+	}
+	conn, err := d.Dial("tcp", "example.com:443")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer Close(conn)
+
+	req := <-requests
+	if req.Method != http.MethodConnect || req.Host != "example.com:443" {
+		t.Fatalf("request = %s %s, want CONNECT example.com:443", req.Method, req.Host)
+	}
+	if user, password, ok := parseProxyAuth(req); !ok || user != "user" || password != "secret" {
+		t.Fatalf("Proxy-Authorization = %q", req.Header.Get("Proxy-Authorization"))
+	}
+
+	if _, err = io.WriteString(conn, "ping"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("tunnel echoed %q, %v", buf, err)
+	}
+}
+
+func TestLoadProxyHTTPConnectRefused(t *testing.T) {
+	addr, _ := startConnectProxy(t, http.StatusForbidden)
+
+	d, err := LoadProxy("http://" + addr)
+	if err != nil {
+		t.Fatalf("LoadProxy() error = %v", err)
+	}
+	if _, err = d.Dial("tcp", "example.com:443"); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("Dial() error = %v, want the proxy status", err)
+	}
+}
+
+func parseProxyAuth(req *http.Request) (string, string, bool) {
+	r := &http.Request{Header: http.Header{"Authorization": req.Header["Proxy-Authorization"]}}
+	return r.BasicAuth()
+}
@@ -27,11 +27,26 @@ func SplitHostPort(s string) (string, uint16, error) {
 	return host, uint16(port), nil
 }
 
+// ParsePort returns the port number s holds, zero if it holds none.
+func ParsePort(s string) uint16 {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0
+	}
+	return uint16(port)
+}
+
+// LoadProxy returns a dialer for the proxy at URL p: socks5, socks5h, http
+// or https.
 func LoadProxy(p string) (proxy.Dialer, error) {
 	u, err := url.Parse(p)
 	if err != nil {
 		return nil, err
 	}
+	switch u.Scheme {
+	case "http", "https":
+		return newHTTPProxy(u), nil
+	}
 	return proxy.FromURL(u, nil)
 }
 
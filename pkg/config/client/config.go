@@ -2,20 +2,26 @@ package client
 
 import (
 	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	"github.com/SuzukiHonoka/spaceship/v2/pkg/dns"
 )
 
 type Client struct {
-	ServerAddr      string        `json:"server_addr"`
-	Host            string        `json:"host,omitempty"`
-	UUID            string        `json:"uuid"` // user id
-	ListenSocks     string        `json:"listen_socks,omitempty"`
-	ListenSocksUnix string        `json:"listen_socks_unix,omitempty"`
-	ListenHttp      string        `json:"listen_http,omitempty"`
-	ListenDns       string        `json:"listen_dns,omitempty"`
-	BasicAuth       []string      `json:"basic_auth,omitempty"` // user:password
-	Mux             uint8         `json:"mux"`                  // 0 -> disabled, n (>0) -> limited connection
-	EnableTLS       bool          `json:"tls"`
-	Routes          router.Routes `json:"route,omitempty"`
+	ServerAddr      string `json:"server_addr"`
+	Host            string `json:"host,omitempty"`
+	UUID            string `json:"uuid"` // user id
+	ListenSocks     string `json:"listen_socks,omitempty"`
+	ListenSocksUnix string `json:"listen_socks_unix,omitempty"`
+	ListenHttp      string `json:"listen_http,omitempty"`
+	ListenDns       string `json:"listen_dns,omitempty"`
+	// ListenRedir takes TCP connections the Linux firewall diverted with the
+	// REDIRECT target; ListenTProxy takes TCP and UDP diverted with TPROXY,
+	// which needs CAP_NET_ADMIN.
+	ListenRedir  string        `json:"listen_redir,omitempty"`
+	ListenTProxy string        `json:"listen_tproxy,omitempty"`
+	BasicAuth    []string      `json:"basic_auth,omitempty"` // user:password
+	Mux          uint8         `json:"mux"`                  // 0 -> disabled, n (>0) -> limited connection
+	EnableTLS    bool          `json:"tls"`
+	Routes       router.Routes `json:"route,omitempty"`
 	// IdleTimeout is gRPC connection idle timeout in seconds.
 	// For decoded JSON, omission keeps the transport default and explicit 0
 	// disables the timeout. The int type is retained for source compatibility.
@@ -24,6 +30,80 @@ type Client struct {
 	// UDP tunes the SOCKS5 UDP ASSOCIATE relay. Omit the whole section to keep
 	// UDP enabled with built-in defaults.
 	UDP *UDP `json:"udp,omitempty"`
+	// DNSCache tunes the answer cache of the listen_dns server. Omit the whole
+	// section to keep caching enabled with built-in defaults.
+	DNSCache *DNSCache `json:"dns_cache,omitempty"`
+	// DNSDirect is the resolver the listen_dns server asks for names routed
+	// direct, so they resolve to nodes near the client. It defaults to the
+	// top-level dns; with neither set those names go through the tunnel.
+	DNSDirect *dns.DNS `json:"dns_direct,omitempty"`
+	// DNSBlock is how the listen_dns server answers names routed to block or
+	// blackhole: "nxdomain" (default) or "zero_ip".
+	DNSBlock string `json:"dns_block,omitempty"`
+	// FakeIP makes the listen_dns server answer with addresses of a reserved
+	// range, which the inbounds map back to the name, so domain routes apply
+	// to apps that connect by address. Omit it to answer real addresses.
+	FakeIP *FakeIP `json:"fake_ip,omitempty"`
+	// Tun takes the traffic routed to a TUN device in. Mobile wrappers hand an
+	// opened device in through the api package instead.
+	Tun *Tun `json:"tun,omitempty"`
+	// Servers are further upstreams to fail over to. server_addr, if set,
+	// joins them with priority 0.
+	Servers []Server `json:"servers,omitempty"`
+	// Balance spreads new sessions across the servers: "failover" (default),
+	// "round_robin", "least_streams", "lowest_rtt" or "consistent_hash" by
+	// destination host.
+	Balance string `json:"balance,omitempty"`
+	// UpstreamProxy is a proxy the connections to the servers go through
+	// when they can not be reached directly: socks5://, http:// or https://,
+	// with user:password@ for authentication.
+	UpstreamProxy string `json:"upstream_proxy,omitempty"`
+}
+
+// Server is an upstream of the client. The healthy one with the lowest
+// priority carries new sessions.
+type Server struct {
+	Addr string `json:"addr"`
+	// Host overrides the TLS server name, host by default.
+	Host     string `json:"host,omitempty"`
+	Priority int    `json:"priority,omitempty"`
+}
+
+// Tun configures the TUN inbound. The device is set up and routed to by the
+// system; the client only terminates the flows read from it.
+type Tun struct {
+	// Name is the device to open, Linux only.
+	Name string `json:"name,omitempty"`
+	// MTU defaults to 1500.
+	MTU uint32 `json:"mtu,omitempty"`
+}
+
+// FakeIP configures the fake-IP mode of the client DNS server.
+type FakeIP struct {
+	// Range is the IPv4 range handed out, 198.18.0.0/15 by default.
+	Range string `json:"range,omitempty"`
+	// Range6 is the IPv6 range handed out. Without it AAAA questions get an
+	// empty answer, so apps fall back to IPv4.
+	Range6 string `json:"range6,omitempty"`
+	// Size bounds the names mapped at once in each range, 65535 by default.
+	// The least recently used mapping is recycled beyond it.
+	Size int `json:"size,omitempty"`
+}
+
+// DNSCache configures the answer cache of the client DNS server. TTLs are in
+// seconds; zero selects the built-in default.
+type DNSCache struct {
+	// Disable sends every query through the tunnel.
+	Disable bool `json:"disable,omitempty"`
+	// Size bounds the number of cached questions, 4096 by default.
+	Size int `json:"size,omitempty"`
+	// MinTTL raises shorter record TTLs; MaxTTL, one day by default, lowers
+	// longer ones.
+	MinTTL uint32 `json:"min_ttl,omitempty"`
+	MaxTTL uint32 `json:"max_ttl,omitempty"`
+	// NegativeTTL is how long NXDOMAIN and empty answers are cached, 30 by
+	// default.
+	NegativeTTL uint32 `json:"negative_ttl,omitempty"`
 }
 
 // UDP configures the SOCKS5 UDP ASSOCIATE relay. Every numeric field is
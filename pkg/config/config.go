@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"time"
@@ -21,6 +22,7 @@ import (
 	"github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
 	"github.com/SuzukiHonoka/spaceship/v2/pkg/dns"
 	"github.com/SuzukiHonoka/spaceship/v2/pkg/logger"
+	"golang.org/x/net/proxy"
 )
 
 // MixedConfig is a server/client mixed config, along with general config.
@@ -34,6 +36,8 @@ type MixedConfig struct {
 	CAs []string `json:"cas,omitempty"`
 	// LogMode is used for set up specific log mod, defaults to stdout.
 	LogMode logger.Mode `json:"log,omitempty"`
+	// Outbounds are destinations routes can name besides the built-in ones.
+	Outbounds []*Outbound `json:"outbounds,omitempty"`
 
 	*client.Client
 	*server.Server
@@ -110,32 +114,57 @@ func NewFromString(c string) (*MixedConfig, error) {
 
 // Apply applies the MixedConfig
 func (c *MixedConfig) Apply() error {
+	p, err := c.Prepare()
+	if err != nil {
+		return err
+	}
+	p.Apply()
+	return nil
+}
+
+// Prepared is a MixedConfig checked and built by Prepare, ready to be applied
+// without failing.
+type Prepared struct {
+	c                *MixedConfig
+	resolver         *net.Resolver
+	udpSettings      socks.UDPSettings
+	upstreamProxy    proxy.Dialer
+	forward          proxy.Dialer
+	guard            *transport.Guard
+	routes           *router.PreparedRoutes
+	policies         map[string]*router.Policy
+	applyIdleTimeout bool
+}
+
+// Prepare checks the MixedConfig and builds everything Apply needs, leaving
+// the running config as it is. A config rejected here changes nothing.
+func (c *MixedConfig) Prepare() (*Prepared, error) {
 	c.ensureEmbeddedConfigs()
+	p := &Prepared{c: c}
 
 	// role check
 	if c.Role != RoleClient && c.Role != RoleServer {
-		return fmt.Errorf("invalid role: %s", c.Role)
+		return nil, fmt.Errorf("invalid role: %s", c.Role)
 	}
 
-	applyIdleTimeout := !c.decodedFromJSON || c.idleTimeoutSet
-	idleTimeoutSeconds := int64(c.IdleTimeout)
-	if applyIdleTimeout {
+	p.applyIdleTimeout = !c.decodedFromJSON || c.idleTimeoutSet
+	if p.applyIdleTimeout {
+		idleTimeoutSeconds := int64(c.IdleTimeout)
 		if idleTimeoutSeconds < 0 {
-			return fmt.Errorf("idle_timeout must be non-negative: %d", c.IdleTimeout)
+			return nil, fmt.Errorf("idle_timeout must be non-negative: %d", c.IdleTimeout)
 		}
 		if idleTimeoutSeconds > maxIdleTimeoutSeconds {
-			return fmt.Errorf("idle_timeout exceeds maximum duration: %d", c.IdleTimeout)
+			return nil, fmt.Errorf("idle_timeout exceeds maximum duration: %d", c.IdleTimeout)
 		}
 	}
 
-	// log mode
-	c.LogMode.Set()
-
 	// dns
 	if c.DNS != nil {
-		if err := c.DNS.SetDefault(); err != nil {
-			return err
+		r, err := c.DNS.NewResolver()
+		if err != nil {
+			return nil, err
 		}
+		p.resolver = r
 	}
 
 	// custom buffer size
@@ -144,17 +173,12 @@ func (c *MixedConfig) Apply() error {
 		// oversized buffer exceeds the gRPC message limit and fails every send at
 		// runtime. Reject it at startup instead.
 		if bufferBytes := int(c.Buffer) * 1024; bufferBytes > rpc.MaxTransportBufferSize {
-			return fmt.Errorf("buffer too large: %dK exceeds maximum %dK",
+			return nil, fmt.Errorf("buffer too large: %dK exceeds maximum %dK",
 				c.Buffer, rpc.MaxTransportBufferSize/1024)
 		}
-		log.Printf("custom buffer size: %dK", c.Buffer)
-		transport.SetBufferSize(c.Buffer)
 	}
 
-	// socks5 udp associate relay. Applied unconditionally so a reload that drops
-	// the section restores defaults, the same way ipv6 is re-enabled below —
-	// otherwise a previously configured "disable" would silently persist.
-	var udpSettings socks.UDPSettings
+	// socks5 udp associate relay
 	if c.UDP != nil {
 		for _, limit := range []struct {
 			name  string
@@ -167,10 +191,10 @@ func (c *MixedConfig) Apply() error {
 			{"max_nat_entries_per_client", c.UDP.MaxNATEntriesPerClient},
 		} {
 			if limit.value < 0 {
-				return fmt.Errorf("udp.%s must be non-negative: %d", limit.name, limit.value)
+				return nil, fmt.Errorf("udp.%s must be non-negative: %d", limit.name, limit.value)
 			}
 		}
-		udpSettings = socks.UDPSettings{
+		p.udpSettings = socks.UDPSettings{
 			Disable:                  c.UDP.Disable,
 			MaxAssociations:          c.UDP.MaxAssociations,
 			MaxAssociationsPerClient: c.UDP.MaxAssociationsPerClient,
@@ -179,34 +203,41 @@ func (c *MixedConfig) Apply() error {
 			MaxNATEntriesPerClient:   c.UDP.MaxNATEntriesPerClient,
 		}
 	}
-	socks.SetUDPSettings(udpSettings)
-	if udpSettings.Disable {
-		log.Println("socks5 udp associate disabled")
-	}
 
-	// custom grpc service name
-	if c.Path != "" {
-		log.Printf("custom service name: %s", c.Path)
-		// Use the new RPC configuration system
-		rpc.SetServiceName(c.Path)
+	// client uuid
+	if c.Role == RoleClient && c.UUID == "" {
+		return nil, errors.New("client uuid empty")
 	}
 
-	// client uuid
-	if c.Role == RoleClient {
-		if c.UUID == "" {
-			return errors.New("client uuid empty")
+	// proxy in front of the spaceship servers
+	if c.UpstreamProxy != "" {
+		d, err := utils.LoadProxy(c.UpstreamProxy)
+		if err != nil {
+			return nil, fmt.Errorf("upstream proxy: %w", err)
 		}
-		rpcClient.SetUUID(c.UUID)
+		p.upstreamProxy = d
 	}
 
 	// forward proxy
 	if c.Forward != "" {
 		d, err := utils.LoadProxy(c.Forward)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		forward.Attach(d)
-		log.Println("forward-proxy attached")
+		p.forward = d
+	}
+
+	// destination guard of the server
+	if c.Role == RoleServer && (c.Guard == nil || !c.Guard.Disable) {
+		var allow, deny []string
+		if c.Guard != nil {
+			allow, deny = c.Guard.Allow, c.Guard.Deny
+		}
+		g, err := transport.NewGuard(allow, deny)
+		if err != nil {
+			return nil, err
+		}
+		p.guard = g
 	}
 
 	// Routes: empty list installs the role default. An explicit list is used as-is
@@ -225,13 +256,101 @@ func (c *MixedConfig) Apply() error {
 	if !c.IPv6 {
 		routes = append(router.Routes{router.RouteBlockIPv6}, routes...)
 	}
-	if err := router.SetRoutes(routes); err != nil {
-		return err
+	if err := c.validateOutbounds(routes); err != nil {
+		return nil, err
+	}
+	prepared, err := router.NewRoutes(routes)
+	if err != nil {
+		return nil, err
+	}
+	p.routes = prepared
+
+	// routing policies server users refer to, and the route lists of users
+	if c.Role == RoleServer {
+		if p.policies, err = router.NewPolicies(c.Policies); err != nil {
+			return nil, err
+		}
+		if err = c.validateUserRoutes(); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Policy returns the named policy of the prepared config, for the users of
+// the server to be prepared against before it is applied.
+func (p *Prepared) Policy(name string) (*router.Policy, bool) {
+	policy, ok := p.policies[name]
+	return policy, ok
+}
+
+// Apply makes the prepared config the running one.
+func (p *Prepared) Apply() {
+	c := p.c
+
+	// log mode
+	c.LogMode.Set()
+
+	// dns
+	if p.resolver != nil {
+		net.DefaultResolver = p.resolver
+	}
+
+	// custom buffer size
+	if c.Buffer > 0 {
+		log.Printf("custom buffer size: %dK", c.Buffer)
+		transport.SetBufferSize(c.Buffer)
+	} else {
+		// restore the default so a reload that drops the field takes effect
+		transport.SetBufferSize(transport.DefaultBufferSize)
+	}
+
+	// socks5 udp associate relay. Applied unconditionally so a reload that drops
+	// the section restores defaults, the same way ipv6 is re-enabled below —
+	// otherwise a previously configured "disable" would silently persist.
+	socks.SetUDPSettings(p.udpSettings)
+	if p.udpSettings.Disable {
+		log.Println("socks5 udp associate disabled")
+	}
+
+	// custom grpc service name
+	if c.Path != "" {
+		log.Printf("custom service name: %s", c.Path)
+		// Use the new RPC configuration system
+		rpc.SetServiceName(c.Path)
+	}
+
+	// client uuid
+	if c.Role == RoleClient {
+		rpcClient.SetUUID(c.UUID)
+	}
+
+	// proxy in front of the spaceship servers
+	if p.upstreamProxy != nil {
+		log.Println("upstream-proxy attached")
+	}
+	rpc.SetUpstreamProxy(p.upstreamProxy)
+
+	// forward proxy
+	if p.forward != nil {
+		forward.Attach(p.forward)
+		log.Println("forward-proxy attached")
+	}
+
+	router.InstallRoutes(p.routes)
+	if c.Role == RoleServer {
+		router.InstallPolicies(p.policies)
+	}
+
+	transport.SetGuard(p.guard)
+	if p.guard != nil {
+		log.Println("destination guard enabled")
+	} else if c.Role == RoleServer {
+		log.Println("warning: destination guard disabled; users can reach the server's own network")
 	}
 
 	// IPv6 dial preference must be set both ways so a later Apply/reload can
-	// re-enable dual-stack after a previous DisableIPv6. Route validation and
-	// installation happen first, so a rejected reload leaves this mode intact.
+	// re-enable dual-stack after a previous DisableIPv6.
 	if !c.IPv6 {
 		transport.DisableIPv6()
 		log.Println("ipv6 disabled")
@@ -240,13 +359,26 @@ func (c *MixedConfig) Apply() error {
 		log.Println("ipv6 enabled")
 	}
 
-	// idle timeout: only apply when the field is present in config.
-	// omitted -> keep transport default (30m); 0 -> disable; n > 0 -> n seconds.
-	if applyIdleTimeout {
+	// idle timeout: only apply a custom value when the field is present in config.
+	// omitted -> transport default (30m); 0 -> disable; n > 0 -> n seconds.
+	if p.applyIdleTimeout {
 		log.Printf("custom idle timeout: %ds", c.IdleTimeout)
-		transport.SetIdleTimeout(time.Duration(idleTimeoutSeconds) * time.Second)
+		transport.SetIdleTimeout(time.Duration(c.IdleTimeout) * time.Second)
+	} else {
+		transport.SetIdleTimeout(transport.DefaultIdleTimeout)
 	}
+}
 
+// validateUserRoutes checks the route lists of server users can be prepared.
+func (c *MixedConfig) validateUserRoutes() error {
+	for _, user := range c.Users {
+		if user == nil || len(user.Routes) == 0 {
+			continue
+		}
+		if _, err := router.NewPolicy(user.Routes, nil); err != nil {
+			return fmt.Errorf("user %s: %w", user.UUID, err)
+		}
+	}
 	return nil
 }
 
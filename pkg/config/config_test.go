@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
 	"os"
 	"strconv"
 	"testing"
@@ -201,7 +202,7 @@ func TestApply_ExplicitRoutesFailClosedWithoutDefault(t *testing.T) {
 	}
 
 	// Matched host uses the explicit exact rule (direct).
-	tr, err := router.GetRoute("example.com")
+	tr, err := router.GetRoute("example.com", 443)
 	if err != nil {
 		t.Fatalf("GetRoute(example.com) error = %v", err)
 	}
@@ -211,7 +212,7 @@ func TestApply_ExplicitRoutesFailClosedWithoutDefault(t *testing.T) {
 	_ = tr.Close()
 
 	// Unmatched host must fail closed — no auto-appended default.
-	_, err = router.GetRoute("other.example")
+	_, err = router.GetRoute("other.example", 443)
 	if err == nil {
 		t.Fatal("expected route not found for unmatched host without default")
 	}
@@ -230,7 +231,7 @@ func TestApply_EmptyRoutesInstallsRoleDefault(t *testing.T) {
 	if err := cfg.Apply(); err != nil {
 		t.Fatal(err)
 	}
-	tr, err := router.GetRoute("anything.example")
+	tr, err := router.GetRoute("anything.example", 443)
 	if err != nil {
 		t.Fatalf("empty routes should install server default: %v", err)
 	}
@@ -305,7 +306,7 @@ func TestApply_InvalidRoutesPreserveIPv6ModeAndLiveRoutes(t *testing.T) {
 		t.Fatal("failed reload changed IPv6 mode")
 	}
 
-	tr, err := router.GetRoute("still-live.example")
+	tr, err := router.GetRoute("still-live.example", 443)
 	if err != nil {
 		t.Fatalf("failed reload replaced live routes: %v", err)
 	}
@@ -449,6 +450,44 @@ func TestApply_UDPSettingsResetOnReload(t *testing.T) {
 	}
 }
 
+// TestApply_RejectedConfigChangesNothing verifies a config rejected by a
+// check late in Apply leaves the settings checked before it as they were.
+func TestApply_RejectedConfigChangesNothing(t *testing.T) {
+	t.Cleanup(func() {
+		transport.EnableIPv6()
+		transport.SetBufferSize(transport.DefaultBufferSize)
+		transport.SetIdleTimeout(transport.DefaultIdleTimeout)
+		socks.SetUDPSettings(socks.UDPSettings{})
+	})
+
+	cfg, err := NewFromString(`{"role":"client","log":"skip","uuid":"u"}`)
+	if err != nil {
+		t.Fatalf("NewFromString() error = %v", err)
+	}
+	if err = cfg.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	buffer := transport.GetBufferSize()
+
+	next, err := NewFromString(`{"role":"client","log":"skip","uuid":"u","buffer":64,"idle_timeout":5,
+		"udp":{"disable":true},"route":[{"dst":"nowhere","type":"default"}]}`)
+	if err != nil {
+		t.Fatalf("NewFromString() error = %v", err)
+	}
+	if err = next.Apply(); err == nil {
+		t.Fatal("Apply() accepted a route to an unknown destination")
+	}
+	if got := transport.GetBufferSize(); got != buffer {
+		t.Errorf("rejected config changed the buffer size to %d", got)
+	}
+	if got := transport.GetIdleTimeout(); got != transport.DefaultIdleTimeout {
+		t.Errorf("rejected config changed the idle timeout to %v", got)
+	}
+	if socks.UDPDisabled() {
+		t.Error("rejected config disabled udp")
+	}
+}
+
 func TestNewFromConfigFile(t *testing.T) {
 	t.Cleanup(transport.EnableIPv6)
 
@@ -518,3 +557,145 @@ func TestApply_NilEmbeddedConfigs(t *testing.T) {
 		t.Fatal("ensureEmbeddedConfigs did not populate Client")
 	}
 }
+
+func TestNewFromStringUserLimit(t *testing.T) {
+	// Lowercase keys are documented; the original field names must keep working
+	// for configs written before the fields were tagged.
+	cfg, err := NewFromString(`{"role":"server","users":[
+		{"uuid":"a","limit":{"downlink":1024,"uplink":256,"bandwidth":2048}},
+		{"uuid":"b","limit":{"DownLink":512}},
+		{"uuid":"c"}
+	]}`)
+	if err != nil {
+		t.Fatalf("NewFromString() error = %v", err)
+	}
+	want := server.Limit{DownLink: 1024, UpLink: 256, Bandwidth: 2048}
+	if got := cfg.Users[0].Limit; got == nil || *got != want {
+		t.Fatalf("user a limit = %+v, want %+v", got, want)
+	}
+	if got := cfg.Users[1].Limit; got == nil || got.DownLink != 512 {
+		t.Fatalf("user b limit = %+v, want downlink 512", got)
+	}
+	if !cfg.Users[2].Limit.Unlimited() {
+		t.Fatal("user without limit is not unlimited")
+	}
+}
+
+func TestPrepareReload(t *testing.T) {
+	running, err := NewFromString(`{"role":"server","listen":"127.0.0.1:1","buffer":16,
+		"users":[{"uuid":"kept"},{"uuid":"dropped"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := NewFromString(`{"role":"server","listen":"127.0.0.1:2","buffer":32,
+		"users":[{"uuid":"kept"},{"uuid":"added"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := running.PrepareReload(next)
+	if err != nil {
+		t.Fatalf("PrepareReload() error = %v", err)
+	}
+	if fmt.Sprint(changes.Applied) != "[buffer users]" {
+		t.Errorf("Applied = %v, want [buffer users]", changes.Applied)
+	}
+	if fmt.Sprint(changes.RestartRequired) != "[listen]" {
+		t.Errorf("RestartRequired = %v, want [listen]", changes.RestartRequired)
+	}
+	if fmt.Sprint(changes.UsersAdded) != "[added]" || fmt.Sprint(changes.UsersRemoved) != "[dropped]" {
+		t.Errorf("users added %v removed %v, want [added] / [dropped]", changes.UsersAdded, changes.UsersRemoved)
+	}
+	if next.Listen != "127.0.0.1:1" {
+		t.Errorf("next.Listen = %q, want the running value kept", next.Listen)
+	}
+}
+
+func TestPrepareReloadRejectsRoleChange(t *testing.T) {
+	running := &MixedConfig{Role: RoleServer}
+	next := &MixedConfig{Role: RoleClient}
+	if _, err := running.PrepareReload(next); err == nil {
+		t.Fatal("PrepareReload() accepted a role change")
+	}
+}
+
+func TestApply_BufferOmittedRestoresDefault(t *testing.T) {
+	t.Cleanup(func() {
+		transport.EnableIPv6()
+		transport.SetBufferSize(transport.DefaultBufferSize)
+	})
+
+	cfg, err := NewFromString(`{"role":"server","log":"skip","buffer":8}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Buffer = 0
+	if err = cfg.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if got := transport.GetBufferSize(); got != int(transport.DefaultBufferSize)*1024 {
+		t.Fatalf("GetBufferSize() = %d, want the default after the field is dropped", got)
+	}
+}
+
+func TestApply_UpstreamProxy(t *testing.T) {
+	t.Cleanup(transport.EnableIPv6)
+	t.Cleanup(func() { rpc.SetUpstreamProxy(nil) })
+
+	cfg, err := NewFromString(`{"role":"client","log":"skip","uuid":"u","upstream_proxy":"ftp://127.0.0.1:21"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Apply(); err == nil {
+		t.Fatal("Apply() accepted an upstream proxy of unknown scheme")
+	}
+
+	cfg, err = NewFromString(`{"role":"client","log":"skip","uuid":"u","upstream_proxy":"socks5://user:pw@127.0.0.1:1080"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+}
+
+func TestApply_Guard(t *testing.T) {
+	t.Cleanup(transport.EnableIPv6)
+	t.Cleanup(func() { transport.SetGuard(nil) })
+	loopback := netip.MustParseAddr("127.0.0.1")
+
+	cfg, err := NewFromString(`{"role":"server","log":"skip"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if g := transport.GetGuard(); g == nil || g.Check(loopback) == nil {
+		t.Fatal("server applied without guarding loopback by default")
+	}
+
+	cfg.Guard = &server.Guard{Allow: []string{"127.0.0.1"}}
+	if err = cfg.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if err = transport.GetGuard().Check(loopback); err != nil {
+		t.Fatalf("Check() of an allowed address = %v", err)
+	}
+
+	cfg.Guard = &server.Guard{Deny: []string{"not-a-prefix"}}
+	if err = cfg.Apply(); err == nil {
+		t.Fatal("Apply() accepted an invalid guard prefix")
+	}
+
+	cfg.Guard = &server.Guard{Disable: true}
+	if err = cfg.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if transport.GetGuard() != nil {
+		t.Fatal("guard still installed after being disabled")
+	}
+}
@@ -0,0 +1,209 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/direct"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport/forward"
+	rpcClient "github.com/SuzukiHonoka/spaceship/v2/internal/transport/rpc/client"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/utils"
+	"github.com/SuzukiHonoka/spaceship/v2/pkg/config/client"
+)
+
+const (
+	OutboundSpaceship = "spaceship"
+	OutboundForward   = "forward"
+	OutboundDirect    = "direct"
+)
+
+// Outbound declares a destination that routes reference by name.
+type Outbound struct {
+	Name string `json:"name"`
+	// Type is "spaceship", "forward" or "direct".
+	Type string `json:"type"`
+	// Servers, Balance, Host, TLS and Mux configure a spaceship outbound like
+	// the client fields of the same name. UUID defaults to the client uuid.
+	Servers   []client.Server `json:"servers,omitempty"`
+	Balance   string          `json:"balance,omitempty"`
+	UUID      string          `json:"uuid,omitempty"`
+	Host      string          `json:"host,omitempty"`
+	EnableTLS bool            `json:"tls,omitempty"`
+	Mux       uint8           `json:"mux,omitempty"`
+	// URL is the proxy of a forward outbound: socks5://, http:// or https://.
+	URL string `json:"url,omitempty"`
+	// Interface binds a direct outbound to a network interface, Linux only.
+	Interface string `json:"interface,omitempty"`
+}
+
+// validateOutbounds checks the outbounds are well-formed and every route,
+// those of server users and policies included, leads to a built-in egress or
+// one of them.
+func (c *MixedConfig) validateOutbounds(routes router.Routes) error {
+	names := make(map[router.Egress]bool, len(c.Outbounds))
+	for i, o := range c.Outbounds {
+		if o == nil {
+			return fmt.Errorf("outbound %d is nil", i)
+		}
+		name := router.Egress(o.Name)
+		switch {
+		case o.Name == "":
+			return fmt.Errorf("outbound %d: name empty", i)
+		case name.IsBuiltin():
+			return fmt.Errorf("outbound %s: name reserved", o.Name)
+		case names[name]:
+			return fmt.Errorf("outbound %s: name duplicated", o.Name)
+		}
+		names[name] = true
+
+		switch o.Type {
+		case OutboundSpaceship:
+			if len(o.Servers) == 0 {
+				return fmt.Errorf("outbound %s: servers empty", o.Name)
+			}
+			if o.UUID == "" && c.Role != RoleClient {
+				return fmt.Errorf("outbound %s: uuid empty", o.Name)
+			}
+			if _, err := rpcClient.ParseBalance(o.Balance); err != nil {
+				return fmt.Errorf("outbound %s: %w", o.Name, err)
+			}
+		case OutboundForward:
+			if o.URL == "" {
+				return fmt.Errorf("outbound %s: url empty", o.Name)
+			}
+		case OutboundDirect:
+		default:
+			return fmt.Errorf("outbound %s: unknown type %q", o.Name, o.Type)
+		}
+	}
+
+	declared := func(e router.Egress) bool {
+		return e.IsBuiltin() || names[e]
+	}
+	if err := router.CheckRoutes(routes, declared); err != nil {
+		return err
+	}
+	if c.Role != RoleServer {
+		return nil
+	}
+	for name, routes := range c.Policies {
+		if err := router.CheckNestedRoutes(routes, declared); err != nil {
+			return fmt.Errorf("policy %s: %w", name, err)
+		}
+	}
+	for _, user := range c.Users {
+		if user == nil {
+			continue
+		}
+		if user.Forward != "" && !declared(router.Egress(user.Forward)) {
+			return fmt.Errorf("user %s: unknown forward %q", user.UUID, user.Forward)
+		}
+		if _, ok := c.Policies[user.Policy]; user.Policy != "" && !ok {
+			return fmt.Errorf("user %s: unknown policy %q", user.UUID, user.Policy)
+		}
+		if err := router.CheckNestedRoutes(user.Routes, declared); err != nil {
+			return fmt.Errorf("user %s: %w", user.UUID, err)
+		}
+	}
+	return nil
+}
+
+// SetupOutbounds builds the outbounds and hands them to the router. The
+// returned func closes them.
+func (c *MixedConfig) SetupOutbounds() (func(), error) {
+	var groups []*rpcClient.Group
+	closeAll := func() {
+		router.SetOutbounds(nil)
+		for _, g := range groups {
+			g.Destroy()
+		}
+	}
+
+	outbounds := make(map[string]router.Outbound, len(c.Outbounds))
+	for _, o := range c.Outbounds {
+		switch o.Type {
+		case OutboundSpaceship:
+			g, err := o.newGroup(c.CAs)
+			if err != nil {
+				closeAll()
+				return nil, fmt.Errorf("outbound %s: %w", o.Name, err)
+			}
+			groups = append(groups, g)
+			outbounds[o.Name] = groupOutbound{g}
+		case OutboundForward:
+			d, err := utils.LoadProxy(o.URL)
+			if err != nil {
+				closeAll()
+				return nil, fmt.Errorf("outbound %s: %w", o.Name, err)
+			}
+			outbounds[o.Name] = transportOutbound{t: forward.NewDialer(d)}
+		case OutboundDirect:
+			t := direct.New()
+			if o.Interface != "" {
+				var err error
+				if t, err = direct.NewBound(o.Interface); err != nil {
+					closeAll()
+					return nil, fmt.Errorf("outbound %s: %w", o.Name, err)
+				}
+			}
+			outbounds[o.Name] = transportOutbound{t: t, udp: true}
+		default:
+			closeAll()
+			return nil, errors.New("outbounds not validated")
+		}
+		log.Printf("outbound %s: %s", o.Name, o.Type)
+	}
+	router.SetOutbounds(outbounds)
+	return closeAll, nil
+}
+
+func (o *Outbound) newGroup(cas []string) (*rpcClient.Group, error) {
+	balance, err := rpcClient.ParseBalance(o.Balance)
+	if err != nil {
+		return nil, err
+	}
+	upstreams := make([]rpcClient.Upstream, len(o.Servers))
+	for i, s := range o.Servers {
+		host := s.Host
+		if host == "" {
+			host = o.Host
+		}
+		upstreams[i] = rpcClient.Upstream{Addr: s.Addr, Host: host, Priority: s.Priority}
+	}
+	return rpcClient.NewGroup(upstreams, balance, o.UUID, o.EnableTLS, o.Mux, cas)
+}
+
+// groupOutbound checks a connection out of the pools of a spaceship
+// outbound per session.
+type groupOutbound struct {
+	*rpcClient.Group
+}
+
+func (o groupOutbound) Transport(dst string) (transport.Transport, error) {
+	c, err := o.New(dst)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (groupOutbound) SupportsUDP() bool {
+	return true
+}
+
+// transportOutbound shares a stateless transport between sessions.
+type transportOutbound struct {
+	t   transport.Transport
+	udp bool
+}
+
+func (o transportOutbound) Transport(string) (transport.Transport, error) {
+	return o.t, nil
+}
+
+func (o transportOutbound) SupportsUDP() bool {
+	return o.udp
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SuzukiHonoka/spaceship/v2/internal/router"
+	"github.com/SuzukiHonoka/spaceship/v2/internal/transport"
+)
+
+func TestApply_RoutesToNamedOutbounds(t *testing.T) {
+	t.Cleanup(transport.EnableIPv6)
+
+	cfg, err := NewFromString(`{
+		"role":"client",
+		"log":"skip",
+		"uuid":"00000000-0000-0000-0000-000000000001",
+		"outbounds":[
+			{"name":"corp","type":"forward","url":"http://127.0.0.1:3128"},
+			{"name":"lan","type":"direct"},
+			{"name":"eu","type":"spaceship","servers":[{"addr":"127.0.0.1:1"}]}
+		],
+		"route":[
+			{"src":["intranet.example"],"dst":"corp","type":"exact"},
+			{"src":["printer.example"],"dst":"lan","type":"exact"},
+			{"dst":"eu","type":"default"}
+		]
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	closeOutbounds, err := cfg.SetupOutbounds()
+	if err != nil {
+		t.Fatalf("SetupOutbounds() error = %v", err)
+	}
+	t.Cleanup(closeOutbounds)
+
+	for host, want := range map[string]string{
+		"intranet.example": "forward",
+		"printer.example":  "direct",
+		"other.example":    "rpc",
+	} {
+		tr, err := router.GetRoute(host, 443)
+		if err != nil {
+			t.Fatalf("GetRoute(%s) error = %v", host, err)
+		}
+		if tr.String() != want {
+			t.Errorf("GetRoute(%s) = %s, want %s", host, tr, want)
+		}
+		_ = tr.Close()
+	}
+	if !router.AnyRouteSupportsUDP() {
+		t.Error("AnyRouteSupportsUDP() = false with direct and spaceship outbounds")
+	}
+}
+
+func TestApply_RejectsBadOutbounds(t *testing.T) {
+	t.Cleanup(transport.EnableIPv6)
+
+	tests := []struct {
+		name      string
+		outbounds string
+		dst       string
+		wantErr   string
+	}{
+		{"unknown destination", `[]`, "nowhere", "unknown destination"},
+		{"reserved name", `[{"name":"proxy","type":"direct"}]`, "direct", "reserved"},
+		{"duplicate name", `[{"name":"a","type":"direct"},{"name":"a","type":"direct"}]`, "a", "duplicated"},
+		{"unknown type", `[{"name":"a","type":"vpn"}]`, "a", "unknown type"},
+		{"forward without url", `[{"name":"a","type":"forward"}]`, "a", "url empty"},
+		{"spaceship without servers", `[{"name":"a","type":"spaceship"}]`, "a", "servers empty"},
+		{"bad balance", `[{"name":"a","type":"spaceship","servers":[{"addr":"127.0.0.1:1"}],"balance":"random"}]`, "a", "balance"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := NewFromString(`{"role":"client","log":"skip","uuid":"u",
+				"outbounds":` + tt.outbounds + `,
+				"route":[{"dst":"` + tt.dst + `","type":"default"}]}`)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err = cfg.Apply(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Apply() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestApply_RejectsUnknownUserForward(t *testing.T) {
+	t.Cleanup(transport.EnableIPv6)
+
+	cfg, err := NewFromString(`{"role":"server","log":"skip","listen":"127.0.0.1:0",
+		"outbounds":[{"name":"eu-exit","type":"forward","url":"socks5://127.0.0.1:1080"}],
+		"users":[{"uuid":"a","forward":"eu-exit"},{"uuid":"b","forward":"us-exit"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Apply(); err == nil || !strings.Contains(err.Error(), "us-exit") {
+		t.Fatalf("Apply() error = %v, want unknown forward us-exit", err)
+	}
+}
+
+func TestApply_ServerPolicies(t *testing.T) {
+	t.Cleanup(transport.EnableIPv6)
+	t.Cleanup(func() { _ = router.SetPolicies(nil) })
+
+	const base = `{"role":"server","log":"skip","listen":"127.0.0.1:0",
+		"policies":{"guest":[{"src":["10.0.0.0/8"],"dst":"block","type":"cidr"}]},`
+	cfg, err := NewFromString(base + `"users":[{"uuid":"a","policy":"guest"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if _, ok := router.GetPolicy("guest"); !ok {
+		t.Fatal("Apply() did not install the guest policy")
+	}
+
+	for users, wantErr := range map[string]string{
+		`[{"uuid":"a","policy":"staff"}]`:                             "unknown policy",
+		`[{"uuid":"a","route":[{"dst":"nowhere","type":"default"}]}]`: "unknown destination",
+	} {
+		cfg, err = NewFromString(base + `"users":` + users + `}`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = cfg.Apply(); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("users %s: Apply() error = %v, want %q", users, err, wantErr)
+		}
+	}
+}
+
+func TestApply_BadUserRoutesSwapNothing(t *testing.T) {
+	t.Cleanup(transport.EnableIPv6)
+	t.Cleanup(func() { _ = router.SetPolicies(nil) })
+
+	cfg, err := NewFromString(`{"role":"server","log":"skip","listen":"127.0.0.1:0",
+		"route":[{"src":["example.com"],"dst":"block","type":"exact"},{"dst":"direct","type":"default"}],
+		"policies":{"guest":[{"dst":"block","type":"default"}]},
+		"users":[{"uuid":"a"}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.Apply(); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+
+	next, err := NewFromString(`{"role":"server","log":"skip","listen":"127.0.0.1:0",
+		"route":[{"dst":"direct","type":"default"}],
+		"users":[{"uuid":"a","route":[{"src":["not-a-cidr"],"dst":"block","type":"cidr"}]}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = next.Apply(); err == nil || !strings.Contains(err.Error(), "user a") {
+		t.Fatalf("Apply() error = %v, want the bad route of user a", err)
+	}
+	if egress, err := router.GetEgress("example.com", 443); err != nil || egress != router.EgressBlock {
+		t.Errorf("GetEgress(example.com, 443) = %s, %v after a failed Apply(), want block", egress, err)
+	}
+	if _, ok := router.GetPolicy("guest"); !ok {
+		t.Error("failed Apply() dropped the guest policy")
+	}
+}
+
+func TestApply_RejectsNestedRuleSets(t *testing.T) {
+	t.Cleanup(transport.EnableIPv6)
+	t.Cleanup(func() { _ = router.SetPolicies(nil) })
+
+	const ruleSet = `[{"url":"https://example.com/ads.list","dst":"block","type":"domain"}]`
+	for name, config := range map[string]string{
+		"policy": `"policies":{"guest":` + ruleSet + `},"users":[{"uuid":"a"}]}`,
+		"user":   `"users":[{"uuid":"a","route":` + ruleSet + `}]}`,
+	} {
+		cfg, err := NewFromString(`{"role":"server","log":"skip","listen":"127.0.0.1:0",` + config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = cfg.Apply(); err == nil || !strings.Contains(err.Error(), "top-level route") {
+			t.Errorf("%s: Apply() error = %v, want url rule sets rejected", name, err)
+		}
+	}
+}
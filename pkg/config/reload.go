@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+
+	"github.com/SuzukiHonoka/spaceship/v2/pkg/config/server"
+)
+
+// Changes reports the outcome of a reload. Fields are named by their JSON keys.
+type Changes struct {
+	// Applied lists the fields that changed and took effect.
+	Applied []string `json:"applied"`
+	// RestartRequired lists the fields that changed but only take effect on the
+	// next launch, such as listeners and the upstream connection.
+	RestartRequired []string `json:"restart_required,omitempty"`
+	UsersAdded      []string `json:"users_added,omitempty"`
+	UsersRemoved    []string `json:"users_removed,omitempty"`
+}
+
+type reloadField struct {
+	name    string
+	running any // pointer into the running config
+	next    any // pointer into the new config
+}
+
+// PrepareReload compares next against the running config c before next is
+// applied. Fields that cannot change while running are reported as requiring
+// a restart and reset to their running value in next, so applying next leaves
+// them untouched. Changing the role is rejected outright.
+func (c *MixedConfig) PrepareReload(next *MixedConfig) (*Changes, error) {
+	c.ensureEmbeddedConfigs()
+	next.ensureEmbeddedConfigs()
+	if next.Role != c.Role {
+		return nil, fmt.Errorf("role can not be changed by reload: %s -> %s", c.Role, next.Role)
+	}
+
+	changes := &Changes{Applied: []string{}}
+
+	// bound by listeners, the rpc pool or process-wide resolvers at launch
+	for _, f := range []reloadField{
+		{"dns", &c.DNS, &next.DNS},
+		{"cas", &c.CAs, &next.CAs},
+		{"forward", &c.Forward, &next.Forward},
+		{"outbounds", &c.Outbounds, &next.Outbounds},
+		{"path", &c.Path, &next.Path},
+		{"listen", &c.Listen, &next.Listen},
+		{"ssl", &c.SSL, &next.SSL},
+		{"state_file", &c.StateFile, &next.StateFile},
+		{"server_addr", &c.ServerAddr, &next.ServerAddr},
+		{"host", &c.Host, &next.Host},
+		{"servers", &c.Servers, &next.Servers},
+		{"balance", &c.Balance, &next.Balance},
+		{"upstream_proxy", &c.UpstreamProxy, &next.UpstreamProxy},
+		{"tls", &c.EnableTLS, &next.EnableTLS},
+		{"mux", &c.Mux, &next.Mux},
+		{"listen_socks", &c.ListenSocks, &next.ListenSocks},
+		{"listen_socks_unix", &c.ListenSocksUnix, &next.ListenSocksUnix},
+		{"listen_http", &c.ListenHttp, &next.ListenHttp},
+		{"listen_dns", &c.ListenDns, &next.ListenDns},
+		{"listen_redir", &c.ListenRedir, &next.ListenRedir},
+		{"listen_tproxy", &c.ListenTProxy, &next.ListenTProxy},
+		{"tun", &c.Tun, &next.Tun},
+		{"block_ipv6_dns", &c.BlockIPv6DNS, &next.BlockIPv6DNS},
+		{"dns_cache", &c.DNSCache, &next.DNSCache},
+		{"dns_direct", &c.DNSDirect, &next.DNSDirect},
+		{"dns_block", &c.DNSBlock, &next.DNSBlock},
+		{"fake_ip", &c.FakeIP, &next.FakeIP},
+		{"basic_auth", &c.BasicAuth, &next.BasicAuth},
+	} {
+		running, updated := reflect.ValueOf(f.running).Elem(), reflect.ValueOf(f.next).Elem()
+		if !reflect.DeepEqual(running.Interface(), updated.Interface()) {
+			changes.RestartRequired = append(changes.RestartRequired, f.name)
+			updated.Set(running)
+		}
+	}
+
+	for _, f := range []reloadField{
+		{"log", &c.LogMode, &next.LogMode},
+		{"buffer", &c.Buffer, &next.Buffer},
+		{"ipv6", &c.IPv6, &next.IPv6},
+		{"idle_timeout", &c.IdleTimeout, &next.IdleTimeout},
+		{"udp", &c.UDP, &next.UDP},
+		{"route", &c.Routes, &next.Routes},
+		{"uuid", &c.UUID, &next.UUID},
+		{"users", &c.Users, &next.Users},
+		{"policies", &c.Policies, &next.Policies},
+		{"guard", &c.Guard, &next.Guard},
+	} {
+		if !reflect.DeepEqual(reflect.ValueOf(f.running).Elem().Interface(), reflect.ValueOf(f.next).Elem().Interface()) {
+			changes.Applied = append(changes.Applied, f.name)
+		}
+	}
+
+	running, updated := userIDs(c.Users), userIDs(next.Users)
+	for _, uid := range updated {
+		if !slices.Contains(running, uid) {
+			changes.UsersAdded = append(changes.UsersAdded, uid)
+		}
+	}
+	for _, uid := range running {
+		if !slices.Contains(updated, uid) {
+			changes.UsersRemoved = append(changes.UsersRemoved, uid)
+		}
+	}
+	return changes, nil
+}
+
+func userIDs(users server.Users) []string {
+	ids := make([]string, 0, len(users))
+	for _, user := range users {
+		if user != nil {
+			ids = append(ids, user.UUID)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
@@ -1,5 +1,7 @@
 package server
 
+import "github.com/SuzukiHonoka/spaceship/v2/internal/router"
+
 type Server struct {
 	Listen  string `json:"listen"`
 	SSL     *SSL   `json:"ssl,omitempty"`
@@ -8,4 +10,13 @@ type Server struct {
 	Buffer  uint16 `json:"buffer,omitempty"`  // transport buffer size in KB, up to 65535
 	IPv6    bool   `json:"ipv6,omitempty"`    // enable ipv6 in tcp network, disable by default
 	Forward string `json:"forward,omitempty"` // extra forward-proxy
+	// StateFile persists per-user traffic counters across restarts. Without it
+	// the counters, and therefore quotas, start from zero on every launch.
+	StateFile string `json:"state_file,omitempty"`
+	// Policies are route lists users refer to by name, so that kinds of users
+	// share their routing, e.g. guests kept off private networks.
+	Policies map[string]router.Routes `json:"policies,omitempty"`
+	// Guard keeps users off the server's own network when routed direct.
+	// It is on unless disabled.
+	Guard *Guard `json:"guard,omitempty"`
 }
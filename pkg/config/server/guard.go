@@ -0,0 +1,12 @@
+package server
+
+// Guard sets which destinations direct egress refuses. Loopback, private,
+// shared, link-local and cloud metadata addresses are denied by default.
+// Entries are CIDR prefixes or single addresses.
+type Guard struct {
+	Disable bool `json:"disable,omitempty"`
+	// Allow overrides the denied ranges, e.g. for a service on the LAN.
+	Allow []string `json:"allow,omitempty"`
+	// Deny adds ranges to the default ones.
+	Deny []string `json:"deny,omitempty"`
+}
@@ -1,7 +1,20 @@
 package server
 
+// Limit caps a user's throughput in KB/s (1K == 1024 Byte), matching the unit
+// of Buffer. Every field is optional; zero means unlimited.
+//
+// The caps are shared by all of the user's concurrent streams, so opening more
+// connections does not buy more bandwidth.
 type Limit struct {
-	DownLink  uint
-	UpLink    uint
-	Bandwidth uint
+	// DownLink caps traffic from the target back to the client.
+	DownLink uint `json:"downlink,omitempty"`
+	// UpLink caps traffic from the client to the target.
+	UpLink uint `json:"uplink,omitempty"`
+	// Bandwidth caps both directions combined, on top of DownLink and UpLink.
+	Bandwidth uint `json:"bandwidth,omitempty"`
+}
+
+// Unlimited reports whether l imposes no cap at all.
+func (l *Limit) Unlimited() bool {
+	return l == nil || (l.DownLink == 0 && l.UpLink == 0 && l.Bandwidth == 0)
 }
@@ -1,9 +1,22 @@
 package server
 
+import "github.com/SuzukiHonoka/spaceship/v2/internal/router"
+
 type User struct {
 	UUID   string `json:"uuid"` // user id
 	Limit  *Limit `json:"limit,omitempty"`
 	Remark string `json:"remark,omitempty"`
+	// Quota is the monthly traffic allowance in MB (1M == 1024K), both
+	// directions combined. Once used up, new requests of the user are rejected
+	// until the next calendar month (UTC). Zero disables the quota.
+	Quota uint64 `json:"quota,omitempty"`
+	// Forward names the outbound that carries the traffic of the user routed
+	// to forward, so users can exit through different proxies.
+	Forward string `json:"forward,omitempty"`
+	// Routes are consulted before those of Policy, a name among the server
+	// policies, and both before the shared route table.
+	Routes router.Routes `json:"route,omitempty"`
+	Policy string        `json:"policy,omitempty"`
 }
 
 type Users []*User
@@ -0,0 +1,201 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	mdns "github.com/miekg/dns"
+)
+
+// dohMediaType is the content type of a DOH query and answer (RFC 8484).
+const dohMediaType = "application/dns-message"
+
+// maxDOHResponse bounds a DOH answer; a DNS message can not exceed 64K.
+const maxDOHResponse = 64 << 10
+
+// Client exchanges DNS messages with an upstream. It is safe for concurrent use.
+type Client interface {
+	Exchange(ctx context.Context, m *mdns.Msg) (*mdns.Msg, error)
+}
+
+// NewClient returns a Client for the upstream. Upstream host names are looked
+// up with the system configuration rather than net.DefaultResolver, which may
+// be this very upstream once SetDefault has run.
+func (s *DNS) NewClient() (Client, error) {
+	switch s.Type {
+	case TypeDefault, TypeCommon:
+		return &plainClient{addr: s.Address()}, nil
+	case TypeDOT:
+		addr, err := s.dialAddress()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		return &dotClient{
+			addr: addr,
+			client: &mdns.Client{
+				Net:       "tcp-tls",
+				TLSConfig: tlsConfig,
+				Dialer:    newDialer(),
+				Timeout:   DefaultTimeout,
+			},
+		}, nil
+	case TypeDOH:
+		addr, err := s.dialAddress()
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig, err := s.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		dialer := newDialer()
+		return &dohClient{
+			url: s.Server,
+			client: &http.Client{
+				Timeout: DefaultTimeout,
+				Transport: &http.Transport{
+					// every request goes to the one upstream, so the url host
+					// only matters for the Host header and certificate
+					DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+						return dialer.DialContext(ctx, network, addr)
+					},
+					TLSClientConfig:   tlsConfig,
+					ForceAttemptHTTP2: true,
+				},
+			},
+		}, nil
+	}
+	return nil, fmt.Errorf("dns: type %s not implemented", s.Type)
+}
+
+func newDialer() *net.Dialer {
+	return &net.Dialer{
+		Timeout:  DefaultTimeout,
+		Resolver: &net.Resolver{},
+	}
+}
+
+// tlsConfig verifies the upstream against ServerName, or the host in Server,
+// trusting CA on top of the system roots.
+func (s *DNS) tlsConfig() (*tls.Config, error) {
+	serverName := s.ServerName
+	if serverName == "" {
+		if s.Type == TypeDOH {
+			u, err := url.Parse(s.Server)
+			if err != nil {
+				return nil, fmt.Errorf("dns: invalid doh url: %w", err)
+			}
+			serverName = u.Hostname()
+		} else if host, _, err := net.SplitHostPort(s.Server); err == nil {
+			serverName = host
+		} else {
+			serverName = s.Server
+		}
+	}
+	config := &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if s.CA == "" {
+		return config, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	b, err := os.ReadFile(filepath.Clean(s.CA))
+	if err != nil {
+		return nil, fmt.Errorf("dns: read ca: %w", err)
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("dns: no certificate found in %s", s.CA)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// plainClient queries over UDP and retries over TCP when the answer was
+// truncated.
+type plainClient struct {
+	addr string
+}
+
+func (c *plainClient) Exchange(ctx context.Context, m *mdns.Msg) (*mdns.Msg, error) {
+	client := &mdns.Client{Timeout: DefaultTimeout}
+	r, _, err := client.ExchangeContext(ctx, m, c.addr)
+	if err == nil && r.Truncated {
+		client.Net = "tcp"
+		r, _, err = client.ExchangeContext(ctx, m, c.addr)
+	}
+	return r, err
+}
+
+type dotClient struct {
+	addr   string
+	client *mdns.Client
+}
+
+func (c *dotClient) Exchange(ctx context.Context, m *mdns.Msg) (*mdns.Msg, error) {
+	r, _, err := c.client.ExchangeContext(ctx, m, c.addr)
+	return r, err
+}
+
+type dohClient struct {
+	url    string
+	client *http.Client
+}
+
+// Exchange posts the query as RFC 8484 prescribes, with the message id zeroed
+// so answers are cacheable, and restores the id on the answer.
+func (c *dohClient) Exchange(ctx context.Context, m *mdns.Msg) (*mdns.Msg, error) {
+	q := m.Copy()
+	q.Id = 0
+	b, err := q.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh: unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxDOHResponse+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxDOHResponse {
+		return nil, errors.New("doh: response too large")
+	}
+	r := new(mdns.Msg)
+	if err = r.Unpack(body); err != nil {
+		return nil, fmt.Errorf("doh: %w", err)
+	}
+	r.Id = m.Id
+	return r, nil
+}
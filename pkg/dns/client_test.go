@@ -0,0 +1,242 @@
+package dns
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+const testServerName = "resolver.test"
+
+// testCert issues a self-signed certificate for 127.0.0.1 and testServerName
+// and writes it to a PEM file usable as DNS.CA.
+func testCert(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: testServerName},
+		DNSNames:              []string{testServerName},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, path
+}
+
+// answerKnown answers A queries for known.test. and NXDOMAIN otherwise.
+func answerKnown(r *mdns.Msg) *mdns.Msg {
+	m := new(mdns.Msg)
+	m.SetReply(r)
+	if q := r.Question[0]; q.Name == "known.test." && q.Qtype == mdns.TypeA {
+		rr, _ := mdns.NewRR("known.test. 60 IN A 192.0.2.7")
+		m.Answer = append(m.Answer, rr)
+	} else {
+		m.Rcode = mdns.RcodeNameError
+	}
+	return m
+}
+
+func startDOTServer(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &mdns.Server{
+		Listener: ln,
+		Net:      "tcp-tls",
+		Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, r *mdns.Msg) {
+			_ = w.WriteMsg(answerKnown(r))
+		}),
+	}
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = srv.ActivateAndServe() }()
+	t.Cleanup(func() { _ = srv.Shutdown() })
+	<-started
+	return ln.Addr().String()
+}
+
+func startDOHServer(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohMediaType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		q := new(mdns.Msg)
+		if err := q.Unpack(b); err != nil || q.Id != 0 {
+			http.Error(w, "bad query", http.StatusBadRequest)
+			return
+		}
+		out, _ := answerKnown(q).Pack()
+		w.Header().Set("Content-Type", dohMediaType)
+		_, _ = w.Write(out)
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv.Listener.Addr().String()
+}
+
+func exchangeKnown(t *testing.T, d *DNS) (*mdns.Msg, error) {
+	t.Helper()
+	c, err := d.NewClient()
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	m := new(mdns.Msg)
+	m.SetQuestion("known.test.", mdns.TypeA)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return c.Exchange(ctx, m)
+}
+
+func wantKnown(t *testing.T, r *mdns.Msg, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	if len(r.Answer) != 1 || r.Answer[0].(*mdns.A).A.String() != "192.0.2.7" {
+		t.Fatalf("answer = %v, want known.test. A 192.0.2.7", r.Answer)
+	}
+}
+
+func TestDOTClient(t *testing.T) {
+	cert, ca := testCert(t)
+	addr := startDOTServer(t, cert)
+	_, port, _ := net.SplitHostPort(addr)
+
+	t.Run("ip upstream", func(t *testing.T) {
+		r, err := exchangeKnown(t, &DNS{Type: TypeDOT, Server: addr, CA: ca})
+		wantKnown(t, r, err)
+	})
+	t.Run("named upstream through bootstrap", func(t *testing.T) {
+		r, err := exchangeKnown(t, &DNS{
+			Type:      TypeDOT,
+			Server:    net.JoinHostPort(testServerName, port),
+			Bootstrap: "127.0.0.1",
+			CA:        ca,
+		})
+		wantKnown(t, r, err)
+	})
+	t.Run("untrusted certificate", func(t *testing.T) {
+		if _, err := exchangeKnown(t, &DNS{Type: TypeDOT, Server: addr}); err == nil {
+			t.Fatal("Exchange() trusted a self-signed upstream without CA")
+		}
+	})
+	t.Run("server name mismatch", func(t *testing.T) {
+		_, err := exchangeKnown(t, &DNS{Type: TypeDOT, Server: addr, ServerName: "other.test", CA: ca})
+		if err == nil {
+			t.Fatal("Exchange() accepted a certificate for another name")
+		}
+	})
+}
+
+func TestDOHClient(t *testing.T) {
+	cert, ca := testCert(t)
+	addr := startDOHServer(t, cert)
+	_, port, _ := net.SplitHostPort(addr)
+
+	d := &DNS{
+		Type:      TypeDOH,
+		Server:    "https://" + net.JoinHostPort(testServerName, port) + "/dns-query",
+		Bootstrap: "127.0.0.1",
+		CA:        ca,
+	}
+	r, err := exchangeKnown(t, d)
+	wantKnown(t, r, err)
+}
+
+func TestNewClientRejectsBadConfig(t *testing.T) {
+	for _, d := range []*DNS{
+		{Type: TypeDOH, Server: "http://resolver.test/dns-query"},
+		{Type: TypeDOH, Server: "https:///dns-query"},
+		{Type: TypeDOT, Server: "resolver.test", Bootstrap: "not-an-ip"},
+		{Type: TypeDOT, Server: "resolver.test", CA: "/nonexistent/ca.pem"},
+		{Type: "doq", Server: "resolver.test"},
+	} {
+		if _, err := d.NewClient(); err == nil {
+			t.Errorf("NewClient(%+v) accepted a bad config", d)
+		}
+	}
+}
+
+func TestSetDefaultDOH(t *testing.T) {
+	prev := net.DefaultResolver
+	t.Cleanup(func() { net.DefaultResolver = prev })
+
+	cert, ca := testCert(t)
+	addr := startDOHServer(t, cert)
+	d := &DNS{Type: TypeDOH, Server: "https://" + addr + "/dns-query", CA: ca}
+	if err := d.SetDefault(); err != nil {
+		t.Fatalf("SetDefault() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", "known.test")
+	if err != nil {
+		t.Fatalf("LookupIP() error = %v", err)
+	}
+	if len(ips) != 1 || ips[0].String() != "192.0.2.7" {
+		t.Fatalf("LookupIP() = %v, want [192.0.2.7]", ips)
+	}
+	if _, err = net.DefaultResolver.LookupIP(ctx, "ip4", "missing.test"); err == nil {
+		t.Fatal("LookupIP() resolved a name the upstream answers NXDOMAIN for")
+	}
+}
+
+func TestSetDefaultDOT(t *testing.T) {
+	prev := net.DefaultResolver
+	t.Cleanup(func() { net.DefaultResolver = prev })
+
+	cert, ca := testCert(t)
+	d := &DNS{Type: TypeDOT, Server: startDOTServer(t, cert), CA: ca}
+	if err := d.SetDefault(); err != nil {
+		t.Fatalf("SetDefault() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", "known.test")
+	if err != nil {
+		t.Fatalf("LookupIP() error = %v", err)
+	}
+	if len(ips) != 1 || ips[0].String() != "192.0.2.7" {
+		t.Fatalf("LookupIP() = %v, want [192.0.2.7]", ips)
+	}
+}
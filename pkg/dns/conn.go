@@ -0,0 +1,161 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// exchangeConn lets the Go resolver talk to a Client. The resolver treats a
+// conn that is not a net.PacketConn as TCP and frames every message with a
+// two-byte length, so Write collects framed queries, exchanges them, and
+// queues the framed answers for Read.
+type exchangeConn struct {
+	client Client
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	in       bytes.Buffer // partial queries
+	out      bytes.Buffer // framed answers
+	err      error        // exchange failure, reported by Read
+	ready    chan struct{}
+	deadline time.Time
+}
+
+func newExchangeConn(c Client) *exchangeConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &exchangeConn{client: c, ctx: ctx, cancel: cancel, ready: make(chan struct{}, 1)}
+}
+
+func (c *exchangeConn) Write(p []byte) (int, error) {
+	if c.ctx.Err() != nil {
+		return 0, net.ErrClosed
+	}
+	c.mu.Lock()
+	c.in.Write(p)
+	var queries [][]byte
+	for c.in.Len() >= 2 {
+		n := int(binary.BigEndian.Uint16(c.in.Bytes()))
+		if c.in.Len() < 2+n {
+			break
+		}
+		c.in.Next(2)
+		queries = append(queries, bytes.Clone(c.in.Next(n)))
+	}
+	deadline := c.deadline
+	c.mu.Unlock()
+
+	for _, q := range queries {
+		go c.exchange(q, deadline)
+	}
+	return len(p), nil
+}
+
+func (c *exchangeConn) exchange(query []byte, deadline time.Time) {
+	ctx := c.ctx
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+
+	m := new(mdns.Msg)
+	answer, err := func() ([]byte, error) {
+		if err := m.Unpack(query); err != nil {
+			return nil, err
+		}
+		r, err := c.client.Exchange(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		return r.Pack()
+	}()
+
+	c.mu.Lock()
+	if err != nil {
+		c.err = err
+	} else {
+		var length [2]byte
+		binary.BigEndian.PutUint16(length[:], uint16(len(answer)))
+		c.out.Write(length[:])
+		c.out.Write(answer)
+	}
+	c.mu.Unlock()
+	select {
+	case c.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (c *exchangeConn) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if c.out.Len() > 0 {
+			n, _ := c.out.Read(p)
+			c.mu.Unlock()
+			return n, nil
+		}
+		if err := c.err; err != nil {
+			c.err = nil
+			c.mu.Unlock()
+			return 0, err
+		}
+		deadline := c.deadline
+		c.mu.Unlock()
+
+		if err := c.wait(deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// wait blocks until an exchange finished, the deadline passed or the conn
+// was closed.
+func (c *exchangeConn) wait(deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-c.ready:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	case <-c.ctx.Done():
+		return io.EOF
+	}
+}
+
+func (c *exchangeConn) Close() error {
+	c.cancel()
+	return nil
+}
+
+func (c *exchangeConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *exchangeConn) SetReadDeadline(t time.Time) error  { return c.SetDeadline(t) }
+func (c *exchangeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (c *exchangeConn) LocalAddr() net.Addr  { return exchangeAddr{} }
+func (c *exchangeConn) RemoteAddr() net.Addr { return exchangeAddr{} }
+
+type exchangeAddr struct{}
+
+func (exchangeAddr) Network() string { return "exchange" }
+func (exchangeAddr) String() string  { return "exchange" }
@@ -4,14 +4,24 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"net/url"
 	"time"
 )
 
 var DefaultTimeout = 3 * time.Second
 
 type DNS struct {
-	Type
-	Server string
+	Type   `json:"type"`
+	Server string `json:"server"`
+	// ServerName overrides the name the TLS certificate of a DOT/DOH upstream
+	// is verified against; it defaults to the host in Server.
+	ServerName string `json:"server_name,omitempty"`
+	// Bootstrap is the IP to connect to instead of resolving the host in
+	// Server, which the system resolver may not be able to do, or may only do
+	// in plaintext.
+	Bootstrap string `json:"bootstrap,omitempty"`
+	// CA is a PEM file trusted in addition to the system roots for DOT/DOH.
+	CA string `json:"ca,omitempty"`
 }
 
 func (s *DNS) String() string {
@@ -21,24 +31,77 @@ func (s *DNS) String() string {
 // Address returns the resolver endpoint to query.
 //
 // Server may be a bare host ("1.1.1.1", "::1", "resolver.example") or already
-// carry a port ("127.0.0.1:5353"). Bare hosts get the standard port 53 appended;
-// anything that already parses as host:port is used verbatim, so a resolver on a
-// non-standard port — a local stub resolver, a container sidecar — is reachable.
+// carry a port ("127.0.0.1:5353"). Bare hosts get the standard port appended,
+// 53 for plain DNS and 853 for DOT; anything that already parses as host:port
+// is used verbatim, so a resolver on a non-standard port — a local stub
+// resolver, a container sidecar — is reachable. For DOH the endpoint is the
+// query URL itself.
 func (s *DNS) Address() string {
 	switch s.Type {
 	case TypeDefault, TypeCommon:
-		if _, _, err := net.SplitHostPort(s.Server); err == nil {
-			return s.Server
-		}
-		return net.JoinHostPort(s.Server, "53")
+		return withDefaultPort(s.Server, "53")
+	case TypeDOT:
+		return withDefaultPort(s.Server, "853")
+	case TypeDOH:
+		return s.Server
 	}
 	return ""
 }
 
+func withDefaultPort(server, port string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, port)
+}
+
+// dialAddress returns the host:port to connect to, with Bootstrap, if set,
+// standing in for the host.
+func (s *DNS) dialAddress() (string, error) {
+	var hostport string
+	switch s.Type {
+	case TypeDOH:
+		u, err := url.Parse(s.Server)
+		if err != nil {
+			return "", fmt.Errorf("dns: invalid doh url: %w", err)
+		}
+		if u.Scheme != "https" || u.Host == "" {
+			return "", fmt.Errorf("dns: doh url must be https://host/path, got %q", s.Server)
+		}
+		hostport = withDefaultPort(u.Host, "443")
+	default:
+		hostport = s.Address()
+	}
+	if s.Bootstrap == "" {
+		return hostport, nil
+	}
+	if net.ParseIP(s.Bootstrap) == nil {
+		return "", fmt.Errorf("dns: bootstrap must be an ip address, got %q", s.Bootstrap)
+	}
+	_, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(s.Bootstrap, port), nil
+}
+
+// SetDefault makes the upstream the resolver of net.DefaultResolver.
 func (s *DNS) SetDefault() error {
+	r, err := s.NewResolver()
+	if err != nil {
+		return err
+	}
+	net.DefaultResolver = r
+	return nil
+}
+
+// NewResolver returns a resolver querying the upstream. DOT and DOH queries
+// are handed to a Client through a conn that speaks the length-prefixed TCP
+// framing the Go resolver uses on stream connections.
+func (s *DNS) NewResolver() (*net.Resolver, error) {
 	switch s.Type {
 	case TypeDefault, TypeCommon:
-		net.DefaultResolver = &net.Resolver{
+		return &net.Resolver{
 			PreferGo: true,
 			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
 				d := net.Dialer{
@@ -46,9 +109,19 @@ func (s *DNS) SetDefault() error {
 				}
 				return d.DialContext(ctx, network, s.Address())
 			},
+		}, nil
+	case TypeDOT, TypeDOH:
+		c, err := s.NewClient()
+		if err != nil {
+			return nil, err
 		}
+		return &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return newExchangeConn(c), nil
+			},
+		}, nil
 	default:
-		return fmt.Errorf("dns: type %s not implemented, abort setting default", s.Type)
+		return nil, fmt.Errorf("dns: type %s not implemented, abort setting default", s.Type)
 	}
-	return nil
 }
@@ -41,10 +41,20 @@ func TestDNSAddress(t *testing.T) {
 			want: "8.8.8.8:53",
 		},
 		{
-			name:   "unimplemented types have no address",
-			dns:    DNS{Type: TypeDOT, Server: "1.1.1.1"},
+			name: "bare dot host gets the dot port",
+			dns:  DNS{Type: TypeDOT, Server: "1.1.1.1"},
+			want: "1.1.1.1:853",
+		},
+		{
+			name: "doh address is the query url",
+			dns:  DNS{Type: TypeDOH, Server: "https://resolver.example/dns-query"},
+			want: "https://resolver.example/dns-query",
+		},
+		{
+			name:   "unknown types have no address",
+			dns:    DNS{Type: "doq", Server: "1.1.1.1"},
 			want:   "",
-			reason: "DOT/DOH are not wired up; an address here would silently query plaintext",
+			reason: "an address here would silently query an unsupported protocol",
 		},
 	}
 
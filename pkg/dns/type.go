@@ -4,7 +4,7 @@ type Type string
 
 const (
 	TypeDefault Type = ""
-	TypeCommon  Type = "common" // only supported now
-	TypeDOT     Type = "dot"
-	TypeDOH     Type = "doh"
+	TypeCommon  Type = "common" // plain udp, tcp on truncation
+	TypeDOT     Type = "dot"    // dns over tls (RFC 7858)
+	TypeDOH     Type = "doh"    // dns over https (RFC 8484)
 )